@@ -0,0 +1,439 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:34
+ * Comment: 告警规则，每个[alert.名字]配置段是一条规则，例如：
+ *          Expr = loss > 2% over 1m
+ *          Expr = p99 > 80ms over 1m
+ *          告警在触发(firing)和恢复(resolved)两个状态之间切换，切换时发送通知
+ */
+
+package main
+
+import (
+	"fmt"
+	"gopkg.in/ini.v1"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const alertSectionPrefix = "alert."
+
+type AlertConfig struct {
+	Name string `ini:"-"`
+
+	// 规则：指标 比较符 阈值 over 窗口，窗口可以是1m, 5m, 1h，省略时为1m
+	Expr string
+
+	// 严重程度：info, warning, critical
+	Severity string
+
+	// 条件持续多久才触发，例如3m，默认0（马上触发）
+	For string
+
+	// 回差，触发后要越过 阈值∓Hysteresis 才算恢复，防止在阈值附近来回抖动
+	Hysteresis float64
+
+	// 同一个目标的同一条规则，两次触发通知之间至少间隔多久，默认10m
+	Cooldown string
+
+	// 只对这些目标生效（逗号分隔），为空时对所有目标生效
+	Targets string
+}
+
+// 没有配置任何规则时使用的默认规则
+var defaultAlertConfigs = []*AlertConfig{
+	{Name: "loss", Expr: "loss > 2% over 1m", Severity: "warning"},
+	{Name: "down", Expr: "silence > 5 over 1m", Severity: "critical"},
+	{Name: "corrupt", Expr: "corrupt > 0 over 5m", Severity: "warning"},
+	// 只在汇总服务器上有意义，节点超过一分钟没有推送
+	{Name: "stale", Expr: "age > 60 over 1m", Severity: "critical"},
+}
+
+// 规则可以使用的指标
+var alertMetrics = map[string]func(s *statsSnapshot, w windowSnapshot) (float64, bool){
+	"loss": func(s *statsSnapshot, w windowSnapshot) (float64, bool) {
+		return w.Counts.LossPercent(), w.Counts.Received+w.Counts.Lost > 0
+	},
+	"avg": func(s *statsSnapshot, w windowSnapshot) (float64, bool) { return w.Latency.Avg, w.Latency.Count > 0 },
+	"p50": func(s *statsSnapshot, w windowSnapshot) (float64, bool) {
+		return float64(w.Latency.P50), w.Latency.Count > 0
+	},
+	"p90": func(s *statsSnapshot, w windowSnapshot) (float64, bool) {
+		return float64(w.Latency.P90), w.Latency.Count > 0
+	},
+	"p99": func(s *statsSnapshot, w windowSnapshot) (float64, bool) {
+		return float64(w.Latency.P99), w.Latency.Count > 0
+	},
+	"p999": func(s *statsSnapshot, w windowSnapshot) (float64, bool) {
+		return float64(w.Latency.P999), w.Latency.Count > 0
+	},
+	"max": func(s *statsSnapshot, w windowSnapshot) (float64, bool) {
+		return float64(w.Latency.Max), w.Latency.Count > 0
+	},
+	"stddev": func(s *statsSnapshot, w windowSnapshot) (float64, bool) { return w.Latency.StdDev, w.Latency.Count > 0 },
+	"jitter": func(s *statsSnapshot, w windowSnapshot) (float64, bool) { return s.Jitter, w.Latency.Count > 0 },
+	"forward": func(s *statsSnapshot, w windowSnapshot) (float64, bool) {
+		return float64(w.Forward.P99), w.Forward.Count > 0
+	},
+	"reverse": func(s *statsSnapshot, w windowSnapshot) (float64, bool) {
+		return float64(w.Reverse.P99), w.Reverse.Count > 0
+	},
+	"late":       func(s *statsSnapshot, w windowSnapshot) (float64, bool) { return float64(w.Counts.Late), true },
+	"duplicate":  func(s *statsSnapshot, w windowSnapshot) (float64, bool) { return float64(w.Counts.Duplicate), true },
+	"reorder":    func(s *statsSnapshot, w windowSnapshot) (float64, bool) { return float64(w.Counts.Reorder), true },
+	"corrupt":    func(s *statsSnapshot, w windowSnapshot) (float64, bool) { return float64(w.Counts.Corrupt), true },
+	"unsent":     func(s *statsSnapshot, w windowSnapshot) (float64, bool) { return float64(w.Counts.Unsent), true },
+	"silence":    func(s *statsSnapshot, w windowSnapshot) (float64, bool) { return float64(w.Counts.Silence), true },
+	"disconnect": func(s *statsSnapshot, w windowSnapshot) (float64, bool) { return float64(w.Counts.Disconnect), true },
+	// 快照是多少秒之前的
+	"age": func(s *statsSnapshot, w windowSnapshot) (float64, bool) { return float64(TimeNowMs()-s.Time) / 1000, true },
+}
+
+type alertRule struct {
+	Name       string
+	Expr       string
+	Metric     string
+	Op         string
+	Threshold  float64
+	Window     time.Duration
+	Severity   string
+	For        time.Duration
+	Hysteresis float64
+	Cooldown   time.Duration
+	Targets    map[string]bool
+}
+
+// 解析 "指标 比较符 阈值[%|ms] [over 窗口]"
+func parseAlertExpr(rule *alertRule, expr string) error {
+	var fields = strings.Fields(expr)
+	if len(fields) != 3 && len(fields) != 5 {
+		return fmt.Errorf("规则格式应该是 \"指标 比较符 阈值 over 窗口\": %s", expr)
+	}
+
+	rule.Metric = strings.ToLower(fields[0])
+	rule.Metric = strings.TrimPrefix(rule.Metric, "rtt_")
+	if _, ok := alertMetrics[rule.Metric]; !ok {
+		return fmt.Errorf("不支持的指标: %s", fields[0])
+	}
+
+	rule.Op = fields[1]
+	switch rule.Op {
+	case ">", ">=", "<", "<=":
+	default:
+		return fmt.Errorf("不支持的比较符: %s", rule.Op)
+	}
+
+	var v = strings.TrimSuffix(strings.TrimSuffix(fields[2], "%"), "ms")
+	var threshold, err = strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("无效的阈值: %s", fields[2])
+	}
+	rule.Threshold = threshold
+
+	rule.Window = time.Minute
+	if len(fields) == 5 {
+		if strings.ToLower(fields[3]) != "over" {
+			return fmt.Errorf("规则格式应该是 \"指标 比较符 阈值 over 窗口\": %s", expr)
+		}
+		rule.Window, err = time.ParseDuration(fields[4])
+		if err != nil {
+			return fmt.Errorf("无效的窗口: %s", fields[4])
+		}
+		if !isReportWindow(rule.Window) {
+			return fmt.Errorf("窗口只能是%s: %s", reportWindowNames(), fields[4])
+		}
+	}
+	return nil
+}
+
+func newAlertRule(cfg *AlertConfig) (*alertRule, error) {
+	var rule = &alertRule{
+		Name:       cfg.Name,
+		Expr:       cfg.Expr,
+		Severity:   strings.ToLower(cfg.Severity),
+		Hysteresis: cfg.Hysteresis,
+		Cooldown:   10 * time.Minute,
+	}
+	if err := parseAlertExpr(rule, cfg.Expr); err != nil {
+		return nil, err
+	}
+
+	switch rule.Severity {
+	case "":
+		rule.Severity = "warning"
+	case "info", "warning", "critical":
+	default:
+		return nil, fmt.Errorf("不支持的严重程度: %s", cfg.Severity)
+	}
+
+	var err error
+	if len(cfg.For) > 0 {
+		if rule.For, err = time.ParseDuration(cfg.For); err != nil {
+			return nil, fmt.Errorf("无效的For: %s", cfg.For)
+		}
+	}
+	if len(cfg.Cooldown) > 0 {
+		if rule.Cooldown, err = time.ParseDuration(cfg.Cooldown); err != nil {
+			return nil, fmt.Errorf("无效的Cooldown: %s", cfg.Cooldown)
+		}
+	}
+	if rule.Hysteresis < 0 {
+		return nil, fmt.Errorf("Hysteresis不能是负数: %v", cfg.Hysteresis)
+	}
+
+	var targets = splitList(cfg.Targets)
+	if len(targets) > 0 {
+		rule.Targets = make(map[string]bool)
+		for _, v := range targets {
+			rule.Targets[v] = true
+		}
+	}
+	return rule, nil
+}
+
+// 是否满足触发条件
+func (self *alertRule) breached(v float64) bool {
+	switch self.Op {
+	case ">":
+		return v > self.Threshold
+	case ">=":
+		return v >= self.Threshold
+	case "<":
+		return v < self.Threshold
+	default:
+		return v <= self.Threshold
+	}
+}
+
+// 已经触发的告警，是否已经恢复（越过回差）
+func (self *alertRule) cleared(v float64) bool {
+	switch self.Op {
+	case ">", ">=":
+		return v < self.Threshold-self.Hysteresis || (self.Hysteresis == 0 && !self.breached(v))
+	default:
+		return v > self.Threshold+self.Hysteresis || (self.Hysteresis == 0 && !self.breached(v))
+	}
+}
+
+// 读取所有的[alert.xxx]配置段，一个都没有时使用默认规则
+func loadAlertRules(iniCfg *ini.File) ([]*alertRule, error) {
+	var configs []*AlertConfig
+	for _, section := range iniCfg.Sections() {
+		if !strings.HasPrefix(section.Name(), alertSectionPrefix) {
+			continue
+		}
+
+		var cfg = &AlertConfig{Name: strings.TrimPrefix(section.Name(), alertSectionPrefix)}
+		if err := section.MapTo(cfg); err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	if len(configs) == 0 {
+		configs = defaultAlertConfigs
+	}
+
+	var rules []*alertRule
+	for _, cfg := range configs {
+		var rule, err = newAlertRule(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("[%s%s] %s", alertSectionPrefix, cfg.Name, err.Error())
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+const (
+	EAlertInactive = "inactive"
+	EAlertPending  = "pending" // 满足条件了，但是还没有持续到For
+	EAlertFiring   = "firing"
+)
+
+// 某条规则在某个目标上的状态
+type alertState struct {
+	State        string
+	Value        float64
+	PendingSince int64
+	FiringSince  int64
+	LastNotify   int64
+	// 触发时有没有发出通知（冷却中没有发的话，恢复时也不发）
+	notified bool
+
+	rule   *alertRule
+	target string
+}
+
+// 一次状态切换
+type alertEvent struct {
+	Rule     *alertRule
+	Target   string
+	State    string // firing, resolved, suppressed（冷却中触发的，没有通知）
+	Value    float64
+	Time     int64
+	Duration int64 // 恢复时，持续了多久（毫秒）
+}
+
+func (self *alertEvent) Subject() string {
+	return fmt.Sprintf("[%s][%s] %s: %s", strings.ToUpper(self.State), self.Rule.Severity, self.Target, self.Rule.Name)
+}
+
+func (self *alertEvent) Text() string {
+	if self.State == "resolved" {
+		return fmt.Sprintf("%s 已恢复，当前值=%.2f，持续了%s", self.Rule.Expr, self.Value,
+			time.Duration(self.Duration)*time.Millisecond)
+	}
+	return fmt.Sprintf("%s 当前值=%.2f", self.Rule.Expr, self.Value)
+}
+
+// 保留最近多少条告警记录
+const alertHistorySize = 100
+
+type alertManager struct {
+	rules  []*alertRule
+	states map[string]*alertState
+
+	// Evaluate在汇报的协程里调用，HTTP会同时读取
+	mutex   sync.Mutex
+	history []*alertEvent
+}
+
+func newAlertManager(rules []*alertRule) *alertManager {
+	return &alertManager{rules: rules, states: make(map[string]*alertState)}
+}
+
+// 根据最新的统计计算所有规则，返回需要通知的状态切换
+func (self *alertManager) Evaluate(snaps []*statsSnapshot, now int64) []*alertEvent {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var events []*alertEvent
+
+	for _, rule := range self.rules {
+		for _, s := range snaps {
+			if rule.Targets != nil && !rule.Targets[s.Name] {
+				continue
+			}
+
+			var v, ok = alertMetrics[rule.Metric](s, s.Window(rule.Window))
+			if !ok {
+				// 没有数据，保持原来的状态
+				continue
+			}
+
+			var key = rule.Name + "|" + s.Name
+			var st, exist = self.states[key]
+			if !exist {
+				st = &alertState{State: EAlertInactive, rule: rule, target: s.Name}
+				self.states[key] = st
+			}
+			st.Value = v
+
+			switch st.State {
+			case EAlertInactive, EAlertPending:
+				if !rule.breached(v) {
+					st.State = EAlertInactive
+					continue
+				}
+				if st.State == EAlertInactive {
+					st.State = EAlertPending
+					st.PendingSince = now
+				}
+				if now-st.PendingSince < int64(rule.For/time.Millisecond) {
+					continue
+				}
+
+				st.State = EAlertFiring
+				st.FiringSince = now
+				st.notified = st.LastNotify == 0 || now-st.LastNotify >= int64(rule.Cooldown/time.Millisecond)
+				if st.notified {
+					st.LastNotify = now
+					events = append(events, &alertEvent{Rule: rule, Target: s.Name, State: "firing", Value: v, Time: now})
+				} else {
+					netLog.Infoln("告警冷却中，不通知:", rule.Name, s.Name, v)
+					// 不通知，但是记到历史里
+					self.history = append(self.history, &alertEvent{Rule: rule, Target: s.Name, State: "suppressed", Value: v, Time: now})
+				}
+
+			case EAlertFiring:
+				if !rule.cleared(v) {
+					continue
+				}
+				st.State = EAlertInactive
+				if st.notified {
+					events = append(events, &alertEvent{Rule: rule, Target: s.Name, State: "resolved", Value: v, Time: now,
+						Duration: now - st.FiringSince})
+				}
+			}
+		}
+	}
+
+	self.history = append(self.history, events...)
+	if len(self.history) > alertHistorySize {
+		self.history = self.history[len(self.history)-alertHistorySize:]
+	}
+
+	return events
+}
+
+// 重新加载配置后更新规则，名字、指标和窗口都没变的规则保留原来的状态
+func (self *alertManager) SetRules(rules []*alertRule) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var byName = make(map[string]*alertRule)
+	for _, rule := range rules {
+		byName[rule.Name] = rule
+	}
+	for key, st := range self.states {
+		var rule, ok = byName[st.rule.Name]
+		if !ok || rule.Metric != st.rule.Metric || rule.Window != st.rule.Window {
+			delete(self.states, key)
+			continue
+		}
+		st.rule = rule
+	}
+	self.rules = rules
+}
+
+// 目标删除了，清掉它的状态
+func (self *alertManager) Forget(target string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for key, st := range self.states {
+		if st.target == target {
+			delete(self.states, key)
+		}
+	}
+}
+
+// 最近的告警记录，从早到晚
+func (self *alertManager) History() []*alertEvent {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return append([]*alertEvent(nil), self.history...)
+}
+
+// 正在触发的告警，Time是开始触发的时间
+func (self *alertManager) Firing() []*alertEvent {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var ret []*alertEvent
+	for _, st := range self.states {
+		if st.State == EAlertFiring {
+			ret = append(ret, &alertEvent{Rule: st.rule, Target: st.target, State: "firing", Value: st.Value, Time: st.FiringSince})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Time < ret[j].Time
+	})
+	return ret
+}
+
+var alerts *alertManager
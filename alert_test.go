@@ -0,0 +1,147 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 冷却中再次触发的不通知，历史里记为suppressed
+func TestAlertCooldownSuppressed(t *testing.T) {
+	var rule, err = newAlertRule(&AlertConfig{Name: "loss", Expr: "loss > 2% over 1m", Cooldown: "10m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var alerts = newAlertManager([]*alertRule{rule})
+	var snap = func(lost int64) []*statsSnapshot {
+		return []*statsSnapshot{{Name: "a", Windows: []windowSnapshot{{Window: time.Minute, Counts: probeCounts{Received: 100 - lost, Lost: lost}}}}}
+	}
+
+	var now = int64(1000000)
+	var cases = []struct {
+		lost    int64
+		events  []string
+		history string
+	}{
+		{10, []string{"firing"}, "firing"},
+		{0, []string{"resolved"}, "resolved"},
+		{10, nil, "suppressed"},
+		// 没有通知过的，恢复时也不通知
+		{0, nil, "suppressed"},
+	}
+	for i, c := range cases {
+		now += int64(time.Minute / time.Millisecond)
+		var events = alerts.Evaluate(snap(c.lost), now)
+		var states []string
+		for _, ev := range events {
+			states = append(states, ev.State)
+		}
+		if len(states) != len(c.events) || (len(states) > 0 && states[0] != c.events[0]) {
+			t.Fatalf("step %d: events %v, want %v", i, states, c.events)
+		}
+		var history = alerts.History()
+		if state := history[len(history)-1].State; state != c.history {
+			t.Fatalf("step %d: last history %s, want %s", i, state, c.history)
+		}
+	}
+}
+
+func TestNewAlertRule(t *testing.T) {
+	var cases = []struct {
+		cfg  AlertConfig
+		want alertRule
+		err  string
+	}{
+		{AlertConfig{Name: "a", Expr: "loss > 2% over 5m"},
+			alertRule{Metric: "loss", Op: ">", Threshold: 2, Window: 5 * time.Minute, Severity: "warning", Cooldown: 10 * time.Minute}, ""},
+		// 窗口省略时是1m，rtt_前缀可以省略
+		{AlertConfig{Name: "a", Expr: "rtt_p99 >= 80ms", Severity: "Critical", For: "3m", Cooldown: "1h", Hysteresis: 10},
+			alertRule{Metric: "p99", Op: ">=", Threshold: 80, Window: time.Minute, Severity: "critical", For: 3 * time.Minute,
+				Cooldown: time.Hour, Hysteresis: 10}, ""},
+		{AlertConfig{Name: "a", Expr: "loss > 2%"}, alertRule{}, ""},
+		{AlertConfig{Name: "a", Expr: "loss > 2% in 1m"}, alertRule{}, "规则格式"},
+		{AlertConfig{Name: "a", Expr: "nope > 2"}, alertRule{}, "不支持的指标"},
+		{AlertConfig{Name: "a", Expr: "loss != 2"}, alertRule{}, "不支持的比较符"},
+		{AlertConfig{Name: "a", Expr: "loss > x"}, alertRule{}, "无效的阈值"},
+		{AlertConfig{Name: "a", Expr: "loss > 2 over 2m"}, alertRule{}, "窗口只能是"},
+		{AlertConfig{Name: "a", Expr: "loss > 2", Severity: "fatal"}, alertRule{}, "不支持的严重程度"},
+		{AlertConfig{Name: "a", Expr: "loss > 2", For: "x"}, alertRule{}, "无效的For"},
+		{AlertConfig{Name: "a", Expr: "loss > 2", Cooldown: "x"}, alertRule{}, "无效的Cooldown"},
+		{AlertConfig{Name: "a", Expr: "loss > 2", Hysteresis: -1}, alertRule{}, "Hysteresis"},
+	}
+	for _, c := range cases {
+		var rule, err = newAlertRule(&c.cfg)
+		if len(c.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%+v: err = %v, want %s", c.cfg, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", c.cfg, err)
+			continue
+		}
+		if len(c.want.Metric) == 0 {
+			continue
+		}
+		c.want.Name, c.want.Expr = c.cfg.Name, c.cfg.Expr
+		if !reflect.DeepEqual(*rule, c.want) {
+			t.Errorf("%+v: rule %+v, want %+v", c.cfg, *rule, c.want)
+		}
+	}
+}
+
+// For、回差和冷却的状态切换，每一步是一分钟后的丢包数（共100个包），-1表示没有数据
+func TestAlertStateMachine(t *testing.T) {
+	var cases = []struct {
+		name   string
+		cfg    AlertConfig
+		lost   []int64
+		events []string
+	}{
+		{"fires at once", AlertConfig{Expr: "loss > 2%"},
+			[]int64{1, 5, 5, 1}, []string{"", "firing", "", "resolved"}},
+		{"for", AlertConfig{Expr: "loss > 2%", For: "2m"},
+			[]int64{5, 5, 5, 1}, []string{"", "", "firing", "resolved"}},
+		// 中间恢复了一次，重新开始计算持续时间
+		{"for restarts", AlertConfig{Expr: "loss > 2%", For: "2m"},
+			[]int64{5, 1, 5, 5, 5}, []string{"", "", "", "", "firing"}},
+		{"no hysteresis", AlertConfig{Expr: "loss > 2%"},
+			[]int64{5, 2, 5}, []string{"firing", "resolved", ""}},
+		// 回差：降到1%以下才算恢复
+		{"hysteresis", AlertConfig{Expr: "loss > 2%", Hysteresis: 1, Cooldown: "1m"},
+			[]int64{5, 2, 1, 0, 5}, []string{"firing", "", "", "resolved", "firing"}},
+		// 没有数据时保持原来的状态
+		{"no data", AlertConfig{Expr: "loss > 2%"},
+			[]int64{5, -1, 0}, []string{"firing", "", "resolved"}},
+		// 冷却过了以后再触发要通知
+		{"cooldown", AlertConfig{Expr: "loss > 2%", Cooldown: "3m"},
+			[]int64{5, 0, 5, 0, 0, 5}, []string{"firing", "resolved", "", "", "", "firing"}},
+		{"other target", AlertConfig{Expr: "loss > 2%", Targets: "b, c"},
+			[]int64{5, 0}, []string{"", ""}},
+	}
+	for _, c := range cases {
+		c.cfg.Name = "loss"
+		var rule, err = newAlertRule(&c.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var alerts = newAlertManager([]*alertRule{rule})
+		for i, lost := range c.lost {
+			var counts probeCounts
+			if lost >= 0 {
+				counts = probeCounts{Received: 100 - lost, Lost: lost}
+			}
+			var snap = &statsSnapshot{Name: "a", Windows: []windowSnapshot{{Window: time.Minute, Counts: counts}}}
+			var events = alerts.Evaluate([]*statsSnapshot{snap}, int64(i+1)*int64(time.Minute/time.Millisecond))
+			var got string
+			for _, ev := range events {
+				got += ev.State
+			}
+			if got != c.events[i] {
+				t.Errorf("%s: step %d lost %d: events %q, want %q", c.name, i, lost, got, c.events[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,514 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:47
+ * Comment: analyze子命令，离线分析记录下来的探测结果
+ *          数据来自本地存储（StoreDir）的原始记录，或者logs/net-*.log日志
+ *          输出丢包段、断网时段、按时间分段的延迟分布表和终端里的延迟曲线
+ */
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+type analyzeOptions struct {
+	// auto, store, logs
+	source   string
+	storeDir string
+	logs     string
+	target   string
+
+	from int64
+	to   int64
+
+	bucket time.Duration
+	// 连续失败多久算断网
+	outage time.Duration
+	// 连续丢几个包算一段丢包
+	burst int
+}
+
+// 一段连续失败的时间
+type analyzeInterval struct {
+	Start  int64
+	End    int64
+	Lost   int
+	Unsent int
+	// 中间有一段时间完全没有记录（程序没运行，或者断网时没有记录发包）
+	NoData bool
+}
+
+func (self *analyzeInterval) Duration() time.Duration {
+	return time.Duration(self.End-self.Start) * time.Millisecond
+}
+
+type analyzeResult struct {
+	Name     string
+	Proto    string
+	Interval time.Duration
+	Totals   *storeBucket
+	Buckets  []*storeBucket
+	Bursts   []*analyzeInterval
+	Outages  []*analyzeInterval
+}
+
+// 时间参数：2006-01-02 15:04:05, 2006-01-02 15:04, 2006-01-02, 15:04（今天），或者 -2h（相对现在）
+func parseAnalyzeTime(s string, now time.Time) (int64, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "-") {
+		var d, err = time.ParseDuration(s[1:])
+		if err != nil {
+			return 0, err
+		}
+		return now.Add(-d).UnixNano() / int64(time.Millisecond), nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t.UnixNano() / int64(time.Millisecond), nil
+		}
+	}
+	if t, err := time.ParseInLocation("15:04", s, time.Local); err == nil {
+		t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+		return t.UnixNano() / int64(time.Millisecond), nil
+	}
+	return 0, fmt.Errorf("无效的时间: %s", s)
+}
+
+func runAnalyze(args []string) int {
+	var fs = flag.NewFlagSet("analyze", flag.ContinueOnError)
+	var opt analyzeOptions
+	var config, from, to string
+	fs.StringVar(&config, "config", "config.ini", "配置文件，用来找到StoreDir")
+	fs.StringVar(&opt.source, "source", "auto", "数据来源：auto, store, logs")
+	fs.StringVar(&opt.storeDir, "store", "", "存储文件夹，默认是配置文件里的StoreDir")
+	fs.StringVar(&opt.logs, "logs", "logs/net-*.log", "日志文件")
+	fs.StringVar(&opt.target, "target", "*", "目标的名字，可以使用通配符")
+	fs.StringVar(&from, "from", "-24h", "开始时间，例如 2026-10-18 09:00, 09:00, -2h")
+	fs.StringVar(&to, "to", "", "结束时间，默认是现在")
+	fs.DurationVar(&opt.bucket, "bucket", 0, "分段的时间长度，默认自动选择")
+	fs.DurationVar(&opt.outage, "outage", 5*time.Second, "连续失败多久算断网")
+	fs.IntVar(&opt.burst, "burst", 2, "连续丢几个包算一段丢包")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	var now = time.Now()
+	var err error
+	if opt.from, err = parseAnalyzeTime(from, now); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	opt.to = now.UnixNano() / int64(time.Millisecond)
+	if len(to) > 0 {
+		if opt.to, err = parseAnalyzeTime(to, now); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+
+	if err = checkAnalyzeBucket(opt.bucket, opt.from, opt.to); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	if len(opt.storeDir) == 0 {
+		opt.storeDir = "data"
+		if cfg, err := ini.Load(config); err == nil {
+			if dir := cfg.Section("main").Key("StoreDir").String(); len(dir) > 0 {
+				opt.storeDir = dir
+			}
+		}
+	}
+
+	var records, source = loadAnalyzeRecords(&opt)
+	if len(records) == 0 {
+		fmt.Fprintln(os.Stderr, "没有找到探测记录")
+		return exitError
+	}
+
+	fmt.Printf("数据来源: %s, 时间: %s ~ %s\n", source, formatAnalyzeTime(opt.from, 0), formatAnalyzeTime(opt.to, 0))
+	for _, r := range analyzeRecords(records, &opt) {
+		printAnalyzeResult(os.Stdout, r)
+	}
+	return exitOk
+}
+
+// 读取探测记录，按目标分组，每组按发送时间排序
+func loadAnalyzeRecords(opt *analyzeOptions) (map[string][]*probeRecord, string) {
+	var ret = make(map[string][]*probeRecord)
+	var add = func(rec *probeRecord) {
+		if ok, _ := path.Match(opt.target, rec.Target); !ok {
+			return
+		}
+		ret[rec.Target] = append(ret[rec.Target], rec)
+	}
+
+	var source = opt.source
+	if source == "auto" || source == "store" {
+		readProbeRecords(opt.storeDir, opt.from, opt.to, add)
+		source = "store " + opt.storeDir
+	}
+	if len(ret) == 0 && opt.source != "store" {
+		readLogRecords(opt.logs, opt.from, opt.to, add)
+		source = "logs " + opt.logs
+	}
+
+	for _, v := range ret {
+		sort.SliceStable(v, func(i, j int) bool {
+			return v[i].SendTime < v[j].SendTime
+		})
+	}
+	return ret, source
+}
+
+var (
+	analyzeLogLine = regexp.MustCompile(`^\[\w+\]\s+\S+\s+(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})\s+(.*)$`)
+	analyzeLogAck  = regexp.MustCompile(`^(收到协议返回|收到协议返回，超时了|协议乱序|协议重复|协议错乱), id=(-?\d+), cost\(ms\)=(-?\d+)(?:, host=(.*))?$`)
+	analyzeLogLost = regexp.MustCompile(`^丢包了, id=(-?\d+), wait\(ms\)=(-?\d+)(?:, host=(.*))?$`)
+	analyzeLogDown = regexp.MustCompile(`^网络断开了，无法发包: (-?\d+)(?: (.*))?$`)
+)
+
+var analyzeLogResults = map[string]EProbeResult{
+	"收到协议返回":     EProbeOnTime,
+	"收到协议返回，超时了": EProbeLate,
+	"协议乱序":       EProbeReorder,
+	"协议重复":       EProbeDuplicate,
+	"协议错乱":       EProbeCorrupt,
+}
+
+// 从日志里还原探测记录，日志的时间只精确到秒
+func readLogRecords(pattern string, from, to int64, fn func(rec *probeRecord)) {
+	var files, _ = filepath.Glob(pattern)
+	for _, name := range files {
+		if info, err := os.Stat(name); err != nil || info.ModTime().UnixNano()/int64(time.Millisecond) < from {
+			continue
+		}
+		var f, err = os.Open(name)
+		if err != nil {
+			continue
+		}
+
+		var scanner = bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if rec := parseLogRecord(scanner.Text()); rec != nil && rec.SendTime >= from && rec.SendTime < to {
+				fn(rec)
+			}
+		}
+		f.Close()
+	}
+}
+
+func parseLogRecord(line string) *probeRecord {
+	var m = analyzeLogLine.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return nil
+	}
+	var t, err = time.ParseInLocation("2006/01/02 15:04:05", m[1], time.Local)
+	if err != nil {
+		return nil
+	}
+	var now = t.UnixNano() / int64(time.Millisecond)
+	var msg = strings.TrimSpace(m[2])
+
+	if a := analyzeLogAck.FindStringSubmatch(msg); a != nil {
+		var id, _ = strconv.Atoi(a[2])
+		var rtt, _ = strconv.ParseInt(a[3], 10, 64)
+		return &probeRecord{Target: a[4], Seq: int32(id), SendTime: now - rtt, RecvTime: now, Rtt: rtt, Result: analyzeLogResults[a[1]]}
+	}
+	if a := analyzeLogLost.FindStringSubmatch(msg); a != nil {
+		var id, _ = strconv.Atoi(a[1])
+		var wait, _ = strconv.ParseInt(a[2], 10, 64)
+		return &probeRecord{Target: a[3], Seq: int32(id), SendTime: now - wait, Rtt: wait, Result: EProbeLost}
+	}
+	if a := analyzeLogDown.FindStringSubmatch(msg); a != nil {
+		var id, _ = strconv.Atoi(a[1])
+		return &probeRecord{Target: a[2], Seq: int32(id), SendTime: now, Result: EProbeUnsent}
+	}
+	return nil
+}
+
+// -bucket最多分成多少段
+const analyzeMaxBuckets = 1000
+
+// 检查-bucket，太小的分段没有意义，分段太多时输出太长
+func checkAnalyzeBucket(bucket time.Duration, from, to int64) error {
+	if bucket == 0 {
+		return nil
+	}
+	if bucket < time.Millisecond {
+		return fmt.Errorf("-bucket不能小于1ms: %s", bucket)
+	}
+	if n := (to - from) / int64(bucket/time.Millisecond); n > analyzeMaxBuckets {
+		return fmt.Errorf("-bucket %s 太小，%s ~ %s 会分成%d段，最多%d段", bucket,
+			formatAnalyzeTime(from, 0), formatAnalyzeTime(to, 0), n, analyzeMaxBuckets)
+	}
+	return nil
+}
+
+// 让图表大约有60列
+var analyzeBuckets = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+func chooseAnalyzeBucket(span time.Duration) time.Duration {
+	for _, b := range analyzeBuckets {
+		if span/b <= 60 {
+			return b
+		}
+	}
+	return analyzeBuckets[len(analyzeBuckets)-1]
+}
+
+func analyzeRecords(all map[string][]*probeRecord, opt *analyzeOptions) []*analyzeResult {
+	var names []string
+	for k := range all {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var ret []*analyzeResult
+	for _, name := range names {
+		ret = append(ret, analyzeTarget(name, all[name], opt))
+	}
+	return ret
+}
+
+func analyzeTarget(name string, records []*probeRecord, opt *analyzeOptions) *analyzeResult {
+	var ret = &analyzeResult{Name: name, Proto: records[0].Proto, Totals: &storeBucket{Rtt: newHistogram()}}
+
+	// 发包间隔，取相邻两个包的间隔的中位数
+	var deltas []int64
+	for i := 1; i < len(records); i++ {
+		if d := records[i].SendTime - records[i-1].SendTime; d > 0 {
+			deltas = append(deltas, d)
+		}
+	}
+	var interval int64 = 1000
+	if len(deltas) > 0 {
+		sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+		interval = deltas[len(deltas)/2]
+	}
+	ret.Interval = time.Duration(interval) * time.Millisecond
+
+	// 分段统计
+	// 日志里的记录可能稍微超出时间范围，分段太多时也自动选择
+	var span = time.Duration(records[len(records)-1].SendTime-records[0].SendTime) * time.Millisecond
+	var bucket = opt.bucket
+	if bucket < time.Millisecond || span/bucket >= analyzeMaxBuckets {
+		bucket = chooseAnalyzeBucket(span)
+	}
+	var bucketMs = int64(bucket / time.Millisecond)
+	var first = records[0].SendTime - records[0].SendTime%bucketMs
+	var last = records[len(records)-1].SendTime
+	for start := first; start <= last; start += bucketMs {
+		ret.Buckets = append(ret.Buckets, &storeBucket{Time: start, Target: name, Rtt: newHistogram()})
+	}
+	for _, r := range records {
+		ret.Totals.Add(r)
+		ret.Buckets[(r.SendTime-first)/bucketMs].Add(r)
+	}
+
+	// 连续失败的时间段，超过outage的算断网，否则连续丢了burst个以上的算一段丢包
+	var gap = int64(opt.outage / time.Millisecond)
+	if interval*3 > gap {
+		gap = interval * 3
+	}
+	var cur *analyzeInterval
+	var finish = func(end int64) {
+		cur.End = end
+		if cur.NoData || cur.Duration() >= opt.outage {
+			ret.Outages = append(ret.Outages, cur)
+		} else if cur.Lost+cur.Unsent >= opt.burst {
+			ret.Bursts = append(ret.Bursts, cur)
+		}
+		cur = nil
+	}
+
+	var prev int64
+	for _, r := range records {
+		if r.Result == EProbeDuplicate || r.Result == EProbeCorrupt || r.Result == EProbeLostLate {
+			continue
+		}
+		if prev > 0 && r.SendTime-prev > gap {
+			if cur == nil {
+				cur = &analyzeInterval{Start: prev}
+			}
+			cur.NoData = true
+		}
+		prev = r.SendTime
+
+		switch r.Result {
+		case EProbeLost, EProbeUnsent:
+			if cur == nil {
+				cur = &analyzeInterval{Start: r.SendTime}
+			}
+			if r.Result == EProbeLost {
+				cur.Lost++
+			} else {
+				cur.Unsent++
+			}
+		default:
+			if cur != nil {
+				finish(r.SendTime)
+			}
+		}
+	}
+	if cur != nil {
+		finish(prev + interval)
+	}
+	return ret
+}
+
+// 按分段长度选择时间的格式
+func formatAnalyzeTime(ms int64, bucket time.Duration) string {
+	var t = time.Unix(0, ms*int64(time.Millisecond))
+	switch {
+	case bucket > 0 && bucket < time.Minute:
+		return t.Format("15:04:05")
+	case bucket > 0 && bucket < 24*time.Hour:
+		return t.Format("01-02 15:04")
+	case bucket > 0:
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func printAnalyzeResult(w io.Writer, r *analyzeResult) {
+	var c = r.Totals.Counts
+	fmt.Fprintf(w, "\n== %s %s 发包间隔≈%s\n", r.Name, r.Proto, r.Interval)
+	fmt.Fprintf(w, "收到:%d, 丢包:%d(%.2f%%), 未发出:%d, 超时:%d, 乱序:%d, 重复:%d, 错乱:%d\n",
+		c.Received, c.Lost, c.LossPercent(), c.Unsent, c.Late, c.Reorder, c.Duplicate, c.Corrupt)
+	fmt.Fprintf(w, "延迟: %s\n", r.Totals.Rtt.Summary())
+
+	fmt.Fprintf(w, "\n断网 %d 次:\n", len(r.Outages))
+	for _, v := range r.Outages {
+		var note = ""
+		if v.NoData {
+			note = " (期间没有记录)"
+		}
+		fmt.Fprintf(w, "  %s ~ %s  %s  丢包:%d 未发出:%d%s\n", formatAnalyzeTime(v.Start, 0), formatAnalyzeTime(v.End, 0),
+			v.Duration(), v.Lost, v.Unsent, note)
+	}
+
+	fmt.Fprintf(w, "\n连续丢包 %d 段:\n", len(r.Bursts))
+	for _, v := range r.Bursts {
+		fmt.Fprintf(w, "  %s ~ %s  %s  丢包:%d 未发出:%d\n", formatAnalyzeTime(v.Start, 0), formatAnalyzeTime(v.End, 0),
+			v.Duration(), v.Lost, v.Unsent)
+	}
+
+	var bucket time.Duration
+	if len(r.Buckets) > 1 {
+		bucket = time.Duration(r.Buckets[1].Time-r.Buckets[0].Time) * time.Millisecond
+	} else {
+		bucket = time.Second
+	}
+	fmt.Fprintf(w, "\n延迟分布（毫秒，每%s）:\n", bucket)
+	fmt.Fprintf(w, "  %s %s %s %6s %6s %6s %6s %6s\n", padAnalyzeText("时间", 12, false), padAnalyzeText("收到", 7, true),
+		padAnalyzeText("丢包率", 7, true), "min", "p50", "p90", "p99", "max")
+	for _, b := range r.Buckets {
+		var s = b.Rtt.Summary()
+		var loss = "-"
+		if b.Counts.Received+b.Counts.Lost > 0 {
+			loss = fmt.Sprintf("%.2f%%", b.Counts.LossPercent())
+		}
+		var latency = fmt.Sprintf("%6s %6s %6s %6s %6s", "-", "-", "-", "-", "-")
+		if s.Count > 0 {
+			latency = fmt.Sprintf("%6d %6d %6d %6d %6d", s.Min, s.P50, s.P90, s.P99, s.Max)
+		}
+		fmt.Fprintf(w, "  %-12s %7d %7s %s\n", formatAnalyzeTime(b.Time, bucket), b.Counts.Received, loss, latency)
+	}
+
+	fmt.Fprintf(w, "\n延迟曲线（█ p50, · p99, x 全部丢失）:\n")
+	for _, line := range analyzeChart(r.Buckets, 10) {
+		fmt.Fprintln(w, line)
+	}
+	if len(r.Buckets) > 0 {
+		var from = formatAnalyzeTime(r.Buckets[0].Time, bucket)
+		var to = formatAnalyzeTime(r.Buckets[len(r.Buckets)-1].Time, bucket)
+		var pad = len(r.Buckets) - len(from) - len(to)
+		if pad < 1 {
+			pad = 1
+		}
+		fmt.Fprintf(w, "%8s %s%s%s\n", "", from, strings.Repeat(" ", pad), to)
+	}
+}
+
+// 终端里的柱状图，每个分段一列
+func analyzeChart(buckets []*storeBucket, height int) []string {
+	var max int64
+	for _, b := range buckets {
+		if p := b.Rtt.Percentile(99); p > max {
+			max = p
+		}
+	}
+	if max <= 0 {
+		max = 1
+	}
+
+	var lines []string
+	for row := height; row >= 1; row-- {
+		var level = float64(max) * float64(row) / float64(height)
+		var label = ""
+		if row == height {
+			label = strconv.FormatInt(max, 10)
+		}
+		var buf = []rune(fmt.Sprintf("%7s |", label))
+		for _, b := range buckets {
+			var ch = ' '
+			switch {
+			case b.Rtt.count == 0:
+				if row == 1 && b.Counts.Lost+b.Counts.Unsent > 0 {
+					ch = 'x'
+				}
+			case float64(b.Rtt.Percentile(50)) >= level-float64(max)/float64(height)/2:
+				ch = '█'
+			case math.Abs(float64(b.Rtt.Percentile(99))-level) <= float64(max)/float64(height)/2:
+				ch = '·'
+			case row == 1:
+				// 延迟很小，不够一格
+				ch = '▁'
+			}
+			buf = append(buf, ch)
+		}
+		lines = append(lines, string(buf))
+	}
+	lines = append(lines, fmt.Sprintf("%7s +%s", "0", strings.Repeat("-", len(buckets))))
+	return lines
+}
+
+// 中文占两列，按显示宽度补空格，left为true时补在左边（右对齐）
+func padAnalyzeText(s string, width int, left bool) string {
+	var n = 0
+	for _, r := range s {
+		if r >= 0x1100 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	if n >= width {
+		return s
+	}
+	if left {
+		return strings.Repeat(" ", width-n) + s
+	}
+	return s + strings.Repeat(" ", width-n)
+}
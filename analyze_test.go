@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckAnalyzeBucket(t *testing.T) {
+	var from = int64(1000000)
+	var cases = []struct {
+		bucket time.Duration
+		to     int64
+		ok     bool
+	}{
+		{0, from + 3600000, true},
+		{500 * time.Microsecond, from + 1000, false},
+		{time.Millisecond, from + 1000, true},
+		{time.Millisecond, from + analyzeMaxBuckets + 1, false},
+		{time.Second, from + 3600000, false},
+		{time.Minute, from + 3600000, true},
+	}
+	for _, c := range cases {
+		if err := checkAnalyzeBucket(c.bucket, from, c.to); (err == nil) != c.ok {
+			t.Errorf("bucket %s span %dms: err %v, want ok %v", c.bucket, c.to-from, err, c.ok)
+		}
+	}
+}
+
+func TestAnalyzeTargetBucket(t *testing.T) {
+	var records []*probeRecord
+	for i := int64(0); i < 100; i++ {
+		records = append(records, &probeRecord{Target: "a", Seq: int32(i), SendTime: 1000000 + i*10, Rtt: 5, Result: EProbeOnTime})
+	}
+	var cases = []struct {
+		bucket  time.Duration
+		buckets int
+	}{
+		{10 * time.Millisecond, 100},
+		{100 * time.Millisecond, 10},
+		// 太小的自动选择，不会除以0
+		{500 * time.Microsecond, 1},
+		{time.Nanosecond, 1},
+		{0, 1},
+	}
+	for _, c := range cases {
+		var r = analyzeTarget("a", records, &analyzeOptions{bucket: c.bucket, outage: 5 * time.Second, burst: 2})
+		if len(r.Buckets) != c.buckets || r.Totals.Counts.Received != 100 {
+			t.Errorf("bucket %s: %d buckets, %d received, want %d buckets", c.bucket, len(r.Buckets), r.Totals.Counts.Received, c.buckets)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:56
+ * Comment: 命令行
+ *          network_profiler [子命令] [参数]
+ *          没有子命令时和以前一样，按配置文件里的Role运行
+ *          配置文件里main段的每一项都可以用同名的参数覆盖，例如 -ProbeInterval 100 -HttpAddr :9100
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 编译时可以用 -ldflags "-X main.version=1.2.3" 指定
+var version = "dev"
+
+type cliCommand struct {
+	Name    string
+	Role    ERole
+	Comment string
+}
+
+var cliCommands = []cliCommand{
+	{Name: "client", Role: ERoleClient, Comment: "客户端，探测ServerAddr或者[target.xxx]里的目标"},
+	{Name: "server", Role: ERoleServer, Comment: "服务器，在ServerAddr侦听"},
+	{Name: "mesh", Role: ERoleMesh, Comment: "网状模式，在ServerAddr侦听，同时探测Peers里的其他节点"},
+	{Name: "collector", Role: ERoleCollector, Comment: "汇总服务器，接收各个节点推送的统计"},
+	{Name: "analyze", Comment: "分析保存的探测结果或者日志"},
+	{Name: "check-config", Comment: "检查配置文件，输出最终生效的配置"},
+	{Name: "init-config", Comment: "生成带注释的配置文件模板，不会覆盖已有的文件"},
+	{Name: "version", Comment: "输出版本"},
+}
+
+// 运行探测时的命令行参数
+type runOptions struct {
+	// 配置文件
+	config string
+
+	// 延迟启动
+	delay time.Duration
+
+	// 退出时最多等多久，超过了强制退出
+	shutdownTimeout time.Duration
+
+	// 覆盖配置文件的值，名字和配置文件里的一样
+	overrides map[string]string
+}
+
+// 覆盖配置文件里某一项的参数
+type configFlag struct {
+	name      string
+	kind      reflect.Kind
+	overrides map[string]string
+}
+
+func (self *configFlag) String() string {
+	if self == nil || self.overrides == nil {
+		return ""
+	}
+	return self.overrides[self.name]
+}
+
+func (self *configFlag) Set(value string) error {
+	var err error
+	switch self.kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		_, err = strconv.ParseFloat(value, 64)
+	case reflect.Bool:
+		_, err = strconv.ParseBool(value)
+	}
+	if err != nil {
+		return fmt.Errorf("无效的值: %s", value)
+	}
+	self.overrides[self.name] = value
+	return nil
+}
+
+// 配置文件的参数，加上main段的每一项
+func newConfigFlagSet(name string, opt *runOptions) *flag.FlagSet {
+	var fs = flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opt.config, "config", "config.ini", "配置文件，按扩展名区分格式：.ini, .yaml, .toml, .json")
+
+	opt.overrides = make(map[string]string)
+	var t = reflect.TypeOf(GlobalConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		var f = t.Field(i)
+		if f.Tag.Get("ini") == "-" {
+			continue
+		}
+		fs.Var(&configFlag{name: f.Name, kind: f.Type.Kind(), overrides: opt.overrides}, f.Name, "覆盖配置文件里的"+f.Name)
+	}
+	return fs
+}
+
+// 解析命令行，analyze, check-config, version这些子命令在这里执行完就退出
+// 返回运行探测需要的参数
+func parseCommandLine(args []string) *runOptions {
+	var opt, code = dispatchCommand(args)
+	if opt == nil {
+		os.Exit(code)
+	}
+	return opt
+}
+
+// 执行子命令时返回nil和退出码，运行探测时返回参数
+func dispatchCommand(args []string) (*runOptions, int) {
+	var name string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	switch name {
+	case "analyze":
+		return nil, runAnalyze(args)
+	case "check-config":
+		return nil, runCheckConfig(args)
+	case "init-config":
+		return nil, runInitConfig(args)
+	case "version":
+		fmt.Printf("network_profiler %s %s %s/%s\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		return nil, exitOk
+	case "help":
+		printUsage()
+		return nil, exitOk
+	}
+
+	var opt runOptions
+	var fs = newConfigFlagSet(name, &opt)
+	// 以前没有子命令时固定等3秒
+	var delay = 3 * time.Second
+	if len(name) > 0 {
+		delay = 0
+	}
+	fs.DurationVar(&opt.delay, "delay", delay, "延迟启动")
+	fs.DurationVar(&opt.shutdownTimeout, "shutdown-timeout", 10*time.Second, "退出时等待探测包返回、写完日志和结果的最长时间")
+	fs.Usage = printUsage
+
+	var role = ERoleNone
+	if len(name) > 0 {
+		for _, c := range cliCommands {
+			if c.Name == name && c.Role != ERoleNone {
+				role = c.Role
+			}
+		}
+		if role == ERoleNone {
+			fmt.Fprintln(os.Stderr, "未知的子命令:", name)
+			printUsage()
+			return nil, exitUsage
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "多余的参数:", strings.Join(fs.Args(), " "))
+		return nil, exitUsage
+	}
+	// 子命令决定角色
+	if role != ERoleNone {
+		opt.overrides["Role"] = strconv.Itoa(int(role))
+	}
+	return &opt, exitOk
+}
+
+func printUsage() {
+	var out = os.Stderr
+	fmt.Fprintln(out, "用法: network_profiler [子命令] [参数]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "子命令:")
+	for _, c := range cliCommands {
+		fmt.Fprintf(out, "  %-14s%s\n", c.Name, c.Comment)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "没有子命令时按配置文件里的Role运行")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "参数:")
+	fmt.Fprintln(out, "  -config string  配置文件（默认config.ini），也可以是.yaml, .toml, .json")
+	fmt.Fprintln(out, "  -delay duration 延迟启动（没有子命令时默认3s）")
+	fmt.Fprintln(out, "  -shutdown-timeout duration")
+	fmt.Fprintln(out, "                  收到SIGINT或者SIGTERM后，等待探测包返回、写完日志和结果的最长时间（默认10s）")
+	fmt.Fprintln(out, "  -<配置项> value  覆盖配置文件[main]段里的同名项，例如 -ProbeInterval 100 -HttpAddr :9100")
+
+	var names []string
+	var t = reflect.TypeOf(GlobalConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("ini") != "-" {
+			names = append(names, t.Field(i).Name)
+		}
+	}
+	fmt.Fprintln(out, "  配置项:", strings.Join(names, ", "))
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "环境变量 NETPROF_配置项 覆盖配置文件，例如 NETPROF_PROBE_INTERVAL=100, NETPROF_TARGET__GAME1__SERVER_ADDR=10.0.0.1:20201")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "退出码:")
+	fmt.Fprintf(out, "  %-4d正常退出\n", exitOk)
+	fmt.Fprintf(out, "  %-4d配置错误或者运行出错\n", exitError)
+	fmt.Fprintf(out, "  %-4d命令行参数错误\n", exitUsage)
+	fmt.Fprintf(out, "  %-4d退出超时，日志或者结果可能没有写完\n", exitShutdownTimeout)
+	fmt.Fprintln(out, "  128+信号  退出过程中再次收到信号，强制退出")
+}
+
+// 读取配置文件并检查，输出最终生效的配置，有错误时返回exitError
+func runCheckConfig(args []string) int {
+	var opt runOptions
+	var fs = newConfigFlagSet("check-config", &opt)
+	var role string
+	fs.StringVar(&role, "role", "", "按哪个子命令检查：client, server, mesh, collector，默认是配置文件里的Role")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if len(role) > 0 {
+		var found bool
+		for _, c := range cliCommands {
+			if c.Name == role && c.Role != ERoleNone {
+				opt.overrides["Role"] = strconv.Itoa(int(c.Role))
+				found = true
+			}
+		}
+		if !found {
+			fmt.Fprintln(os.Stderr, "无效的角色:", role)
+			return exitUsage
+		}
+	}
+
+	if err := loadConfig(opt.config, opt.overrides); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	fmt.Println("配置文件:", opt.config)
+	var v = reflect.ValueOf(globalConfig)
+	for i := 0; i < v.NumField(); i++ {
+		var f = v.Type().Field(i)
+		if f.Tag.Get("ini") == "-" {
+			continue
+		}
+		var mark string
+		if _, ok := opt.overrides[f.Name]; ok {
+			mark = "  (命令行)"
+		}
+		fmt.Printf("  %-18s= %v%s\n", f.Name, v.Field(i).Interface(), mark)
+	}
+	fmt.Println("探测目标:")
+	for _, t := range globalConfig.Targets {
+		fmt.Printf("  %s %s %s 间隔%dms 超时%dms\n", t.Name, t.Proto, t.ServerAddr, t.ProbeInterval, t.ProbeTimeout)
+	}
+	fmt.Println("告警规则:")
+	for _, r := range alerts.rules {
+		fmt.Printf("  %s: %s [%s]\n", r.Name, r.Expr, r.Severity)
+	}
+	fmt.Println("通知渠道:")
+	for _, n := range notifiers {
+		fmt.Println(" ", n.Name())
+	}
+	for _, s := range digestSchedules {
+		fmt.Println("定时报告:", s.Spec)
+	}
+
+	fmt.Println("配置正确")
+	return exitOk
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDispatchCommand(t *testing.T) {
+	var cases = []struct {
+		args  []string
+		run   bool
+		code  int
+		role  ERole
+		delay time.Duration
+	}{
+		// 没有子命令时按配置文件里的Role运行，和以前一样等3秒
+		{nil, true, exitOk, ERoleNone, 3 * time.Second},
+		{[]string{"-config", "a.yaml", "-ProbeInterval", "100"}, true, exitOk, ERoleNone, 3 * time.Second},
+		{[]string{"client"}, true, exitOk, ERoleClient, 0},
+		{[]string{"server", "-delay", "1s"}, true, exitOk, ERoleServer, time.Second},
+		{[]string{"mesh"}, true, exitOk, ERoleMesh, 0},
+		{[]string{"collector"}, true, exitOk, ERoleCollector, 0},
+		// 子命令决定的角色覆盖参数
+		{[]string{"client", "-Role", "2"}, true, exitOk, ERoleClient, 0},
+		{[]string{"version"}, false, exitOk, ERoleNone, 0},
+		{[]string{"help"}, false, exitOk, ERoleNone, 0},
+		{[]string{"nope"}, false, exitUsage, ERoleNone, 0},
+		{[]string{"client", "extra"}, false, exitUsage, ERoleNone, 0},
+		{[]string{"-nope", "1"}, false, exitUsage, ERoleNone, 0},
+		{[]string{"-ProbeInterval", "fast"}, false, exitUsage, ERoleNone, 0},
+		{[]string{"check-config", "-role", "nope"}, false, exitUsage, ERoleNone, 0},
+		{[]string{"init-config", "-nope"}, false, exitUsage, ERoleNone, 0},
+		{[]string{"analyze", "-from", "yesterday"}, false, exitUsage, ERoleNone, 0},
+	}
+	for _, c := range cases {
+		var opt, code = dispatchCommand(c.args)
+		if (opt != nil) != c.run || code != c.code {
+			t.Errorf("%v: run %v code %d, want %v %d", c.args, opt != nil, code, c.run, c.code)
+			continue
+		}
+		if opt == nil {
+			continue
+		}
+		var role = ERoleNone
+		if v, ok := opt.overrides["Role"]; ok {
+			var n, _ = strconv.Atoi(v)
+			role = ERole(n)
+		}
+		if role != c.role || opt.delay != c.delay {
+			t.Errorf("%v: role %d delay %v, want %d %v", c.args, role, opt.delay, c.role, c.delay)
+		}
+	}
+}
+
+// [main]里的每一项都有同名的参数，解析配置时覆盖配置文件
+func TestConfigFlags(t *testing.T) {
+	var opt runOptions
+	var fs = newConfigFlagSet("test", &opt)
+	var typ = reflect.TypeOf(GlobalConfig{})
+	for i := 0; i < typ.NumField(); i++ {
+		var f = typ.Field(i)
+		if (fs.Lookup(f.Name) != nil) == (f.Tag.Get("ini") == "-") {
+			t.Errorf("%s: flag %v", f.Name, fs.Lookup(f.Name) != nil)
+		}
+	}
+
+	var dir, err = ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "config.ini")
+	if err := ioutil.WriteFile(path, []byte("[main]\nRole = 2\nProto = udp\nServerAddr = 127.0.0.1:20201\nProbeInterval = 500\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var run, code = dispatchCommand([]string{"client", "-config", path, "-ProbeInterval", "100", "-HttpAddr", ":9100",
+		"-StuffingCount", "8", "-ResultFormat", "csv"})
+	if run == nil {
+		t.Fatalf("exit code %d", code)
+	}
+	if run.config != path {
+		t.Fatalf("config %s, want %s", run.config, path)
+	}
+	cfg, err := parseConfig(run.config, run.overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g = &cfg.global
+	if g.Role != ERoleClient || g.ProbeInterval != 100 || g.HttpAddr != ":9100" || g.StuffingCount != 8 ||
+		g.ResultFormat != "csv" || g.ServerAddr != "127.0.0.1:20201" {
+		t.Errorf("config %+v", *g)
+	}
+}
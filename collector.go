@@ -0,0 +1,315 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:55
+ * Comment: 汇总服务器（Role=4）
+ *          各个节点配置CollectorUrl后，每10秒把统计快照通过HTTP推送到汇总服务器的/api/push
+ *          汇总服务器保存所有节点的快照，每分钟的汇总写入本地存储，统一计算告警和发送通知
+ *          超过collectorForgetTime没有推送的节点删除
+ *          节点推送到汇总服务器时，自己不再发送告警通知，只记日志
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 超过这个时间没有推送，认为节点掉线了
+const collectorStaleTime = time.Minute
+
+// 超过这个时间没有推送，删除节点，不再告警
+const collectorForgetTime = 24 * time.Hour
+
+func init() {
+	httpMux.HandleFunc("/api/push", handleCollectorPush)
+	httpMux.HandleFunc("/api/fleet", handleFleet)
+}
+
+// 节点推送的内容
+type collectorPush struct {
+	Node      string
+	LocalIp   string
+	Time      int64
+	Snapshots []*statsSnapshot
+}
+
+// 汇总服务器上的一个节点
+type fleetNode struct {
+	Node     string
+	LocalIp  string
+	LastSeen int64
+	// 节点上报的快照，名字是节点上的目标名字
+	Snapshots []*statsSnapshot
+}
+
+type fleetRegistry struct {
+	mutex sync.Mutex
+	nodes map[string]*fleetNode
+
+	// 每个目标（节点/目标）已经写入存储的最后一分钟，
+	// 第一次写入前从存储里读取，重启后不会再写一遍快照里的一小时
+	stored map[string]int64
+}
+
+var fleet = &fleetRegistry{nodes: make(map[string]*fleetNode)}
+
+// 存储里最近一小时每个目标的最后一分钟
+func loadFleetStored(dir string, now int64) map[string]int64 {
+	var ret = make(map[string]int64)
+	var hour = int64(time.Hour / time.Millisecond)
+	readRollupRecords(dir, storeTierMinute, now-hour-hour/60, now, func(rec *rollupRecord) {
+		if rec.Time > ret[rec.Target] {
+			ret[rec.Target] = rec.Time
+		}
+	})
+	return ret
+}
+
+func (self *fleetRegistry) Update(push *collectorPush, now int64) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var n, ok = self.nodes[push.Node]
+	if !ok {
+		netLog.Infoln("新的节点:", push.Node, push.LocalIp)
+		n = &fleetNode{Node: push.Node}
+		self.nodes[push.Node] = n
+	}
+	n.LocalIp = push.LocalIp
+	n.LastSeen = now
+	n.Snapshots = push.Snapshots
+
+	// 已经结束的分钟写入存储
+	if resultStore == nil {
+		return
+	}
+	if self.stored == nil {
+		self.stored = loadFleetStored(resultStore.dir, now)
+	}
+	for _, s := range push.Snapshots {
+		var name = fleetTargetName(push.Node, s.Name)
+		// 最后一分钟还没结束
+		for i := 0; i+1 < len(s.Minutes); i++ {
+			var m = s.Minutes[i]
+			if m.Time <= self.stored[name] || m.Counts.Sent+m.Counts.Received+m.Counts.Lost+m.Counts.Unsent == 0 {
+				continue
+			}
+			self.stored[name] = m.Time
+			resultStore.AppendRollup(&rollupRecord{
+				Time:    m.Time,
+				Span:    int64(time.Minute / time.Millisecond),
+				Target:  name,
+				Proto:   s.Proto,
+				Counts:  m.Counts,
+				RttSum:  int64(m.Latency.Avg * float64(m.Latency.Count)),
+				Latency: m.Latency,
+			})
+		}
+	}
+}
+
+// 删除很久没有推送的节点
+func (self *fleetRegistry) Expire(now int64) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for name, n := range self.nodes {
+		if now-n.LastSeen <= int64(collectorForgetTime/time.Millisecond) {
+			continue
+		}
+		netLog.Warnln("节点很久没有推送，删除:", name, n.LocalIp)
+		delete(self.nodes, name)
+		for _, s := range n.Snapshots {
+			var target = fleetTargetName(name, s.Name)
+			delete(self.stored, target)
+			if alerts != nil {
+				alerts.Forget(target)
+			}
+		}
+	}
+}
+
+// 所有节点，按名字排序
+func (self *fleetRegistry) Nodes() []fleetNode {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var ret []fleetNode
+	for _, n := range self.nodes {
+		ret = append(ret, *n)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Node < ret[j].Node
+	})
+	return ret
+}
+
+// 汇总服务器上的目标名字：节点/目标
+func fleetTargetName(node, target string) string {
+	return node + "/" + target
+}
+
+// 所有节点的快照，名字换成 节点/目标，用来计算告警和汇报
+func fleetSnapshots() []*statsSnapshot {
+	var ret []*statsSnapshot
+	for _, n := range fleet.Nodes() {
+		for _, s := range n.Snapshots {
+			var v = *s
+			v.Name = fleetTargetName(n.Node, s.Name)
+			// 用收到的时间，不受节点时钟的影响
+			v.Time = n.LastSeen
+			ret = append(ret, &v)
+		}
+	}
+	return ret
+}
+
+// 汇报、告警和网页使用的快照，汇总服务器上是所有节点的，其他是本节点的
+func reportSnapshots(reset bool) []*statsSnapshot {
+	if currentConfig().Role == ERoleCollector {
+		return fleetSnapshots()
+	}
+	return snapshotAll(reset)
+}
+
+// 每个节点一行
+func fleetReport(now int64) []string {
+	var lines []string
+	for _, n := range fleet.Nodes() {
+		var problems []string
+		for _, s := range n.Snapshots {
+			var w = s.Window(time.Minute)
+			if !s.Connected || w.Counts.HasProblem() {
+				problems = append(problems, fmt.Sprintf("%s(丢包率=%.2f%%, 断网:%d)", s.Name, w.Counts.LossPercent(),
+					w.Counts.Unsent+w.Counts.Silence))
+			}
+		}
+		var state = "在线"
+		if now-n.LastSeen > int64(collectorStaleTime/time.Millisecond) {
+			state = "掉线"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s, %d秒前上报, 目标:%d, 有问题的目标: %s", n.Node, n.LocalIp, state,
+			(now-n.LastSeen)/1000, len(n.Snapshots), strings.Join(problems, ", ")))
+	}
+	return lines
+}
+
+func handleCollectorPush(w http.ResponseWriter, r *http.Request) {
+	defer CheckPanic(netLog)
+
+	var cfg = currentConfig()
+	if cfg.Role != ERoleCollector {
+		http.Error(w, "not a collector", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(cfg.CollectorToken) > 0 && r.Header.Get("Authorization") != "Bearer "+cfg.CollectorToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var push collectorPush
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<20)).Decode(&push); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(push.Node) == 0 {
+		http.Error(w, "node name required", http.StatusBadRequest)
+		return
+	}
+	fleet.Update(&push, TimeNowMs())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type fleetTargetView struct {
+	Name      string  `json:"name"`
+	Proto     string  `json:"proto"`
+	Addr      string  `json:"addr"`
+	Connected bool    `json:"connected"`
+	Loss      float64 `json:"loss"`
+	P50       int64   `json:"p50"`
+	P99       int64   `json:"p99"`
+}
+
+type fleetNodeView struct {
+	Node     string            `json:"node"`
+	LocalIp  string            `json:"localIp"`
+	LastSeen int64             `json:"lastSeen"`
+	Online   bool              `json:"online"`
+	Targets  []fleetTargetView `json:"targets"`
+}
+
+// 所有节点的概况，最近一分钟
+func handleFleet(w http.ResponseWriter, r *http.Request) {
+	defer CheckPanic(netLog)
+
+	var now = TimeNowMs()
+	var ret = []fleetNodeView{}
+	for _, n := range fleet.Nodes() {
+		var v = fleetNodeView{Node: n.Node, LocalIp: n.LocalIp, LastSeen: n.LastSeen,
+			Online: now-n.LastSeen <= int64(collectorStaleTime/time.Millisecond), Targets: []fleetTargetView{}}
+		for _, s := range n.Snapshots {
+			var win = s.Window(time.Minute)
+			v.Targets = append(v.Targets, fleetTargetView{Name: s.Name, Proto: s.Proto, Addr: s.Addr, Connected: s.Connected,
+				Loss: win.Counts.LossPercent(), P50: win.Latency.P50, P99: win.Latency.P99})
+		}
+		ret = append(ret, v)
+	}
+
+	var data, _ = json.Marshal(ret)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(data)
+}
+
+var collectorClient = &http.Client{Timeout: 5 * time.Second}
+var collectorPushing int32
+
+// 把本节点的快照推送到汇总服务器，上一次还没推送完时跳过
+func pushToCollector(localIp string, snaps []*statsSnapshot) {
+	if !atomic.CompareAndSwapInt32(&collectorPushing, 0, 1) {
+		return
+	}
+
+	var cfg = currentConfig()
+	go func() {
+		defer atomic.StoreInt32(&collectorPushing, 0)
+		defer CheckPanic(netLog)
+
+		var data, err = json.Marshal(&collectorPush{
+			Node:      meshNodeName(&cfg),
+			LocalIp:   localIp,
+			Time:      TimeNowMs(),
+			Snapshots: snaps,
+		})
+		if err != nil {
+			netLog.Warnln("推送到汇总服务器失败:", err.Error())
+			return
+		}
+
+		var req, _ = http.NewRequest(http.MethodPost, cfg.CollectorUrl, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		if len(cfg.CollectorToken) > 0 {
+			req.Header.Set("Authorization", "Bearer "+cfg.CollectorToken)
+		}
+		resp, err := collectorClient.Do(req)
+		if err != nil {
+			netLog.Warnln("推送到汇总服务器失败:", err.Error())
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			netLog.Warnln("推送到汇总服务器失败:", resp.Status)
+		}
+	}()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// 节点推送的快照：从start开始的n分钟，每分钟收到10个包
+func fleetPush(node string, start int64, n int) *collectorPush {
+	var s = &statsSnapshot{Name: "t", Proto: "tcp"}
+	for i := 0; i < n; i++ {
+		s.Minutes = append(s.Minutes, minuteSnapshot{Time: start + int64(i)*60000, Counts: probeCounts{Sent: 10, Received: 10},
+			Latency: latencySummary{Count: 10, Min: 1, Max: 9, Avg: 5, P50: 5, P90: 8, P99: 9, P999: 9}})
+	}
+	return &collectorPush{Node: node, LocalIp: "10.0.0.1", Snapshots: []*statsSnapshot{s}}
+}
+
+// 把推送的汇总写入文件
+func drainStore(s *probeStore, now time.Time) {
+	for len(s.rollups) > 0 {
+		s.writeRollup(<-s.rollups)
+	}
+	s.flushBuckets(now, true)
+	for _, w := range s.writers {
+		w.Close()
+	}
+}
+
+func TestFleetStoredSurvivesRestart(t *testing.T) {
+	var dir = tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	defer func() { resultStore = nil }()
+
+	var now = time.Now()
+	var start = now.Truncate(time.Minute).Add(-10 * time.Minute)
+	var push = fleetPush("n1", storeMs(start), 10)
+
+	resultStore = newProbeStore(dir, defaultStoreTiers)
+	var registry = &fleetRegistry{nodes: make(map[string]*fleetNode)}
+	registry.Update(push, storeMs(now))
+	registry.Update(push, storeMs(now))
+	drainStore(resultStore, now)
+
+	// 重启以后收到同样的快照，不再写入
+	resultStore = newProbeStore(dir, defaultStoreTiers)
+	registry = &fleetRegistry{nodes: make(map[string]*fleetNode)}
+	registry.Update(push, storeMs(now))
+	if n := len(resultStore.rollups); n != 0 {
+		t.Fatalf("rewrote %d minutes after restart", n)
+	}
+	// 新的一分钟照常写入
+	registry.Update(fleetPush("n1", storeMs(start), 11), storeMs(now))
+	if n := len(resultStore.rollups); n != 1 {
+		t.Fatalf("wrote %d new minutes, want 1", n)
+	}
+	drainStore(resultStore, now)
+
+	var minutes int
+	var times = make(map[int64]bool)
+	readStoreTier(dir, findStoreTier(defaultStoreTiers, storeTierMinute), storeMs(start), storeMs(now), func(d *storeDecoder, kind int64) {
+		var rec = decodeRollupRecord(d)
+		if times[rec.Time] {
+			t.Errorf("minute %d written twice", rec.Time)
+		}
+		times[rec.Time] = true
+		minutes++
+	})
+	// 最后一分钟还没结束，不写
+	if minutes != 10 {
+		t.Fatalf("minutes = %d, want 10", minutes)
+	}
+}
+
+func TestStoreRollupToHour(t *testing.T) {
+	var dir = tempStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	var s = newProbeStore(dir, defaultStoreTiers)
+	var hour = time.Now().Truncate(time.Hour)
+	for i := 0; i < 3; i++ {
+		s.writeRollup(&rollupRecord{Time: storeMs(hour) + int64(i)*60000, Span: 60000, Target: "n1/t", Proto: "tcp",
+			Counts: probeCounts{Received: 10, Lost: int64(i)}, RttSum: 50,
+			Latency: latencySummary{Count: 10, Min: int64(1 + i), Max: int64(9 + i), P50: 5, P90: 8, P99: 9, P999: 9}})
+	}
+	drainStore(s, hour)
+
+	var got []*rollupRecord
+	readRollupRecords(dir, storeTierHour, storeMs(hour), storeMs(hour)+3600000, func(rec *rollupRecord) {
+		got = append(got, rec)
+	})
+	if len(got) != 1 {
+		t.Fatalf("hour rollups = %d, want 1", len(got))
+	}
+	var h = got[0]
+	if h.Time != storeMs(hour) || h.Span != 3600000 || h.Counts.Received != 30 || h.Counts.Lost != 3 ||
+		h.Latency.Count != 30 || h.Latency.Min != 1 || h.Latency.Max != 11 || h.Latency.Avg != 5 {
+		t.Fatalf("hour rollup = %+v", h)
+	}
+}
+
+func TestFleetExpire(t *testing.T) {
+	var registry = &fleetRegistry{nodes: make(map[string]*fleetNode)}
+	var now = int64(100000000)
+	registry.Update(fleetPush("old", now, 1), now)
+	registry.Update(fleetPush("new", now, 1), now+int64(collectorForgetTime/time.Millisecond))
+
+	registry.Expire(now + int64(collectorForgetTime/time.Millisecond) + 1)
+	var nodes = registry.Nodes()
+	if len(nodes) != 1 || nodes[0].Node != "new" {
+		t.Fatalf("nodes = %+v, want only new", nodes)
+	}
+}
+
+// 汇总服务器的报告里是各个节点推送的目标
+func TestFleetDigest(t *testing.T) {
+	var dir = tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	defer func(old *fleetRegistry) { fleet = old; resultStore = nil }(fleet)
+
+	var now = time.Now().Truncate(time.Minute)
+	var push = fleetPush("n1", storeMs(now.Add(-10*time.Minute)), 10)
+	push.Snapshots[0].Addr = "10.0.0.2:7000"
+
+	resultStore = newProbeStore(dir, defaultStoreTiers)
+	fleet = &fleetRegistry{nodes: make(map[string]*fleetNode)}
+	fleet.Update(push, storeMs(now))
+	drainStore(resultStore, now)
+
+	var data = newDigestData("127.0.0.1", &digestSchedule{Kind: "daily"}, now)
+	var target *digestTarget
+	for _, v := range data.Targets {
+		if v.Name == fleetTargetName("n1", "t") {
+			target = v
+		}
+	}
+	if target == nil || target.Addr != "10.0.0.2:7000" || target.Current.ObservedMinutes != 9 || target.Current.Counts.Received != 90 {
+		t.Fatalf("targets = %+v", data.Targets)
+	}
+}
@@ -0,0 +1,713 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:59
+ * Comment: 读取和检查配置文件
+ *          检查语法、协议、地址、角色和数值范围，所有的问题一起报告，带上行号
+ *          配置文件有错误时不会改写它，需要模板时用 init-config 生成
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// 配置文件里的一个问题，Line为0时表示不在文件里（命令行参数或者默认值）
+type configIssue struct {
+	Path    string
+	Line    int
+	Section string
+	Key     string
+	Message string
+}
+
+func (self *configIssue) String() string {
+	var where = self.Path
+	if self.Line > 0 {
+		where += ":" + strconv.Itoa(self.Line)
+	}
+	if len(self.Section) == 0 {
+		return fmt.Sprintf("%s: %s", where, self.Message)
+	}
+	var name = "[" + self.Section + "]"
+	if len(self.Key) > 0 {
+		name += " " + self.Key
+	}
+	return fmt.Sprintf("%s: %s: %s", where, name, self.Message)
+}
+
+// 所有的问题，一行一个
+type configErrors []*configIssue
+
+func (self configErrors) Error() string {
+	var lines []string
+	for _, v := range self {
+		lines = append(lines, v.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+type configChecker struct {
+	path string
+
+	// 配置段所在的行，键是配置段的名字
+	sections map[string]int
+
+	// 配置项所在的行，键是 配置段.名字
+	keys map[string]int
+
+	// 命令行参数覆盖的[main]里的项
+	overrides map[string]string
+
+	// 环境变量覆盖的项，配置段.名字 -> 环境变量
+	envs map[string]string
+
+	issues configErrors
+}
+
+func newConfigChecker(path string, overrides map[string]string) *configChecker {
+	return &configChecker{
+		path:      path,
+		sections:  make(map[string]int),
+		keys:      make(map[string]int),
+		overrides: overrides,
+	}
+}
+
+func (self *configChecker) add(section, key string, format string, args ...interface{}) {
+	var issue = &configIssue{Path: self.path, Section: section, Key: key, Message: fmt.Sprintf(format, args...)}
+	if _, ok := self.overrides[key]; ok && section == "main" && len(key) > 0 {
+		issue.Path = "命令行参数"
+	} else if env, ok := self.envs[section+"."+key]; ok && len(key) > 0 {
+		issue.Path = "环境变量" + env
+	} else if line, ok := self.keys[section+"."+key]; ok && len(key) > 0 {
+		issue.Line = line
+	} else {
+		issue.Line = self.sections[section]
+	}
+	self.issues = append(self.issues, issue)
+}
+
+// 逐行检查语法，记下每个配置段和配置项所在的行
+// 返回去掉了有问题的行（换成空行，行号不变）的内容，其他的配置照常检查
+func (self *configChecker) scan(data []byte) []byte {
+	var section = ini.DefaultSection
+	// 配置段的名字有问题时，这一段都不读，免得算到上一个配置段里
+	var skip bool
+	var out bytes.Buffer
+	var scanner = bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var text = strings.TrimSpace(scanner.Text())
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if line > 1 {
+			out.WriteString("\n")
+		}
+		if len(text) == 0 || text[0] == ';' || text[0] == '#' {
+			continue
+		}
+
+		if text[0] == '[' {
+			var end = strings.IndexByte(text, ']')
+			if end < 0 {
+				section = strings.TrimSpace(text[1:])
+				skip = true
+				self.issues = append(self.issues, &configIssue{Path: self.path, Line: line, Section: section, Message: "配置段缺少]: " + text})
+				continue
+			}
+			skip = false
+			section = strings.TrimSpace(text[1:end])
+			if prev, ok := self.sections[section]; ok {
+				// ini会把同名的配置段合并成一个，多半是复制的时候忘了改名字
+				self.issues = append(self.issues, &configIssue{Path: self.path, Line: line, Section: section,
+					Message: fmt.Sprintf("重复的配置段，第%d行已经有了", prev)})
+			} else {
+				self.sections[section] = line
+			}
+			out.WriteString(text)
+			continue
+		}
+
+		var idx = strings.IndexAny(text, "=:")
+		if idx <= 0 {
+			self.issues = append(self.issues, &configIssue{Path: self.path, Line: line, Section: section, Message: "应该是 名字 = 值: " + text})
+			continue
+		}
+		var key = strings.TrimSpace(text[:idx])
+		if prev, ok := self.keys[section+"."+key]; ok {
+			self.issues = append(self.issues, &configIssue{Path: self.path, Line: line, Section: section, Key: key,
+				Message: fmt.Sprintf("重复的配置项，第%d行已经有了", prev)})
+			continue
+		}
+		self.keys[section+"."+key] = line
+		if !skip {
+			out.WriteString(text)
+		}
+	}
+	return out.Bytes()
+}
+
+// 配置段里的每一项都要是v里的字段，值的类型要对
+func (self *configChecker) checkKeys(section *ini.Section, v interface{}) {
+	var t = reflect.TypeOf(v).Elem()
+	for _, key := range section.Keys() {
+		var f, ok = t.FieldByName(key.Name())
+		if !ok || f.Tag.Get("ini") == "-" {
+			self.add(section.Name(), key.Name(), "未知的配置项（注意大小写）")
+			continue
+		}
+
+		var value = strings.TrimSpace(key.String())
+		if len(value) == 0 {
+			continue
+		}
+		var err error
+		switch f.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			_, err = strconv.ParseInt(value, 10, 64)
+		case reflect.Float32, reflect.Float64:
+			_, err = strconv.ParseFloat(value, 64)
+		case reflect.Bool:
+			_, err = strconv.ParseBool(value)
+		}
+		if err != nil {
+			self.add(section.Name(), key.Name(), "应该是%s: %s", f.Type.Kind(), value)
+		}
+	}
+}
+
+// 地址的格式：主机:端口，服务器可以省略主机
+func (self *configChecker) checkAddr(section, key, addr string) {
+	var _, port, err = net.SplitHostPort(addr)
+	if err != nil {
+		self.add(section, key, "地址应该是 主机:端口: %s", addr)
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		self.add(section, key, "无效的端口: %s", addr)
+	}
+}
+
+// 探测相关的配置，[main]和[target.xxx]一样
+func (self *configChecker) checkProbe(section string, t *TargetConfig, needAddr bool) {
+	switch strings.ToLower(t.Proto) {
+	case "tcp", "udp":
+	default:
+		self.add(section, "Proto", "协议只能是tcp, udp: %s", t.Proto)
+	}
+
+	if len(t.ServerAddr) > 0 {
+		self.checkAddr(section, "ServerAddr", t.ServerAddr)
+	} else if needAddr {
+		self.add(section, "ServerAddr", "没有配置服务器地址")
+	}
+
+	if t.MaxWaitTime < 0 {
+		self.add(section, "MaxWaitTime", "不能是负数: %d", t.MaxWaitTime)
+	}
+	if t.ProbeTimeout < 0 {
+		self.add(section, "ProbeTimeout", "不能是负数: %d", t.ProbeTimeout)
+	}
+	// 超过了探测包收不到，只会算成丢包
+	var maxStuffing = maxProbeStuffing(t.Proto)
+	if t.StuffingCount < 0 {
+		self.add(section, "StuffingCount", "不能是负数: %d", t.StuffingCount)
+	} else if t.StuffingCount > maxStuffing {
+		self.add(section, "StuffingCount", "%s最多%d: %d", t.Proto, maxStuffing, t.StuffingCount)
+	}
+	if t.ProbeInterval != 0 && t.ProbeInterval < MinProbeInterval {
+		self.add(section, "ProbeInterval", "最小是%d: %d", MinProbeInterval, t.ProbeInterval)
+	}
+	if t.ProbeBurst < 0 {
+		self.add(section, "ProbeBurst", "不能是负数: %d", t.ProbeBurst)
+	}
+	// 泊松分布的间隔有长有短，留出余量
+	if n := probeIdsInFlight(t.ProbeInterval, t.ProbeBurst, t.ProbeTimeout); n >= probeIdModulo/4 {
+		self.add(section, "ProbeBurst", "发包太快，一个超时时间内发出%d个包，探测包的Id会在超时之前重复", n)
+	}
+
+	switch strings.ToLower(t.ProbeMode) {
+	case "", "fixed", "poisson":
+	default:
+		self.add(section, "ProbeMode", "只能是fixed, poisson: %s", t.ProbeMode)
+	}
+
+	for _, v := range splitList(t.ProbeSizes) {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			self.add(section, "ProbeSizes", "无效的包大小: %s", v)
+		} else if probeSizeStuffing(n) > maxStuffing {
+			self.add(section, "ProbeSizes", "%s的包大小最大%d字节: %s", t.Proto, maxStuffing*ptAckStuffingSize, v)
+		}
+	}
+}
+
+// [main]
+func (self *configChecker) checkMain(cfg *GlobalConfig, hasTargets bool) {
+	var section = "main"
+	if cfg.Role < ERoleClient || cfg.Role > ERoleCollector {
+		self.add(section, "Role", "只能是1（客户端），2（服务器），3（网状模式），4（汇总服务器）: %d", cfg.Role)
+	}
+
+	// 只有客户端配置了[target.xxx]时不需要ServerAddr
+	var needAddr = cfg.Role == ERoleServer || cfg.Role == ERoleMesh || (cfg.Role == ERoleClient && !hasTargets)
+	self.checkProbe(section, newTargetConfig(cfg.ServerAddr, cfg), needAddr)
+
+	if cfg.Role == ERoleMesh {
+		var peers = parsePeers(cfg.Peers)
+		if len(peers) == 0 {
+			self.add(section, "Peers", "网状模式需要配置Peers")
+		}
+		for _, p := range peers {
+			self.checkAddr(section, "Peers", p.Addr)
+		}
+	}
+
+	if len(cfg.HttpAddr) > 0 {
+		self.checkAddr(section, "HttpAddr", cfg.HttpAddr)
+	} else if cfg.Role == ERoleCollector {
+		self.add(section, "HttpAddr", "汇总服务器需要配置HttpAddr")
+	}
+
+	if len(cfg.CollectorUrl) > 0 {
+		var u, err = url.Parse(cfg.CollectorUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			self.add(section, "CollectorUrl", "应该是 http://主机:端口/api/push: %s", cfg.CollectorUrl)
+		}
+	}
+
+	for _, v := range []struct {
+		key   string
+		value int
+	}{
+		{"LogMaxCount", cfg.LogMaxCount},
+		{"NotifyBatchDelay", cfg.NotifyBatchDelay},
+		{"NotifyRateLimit", cfg.NotifyRateLimit},
+		{"StoreRawDays", cfg.StoreRawDays},
+		{"StoreMinuteDays", cfg.StoreMinuteDays},
+		{"StoreHourDays", cfg.StoreHourDays},
+	} {
+		if v.value < 0 {
+			self.add(section, v.key, "不能是负数: %d", v.value)
+		}
+	}
+
+	switch strings.ToLower(cfg.ResultFormat) {
+	case "", "json", "csv":
+	default:
+		self.add(section, "ResultFormat", "只能是json, csv: %s", cfg.ResultFormat)
+	}
+
+	if _, err := parseDigestSchedules(cfg.DigestSchedule); err != nil {
+		self.add(section, "DigestSchedule", "%s", err.Error())
+	} else if cfg.Role == ERoleCollector && len(cfg.DigestSchedule) > 0 && len(cfg.StoreDir) == 0 {
+		self.add(section, "DigestSchedule", "汇总服务器的日报和周报从存储读取，需要配置StoreDir")
+	}
+}
+
+// 其他的配置段
+func (self *configChecker) checkSections(iniCfg *ini.File, cfg *GlobalConfig) {
+	for _, section := range iniCfg.Sections() {
+		var name = section.Name()
+		switch {
+		case name == "main":
+			self.checkKeys(section, &GlobalConfig{})
+
+		case strings.HasPrefix(name, targetSectionPrefix):
+			var t = newTargetConfig(strings.TrimPrefix(name, targetSectionPrefix), cfg)
+			self.checkKeys(section, t)
+			section.MapTo(t)
+			if len(strings.TrimSpace(t.Name)) == 0 {
+				self.add(name, "", "探测目标需要名字: [target.名字]")
+			}
+			// 网状模式下和节点同名的配置段，地址用Peers里的
+			var needAddr = true
+			if cfg.Role == ERoleMesh && isMeshPeerName(cfg.Peers, t.Name) {
+				needAddr = false
+				if t.Name == meshNodeName(cfg) {
+					self.add(name, "", "网状模式不探测本节点自己")
+				}
+			}
+			self.checkProbe(name, t, needAddr)
+
+		case strings.HasPrefix(name, notifySectionPrefix):
+			var n = &NotifierConfig{Name: strings.TrimPrefix(name, notifySectionPrefix)}
+			self.checkKeys(section, n)
+			section.MapTo(n)
+			if _, err := newNotifier(n); err != nil {
+				self.add(name, "", "%s", err.Error())
+			}
+
+		case strings.HasPrefix(name, alertSectionPrefix):
+			var a = &AlertConfig{Name: strings.TrimPrefix(name, alertSectionPrefix)}
+			self.checkKeys(section, a)
+			section.MapTo(a)
+			if _, err := newAlertRule(a); err != nil {
+				self.add(name, "", "%s", err.Error())
+			}
+
+		case name == ini.DefaultSection:
+			for _, key := range section.Keys() {
+				self.add(name, key.Name(), "配置项要写在[main]里")
+			}
+
+		default:
+			self.add(name, "", "未知的配置段")
+		}
+	}
+}
+
+// 检查通过的配置，由publishConfig一起生效
+type loadedConfig struct {
+	global          GlobalConfig
+	notifiers       []INotifier
+	rules           []*alertRule
+	templates       *notifyTemplates
+	digestSchedules []*digestSchedule
+	digestTemplates *notifyTemplates
+
+	// 配置文件里写的Role，重新加载时Role被覆盖成原来的值，用这个判断配置文件里是不是改了
+	fileRole ERole
+}
+
+// 保护globalConfig、notifiers、templates、digestSchedules和digestTemplates，
+// 重新加载时在写锁里整体替换，其他协程用currentConfig这些函数读取
+var configMutex sync.RWMutex
+
+// 读取配置文件并生效，overrides覆盖main段里的同名项
+// 有问题时返回configErrors，包含所有的问题，原来的配置不变
+func loadConfig(path string, overrides map[string]string) error {
+	var cfg, err = parseConfig(path, overrides)
+	if err != nil {
+		return err
+	}
+	publishConfig(cfg)
+	return nil
+}
+
+// 读取和检查配置文件，不修改正在使用的配置
+func parseConfig(path string, overrides map[string]string) (*loadedConfig, error) {
+	var data, err = ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("配置文件不存在: %s，可以用 init-config 生成", path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var checker = newConfigChecker(path, overrides)
+	var iniCfg *ini.File
+	if format := configFormat(path); format == "ini" {
+		// 语法有问题的行不读，其他的照常检查，所有的问题一起报告
+		iniCfg, err = ini.Load(checker.scan(data))
+	} else {
+		iniCfg, err = parseStructuredConfig(format, data, checker)
+	}
+	if err != nil {
+		checker.issues = append(checker.issues, &configIssue{Path: path, Message: err.Error()})
+		return nil, checker.issues
+	}
+	var envIssues configErrors
+	checker.envs, envIssues = applyEnvOverrides(iniCfg, os.Environ())
+	checker.issues = append(checker.issues, envIssues...)
+	if _, err = iniCfg.GetSection("main"); err != nil {
+		checker.issues = append(checker.issues, &configIssue{Path: path, Section: "main", Message: "没有[main]配置段"})
+		return nil, checker.issues
+	}
+	var fileRole = ERole(iniCfg.Section("main").Key("Role").MustInt(0))
+	for k, v := range overrides {
+		iniCfg.Section("main").Key(k).SetValue(v)
+	}
+
+	var cfg GlobalConfig
+	iniCfg.Section("main").MapTo(&cfg)
+	var hasTargets bool
+	for _, section := range iniCfg.Sections() {
+		hasTargets = hasTargets || strings.HasPrefix(section.Name(), targetSectionPrefix)
+	}
+	checker.checkSections(iniCfg, &cfg)
+	checker.checkMain(&cfg, hasTargets)
+	if _, err := loadNotifyTemplates(cfg.TemplateDir); err != nil {
+		checker.add("main", "TemplateDir", "%s", err.Error())
+	} else if _, err := loadDigestTemplates(cfg.TemplateDir); err != nil {
+		checker.add("main", "TemplateDir", "%s", err.Error())
+	}
+	if len(checker.issues) > 0 {
+		sort.SliceStable(checker.issues, func(i, j int) bool {
+			return checker.issues[i].Line < checker.issues[j].Line
+		})
+		return nil, checker.issues
+	}
+
+	var ret = &loadedConfig{global: cfg, fileRole: fileRole}
+	if ret.global.Role == ERoleMesh {
+		var sections []*TargetConfig
+		if sections, err = loadTargetSections(iniCfg, &ret.global); err == nil {
+			ret.global.Targets = meshTargets(&ret.global, sections)
+		}
+	} else {
+		ret.global.Targets, err = loadTargets(iniCfg, &ret.global)
+	}
+	if err == nil {
+		ret.notifiers, err = loadNotifiers(iniCfg)
+	}
+	if err == nil {
+		ret.rules, err = loadAlertRules(iniCfg)
+	}
+	if err == nil {
+		ret.templates, err = loadNotifyTemplates(ret.global.TemplateDir)
+	}
+	if err == nil {
+		ret.digestSchedules, err = parseDigestSchedules(ret.global.DigestSchedule)
+	}
+	if err == nil {
+		ret.digestTemplates, err = loadDigestTemplates(ret.global.TemplateDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// 检查通过了，生效
+// 没变的日报保持原来的发送时间，告警保留原来的状态
+func publishConfig(cfg *loadedConfig) {
+	configMutex.Lock()
+	var next = make(map[string]time.Time)
+	for _, v := range digestSchedules {
+		next[v.Spec] = v.next
+	}
+	for _, v := range cfg.digestSchedules {
+		v.next = next[v.Spec]
+	}
+	globalConfig = cfg.global
+	notifiers = cfg.notifiers
+	templates = cfg.templates
+	digestSchedules = cfg.digestSchedules
+	digestTemplates = cfg.digestTemplates
+	if cfg.global.LogMaxCount > 0 {
+		atomic.StoreInt32(&MaxKeepLogCount, int32(cfg.global.LogMaxCount))
+	}
+	configMutex.Unlock()
+
+	// 第一次加载在启动时，这时还没有其他协程
+	if alerts == nil {
+		alerts = newAlertManager(cfg.rules)
+	} else {
+		alerts.SetRules(cfg.rules)
+	}
+}
+
+// 正在使用的配置的副本
+func currentConfig() GlobalConfig {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return globalConfig
+}
+
+func currentNotifiers() []INotifier {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return notifiers
+}
+
+func currentTemplates() *notifyTemplates {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return templates
+}
+
+func currentDigestTemplates() *notifyTemplates {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return digestTemplates
+}
+
+// 写一个带注释的配置文件模板，文件已经存在时不覆盖，除非force
+func runInitConfig(args []string) int {
+	var fs = flag.NewFlagSet("init-config", flag.ContinueOnError)
+	var path string
+	var force bool
+	fs.StringVar(&path, "config", "config.ini", "写到哪个文件")
+	fs.BoolVar(&force, "force", false, "文件已经存在时覆盖")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if _, err := os.Stat(path); err == nil && !force {
+		fmt.Fprintf(os.Stderr, "%s 已经存在，不会覆盖，需要覆盖时加 -force\n", path)
+		return exitError
+	}
+	if err := ioutil.WriteFile(path, []byte(configTemplate), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	fmt.Println("已生成配置文件:", path)
+	return exitOk
+}
+
+// init-config生成的模板，和仓库里的config.ini保持一致
+const configTemplate = `;; [main]里的每一项都可以用同名的命令行参数覆盖，例如 network_profiler client -ProbeInterval 100
+;; 子命令client, server, mesh, collector决定Role，check-config检查配置
+;; 环境变量也可以覆盖配置，例如 NETPROF_PROBE_INTERVAL=100, NETPROF_TARGET__GAME1__SERVER_ADDR=10.0.0.1:20201
+;; 配置文件也可以是yaml, toml, json格式，[target.xxx]这些写成嵌套的targets, notify, alerts
+;; 修改后自动重新加载（也可以发SIGHUP），Role、HttpAddr、存储、结果文件和通知队列的设置需要重启才能生效
+[main]
+;; 最长等待时间，单位是毫秒，超过的记在日志里
+MaxWaitTime = 10
+
+;; 探测包超时时间，单位是毫秒，超过这个时间没有返回算丢包
+ProbeTimeout = 3000
+
+;; 协议：tcp, udp
+Proto       = tcp
+
+;; 服务器地址，服务器在这个地址侦听，客户端连接这个地址
+ServerAddr  = 127.0.0.1:20201
+
+;; 1为客户端，2为服务器，3为网状模式（在ServerAddr侦听，同时探测Peers里的其他节点），4为汇总服务器（需要HttpAddr）
+Role        = 1
+
+;; 网状模式下本节点的名字，默认是主机名
+NodeName    =
+
+;; 网状模式下的所有节点，格式：名字=地址，逗号分隔，可以包含自己
+;; 可以用[target.节点名字]单独设置到这个节点的探测参数，其他的[target.xxx]是额外的探测目标
+Peers       =
+
+;; 最多保留几个日志文件
+LogMaxCount = 30
+
+;; 附带的垃圾数据包
+StuffingCount = 100
+
+;; 发包间隔，单位是毫秒，最小10
+ProbeInterval = 1000
+
+;; 每次连续发几个包
+ProbeBurst = 1
+
+;; 发包间隔的分布：fixed, poisson
+ProbeMode = fixed
+
+;; 轮换使用的附带数据大小（字节），逗号分隔，例如 0,512,1400，为空时使用StuffingCount
+;; udp最大1436（超过MTU的包收不到），tcp最大65500
+ProbeSizes =
+
+;; 是否停止通知（所有的通知渠道）
+NotEmail = 0
+
+;; 未发送的通知保存在哪个文件夹，重启后继续发送
+SpoolDir = spool
+
+;; 同一个目标的通知，等多少秒合并成一条再发送
+NotifyBatchDelay = 10
+
+;; 每个通知渠道每分钟最多发几条
+NotifyRateLimit = 6
+
+;; 通知模板所在的文件夹，可以放subject.tmpl, body.txt.tmpl, body.html.tmpl，没有的使用默认模板
+;; 日报和周报的模板文件名前面加digest.，例如digest.body.html.tmpl
+TemplateDir =
+
+;; 日报和周报的发送时间，例如 daily 09:00, weekly mon 09:00，多个用逗号分隔，为空时不发送
+;; 配置了CollectorUrl的节点不发送，由汇总服务器发送所有节点的报告（汇总服务器需要配置StoreDir）
+DigestSchedule =
+
+;; 探测结果的存储文件夹，为空时不保存
+StoreDir = data
+
+;; 每个探测包的原始记录保留几天
+StoreRawDays = 7
+
+;; 每分钟的汇总保留几天
+StoreMinuteDays = 30
+
+;; 每小时的汇总保留几天
+StoreHourDays = 365
+
+;; 每个探测包和连接事件输出一行结构化的结果：json, csv，为空时不输出
+ResultFormat =
+
+;; 结果文件所在的文件夹，和日志一样切割
+ResultDir = results
+
+;; 汇总服务器的地址，例如 http://10.0.0.1:9100/api/push，配置后告警、日报和周报由汇总服务器统一发送
+CollectorUrl =
+
+;; 推送到汇总服务器时使用的令牌，汇总服务器上配置同样的值
+CollectorToken =
+
+;; 内置HTTP服务的地址（网页在/，Prometheus指标在/metrics），为空时不开启
+HttpAddr =
+
+;; 同时探测多个目标时，每个目标一个[target.名字]配置段，名字不能重复，没写的字段沿用[main]里的值
+;; 一个目标都没有配置时，[main]本身就是唯一的目标
+;[target.game1]
+;Proto       = tcp
+;ServerAddr  = 10.0.0.1:20201
+;MaxWaitTime = 50
+;ProbeInterval = 500
+
+;; 通知渠道，每个渠道一个[notify.名字]配置段，Type可以是：
+;; smtp, webhook, slack, dingtalk, wecom, feishu, command
+;[notify.mail]
+;Type     = smtp
+;Host     = smtp.qq.com
+;Port     = 465
+;Account  = someone@qq.com
+;Password = xxxxxx
+;To       = a@qq.com, b@qq.com
+;; ssl或者starttls，465端口默认ssl，其他默认starttls
+;Security = ssl
+
+;[notify.robot]
+;Type   = dingtalk
+;Url    = https://oapi.dingtalk.com/robot/send?access_token=xxxxxx
+;Secret = SECxxxxxx
+
+;[notify.hook]
+;Type    = command
+;; 标题在环境变量NETPROF_SUBJECT中，正文从标准输入传入
+;; 用sh -c执行（Windows上是cmd /C），参数里有空格时加引号
+;Command = /usr/local/bin/on-alert.sh "game servers"
+
+;; 告警规则，每条规则一个[alert.名字]配置段，一条都没有时使用默认规则：
+;;   loss > 2% over 1m, silence > 5 over 1m, corrupt > 0 over 5m, age > 60 over 1m
+;; 指标：loss, avg, p50, p90, p99, p999, max, stddev, jitter, forward, reverse,
+;;       late, duplicate, reorder, corrupt, unsent, silence, disconnect,
+;;       age（快照是多少秒之前的，汇总服务器上用来发现掉线的节点）
+;; 窗口：1m, 5m, 1h
+;[alert.loss]
+;Expr       = loss > 2% over 1m
+;; info, warning, critical
+;Severity   = warning
+;; 条件持续多久才触发
+;For        = 0s
+;; 回差，丢包率降到1.5%以下才算恢复
+;Hysteresis = 0.5
+;; 两次触发通知之间至少间隔多久
+;Cooldown   = 10m
+
+;[alert.slow]
+;Expr     = p99 > 80ms over 1m
+;For      = 3m
+;Severity = critical
+;Targets  = game1
+`
@@ -0,0 +1,626 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 04:00
+ * Comment: 配置文件的其他格式和环境变量
+ *          按扩展名选择格式：.ini（默认）, .yaml/.yml, .toml, .json
+ *          yaml, toml, json都转换成和ini一样的配置段，后面的检查和读取都一样：
+ *            最外层的值        -> [main]，也可以写在main下面
+ *            targets.名字.xxx -> [target.名字]，也可以是列表，每项要有Name
+ *            notify.名字.xxx  -> [notify.名字]
+ *            alerts.名字.xxx  -> [alert.名字]
+ *          列表会用逗号连起来，例如 To: [a@qq.com, b@qq.com]，Peers还可以写成 名字: 地址
+ *          环境变量覆盖配置文件，命令行参数又覆盖环境变量：
+ *            NETPROF_PROBE_INTERVAL=100                 -> [main] ProbeInterval
+ *            NETPROF_TARGET__GAME1__SERVER_ADDR=1.2.3.4:20201 -> [target.game1] ServerAddr
+ *          只能覆盖配置文件里已有的配置段，没有的配置段报告为错误
+ *          名字不区分大小写，下划线可有可无
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v2"
+)
+
+const configEnvPrefix = "NETPROF_"
+
+// 每种配置段对应的结构
+var configSectionTypes = map[string]reflect.Type{
+	"main": reflect.TypeOf(GlobalConfig{}),
+	strings.TrimSuffix(targetSectionPrefix, "."): reflect.TypeOf(TargetConfig{}),
+	strings.TrimSuffix(notifySectionPrefix, "."): reflect.TypeOf(NotifierConfig{}),
+	strings.TrimSuffix(alertSectionPrefix, "."):  reflect.TypeOf(AlertConfig{}),
+}
+
+// 结构化配置里的名字 -> 配置段的类型
+var configSectionAliases = map[string]string{
+	"main":      "main",
+	"target":    "target",
+	"targets":   "target",
+	"notify":    "notify",
+	"notifiers": "notify",
+	"alert":     "alert",
+	"alerts":    "alert",
+}
+
+// 配置文件的格式，按扩展名
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	case ".json":
+		return "json"
+	}
+	return "ini"
+}
+
+// 去掉下划线和横线，转成小写，用来不区分大小写地比较名字
+func normalizeConfigName(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+}
+
+// 找到结构里对应的字段名字，找不到时原样返回，后面检查的时候会报告
+func resolveConfigKey(t reflect.Type, name string) string {
+	var n = normalizeConfigName(name)
+	for i := 0; i < t.NumField(); i++ {
+		var f = t.Field(i)
+		if f.Tag.Get("ini") != "-" && normalizeConfigName(f.Name) == n {
+			return f.Name
+		}
+	}
+	return name
+}
+
+// 读取yaml, toml, json，转换成ini
+// 每个配置段和配置项所在的行记到checker里，结构上的问题也报告给checker，其他部分照常读取
+func parseStructuredConfig(format string, data []byte, checker *configChecker) (*ini.File, error) {
+	var root = make(map[string]interface{})
+	var lines map[string]int
+	var err error
+	switch format {
+	case "yaml":
+		var m map[interface{}]interface{}
+		if err = yaml.Unmarshal(data, &m); err == nil {
+			root = normalizeConfigValue(m).(map[string]interface{})
+		}
+		lines = locateYamlKeys(data)
+	case "toml":
+		_, err = toml.Decode(string(data), &root)
+		lines = locateTomlKeys(data)
+	case "json":
+		err = json.Unmarshal(data, &root)
+		if e, ok := err.(*json.SyntaxError); ok {
+			err = fmt.Errorf("json: line %d: %s", bytes.Count(data[:e.Offset], []byte("\n"))+1, e.Error())
+		}
+		lines = locateJsonKeys(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg = ini.Empty()
+	var main = cfg.Section("main")
+	var mainType = configSectionTypes["main"]
+	for _, k := range sortedConfigKeys(root) {
+		var v = root[k]
+		var kind, isSection = configSectionAliases[strings.ToLower(k)]
+		var m, isMap = v.(map[string]interface{})
+
+		switch {
+		case isSection && kind == "main" && isMap:
+			recordConfigLine(checker.sections, "main", lines[configPath(k)])
+			setConfigKeys(main, m, lines, configPath(k), checker)
+
+		case isSection && kind != "main":
+			var items = configSectionItems(k, v, lines, checker)
+			for _, name := range sortedConfigKeys(items) {
+				var section, _ = cfg.NewSection(kind + "." + name)
+				var path = configPath(k, name)
+				recordConfigLine(checker.sections, section.Name(), lines[path])
+				setConfigKeys(section, items[name].(map[string]interface{}), lines, path, checker)
+			}
+
+		case isMap && normalizeConfigName(k) == "peers":
+			// 名字: 地址
+			var peers []string
+			for _, name := range sortedConfigKeys(m) {
+				peers = append(peers, name+"="+configValueString(m[name]))
+			}
+			var key = resolveConfigKey(mainType, k)
+			main.Key(key).SetValue(strings.Join(peers, ","))
+			recordConfigLine(checker.keys, "main."+key, lines[configPath(k)])
+
+		case isMap:
+			// 不认识的配置段，检查的时候会报告
+			var section, _ = cfg.NewSection(k)
+			recordConfigLine(checker.sections, k, lines[configPath(k)])
+			setConfigKeys(section, m, lines, configPath(k), checker)
+
+		default:
+			var key = resolveConfigKey(mainType, k)
+			main.Key(key).SetValue(configValueString(v))
+			recordConfigLine(checker.keys, "main."+key, lines[configPath(k)])
+		}
+	}
+	return cfg, nil
+}
+
+// targets, notify, alerts下面的每一项，可以是 名字: {...}，也可以是列表，每项有Name
+// 有问题的项报告给checker，跳过
+func configSectionItems(key string, v interface{}, lines map[string]int, checker *configChecker) map[string]interface{} {
+	var ret = make(map[string]interface{})
+	var kind = configSectionAliases[strings.ToLower(key)]
+	var report = func(path string, format string, args ...interface{}) {
+		var line = lines[path]
+		if line == 0 {
+			line = lines[configPath(key)]
+		}
+		checker.issues = append(checker.issues, &configIssue{Path: checker.path, Line: line, Section: kind, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch items := v.(type) {
+	case map[string]interface{}:
+		for name, item := range items {
+			if _, ok := item.(map[string]interface{}); !ok {
+				report(configPath(key, name), "%s.%s应该是一组配置", key, name)
+				continue
+			}
+			ret[name] = item
+		}
+
+	case []interface{}, []map[string]interface{}:
+		var list = reflect.ValueOf(items)
+		for i := 0; i < list.Len(); i++ {
+			// 没有Name的项，行号按位置找
+			var path = configPath(key, "#"+strconv.Itoa(i+1))
+			var item, ok = list.Index(i).Interface().(map[string]interface{})
+			if !ok {
+				report(path, "%s的第%d项应该是一组配置", key, i+1)
+				continue
+			}
+			var name string
+			for k, v := range item {
+				if normalizeConfigName(k) == "name" {
+					name = configValueString(v)
+					delete(item, k)
+				}
+			}
+			if len(name) == 0 {
+				report(path, "%s的第%d项没有Name", key, i+1)
+				continue
+			}
+			if _, ok := ret[name]; ok {
+				report(path, "%s的第%d项和前面的重名: %s", key, i+1, name)
+				continue
+			}
+			ret[name] = item
+		}
+
+	default:
+		report(configPath(key), "%s应该是一组配置或者列表", key)
+	}
+	return ret
+}
+
+// m里的每一项写到section里，path是m在配置文件里的路径
+func setConfigKeys(section *ini.Section, m map[string]interface{}, lines map[string]int, path string, checker *configChecker) {
+	var t = configSectionTypes[strings.SplitN(section.Name(), ".", 2)[0]]
+	for _, k := range sortedConfigKeys(m) {
+		var line = lines[path+configPathSep+k]
+		if _, ok := m[k].(map[string]interface{}); ok {
+			checker.issues = append(checker.issues, &configIssue{Path: checker.path, Line: line, Section: section.Name(), Key: k, Message: "不能再嵌套"})
+			continue
+		}
+		var name = k
+		if t != nil {
+			name = resolveConfigKey(t, k)
+		}
+		section.Key(name).SetValue(configValueString(m[k]))
+		recordConfigLine(checker.keys, section.Name()+"."+name, line)
+	}
+}
+
+// yaml解析出来的map的键是interface{}，统一换成string
+func normalizeConfigValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		var m = make(map[string]interface{})
+		for k, item := range v {
+			m[fmt.Sprint(k)] = normalizeConfigValue(item)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeConfigValue(v[i])
+		}
+	}
+	return v
+}
+
+// 值转成ini里的字符串，列表用逗号连起来
+func configValueString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		// NotEmail这些是数字
+		if v {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			parts = append(parts, configValueString(item))
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+func sortedConfigKeys(m map[string]interface{}) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 用NETPROF_开头的环境变量覆盖配置，返回 配置段.名字 -> 环境变量，用来报告问题
+// 只能覆盖已有的配置段，名字写错时报告问题，不会多出一个配置段
+func applyEnvOverrides(cfg *ini.File, environ []string) (map[string]string, configErrors) {
+	var ret = make(map[string]string)
+	var issues configErrors
+	for _, kv := range environ {
+		var idx = strings.IndexByte(kv, '=')
+		if idx < 0 || !strings.HasPrefix(kv[:idx], configEnvPrefix) {
+			continue
+		}
+		var env, value = kv[:idx], kv[idx+1:]
+
+		// NETPROF_字段 或者 NETPROF_类型__名字__字段
+		var parts = strings.Split(strings.TrimPrefix(env, configEnvPrefix), "__")
+		var sectionName, key string
+		switch len(parts) {
+		case 1:
+			sectionName, key = "main", parts[0]
+		case 3:
+			var kind, ok = configSectionAliases[strings.ToLower(parts[0])]
+			if !ok || kind == "main" {
+				issues = append(issues, &configIssue{Path: "环境变量" + env, Message: "未知的配置段类型，只能是target, notify, alert: " + parts[0]})
+				continue
+			}
+			// 名字不区分大小写，匹配已有的配置段
+			for _, s := range cfg.Sections() {
+				if strings.EqualFold(s.Name(), kind+"."+parts[1]) {
+					sectionName = s.Name()
+				}
+			}
+			if len(sectionName) == 0 {
+				issues = append(issues, &configIssue{Path: "环境变量" + env, Section: kind + "." + parts[1], Message: "配置文件里没有这个配置段"})
+				continue
+			}
+			key = parts[2]
+		default:
+			issues = append(issues, &configIssue{Path: "环境变量" + env, Message: "应该是 NETPROF_配置项 或者 NETPROF_类型__名字__配置项"})
+			continue
+		}
+
+		var t = configSectionTypes[strings.SplitN(sectionName, ".", 2)[0]]
+		key = resolveConfigKey(t, key)
+		if _, ok := t.FieldByName(key); !ok && sectionName == "main" {
+			// 通知命令会设置NETPROF_SUBJECT这些环境变量，不是配置
+			continue
+		}
+		cfg.Section(sectionName).Key(key).SetValue(value)
+		ret[sectionName+"."+key] = env
+	}
+	return ret, issues
+}
+
+// 结构化配置里的路径，各级的名字用configPathSep连起来，列表里的项是#序号（从1开始），有Name时换成Name
+const configPathSep = "\x00"
+
+func configPath(parts ...string) string {
+	return strings.Join(parts, configPathSep)
+}
+
+// 记下配置段或者配置项所在的行，不知道行号时不记，报告时用配置段的行号
+func recordConfigLine(m map[string]int, name string, line int) {
+	if _, ok := m[name]; !ok && line > 0 {
+		m[name] = line
+	}
+}
+
+// 解析库不提供行号，逐行找到每个路径第一次出现的行
+// items是列表里的项（#序号）的Name，找完以后把路径里的#序号换成Name
+type configLocator struct {
+	lines map[string]int
+	count map[string]int
+	names map[string]string
+}
+
+func newConfigLocator() *configLocator {
+	return &configLocator{
+		lines: make(map[string]int),
+		count: make(map[string]int),
+		names: make(map[string]string),
+	}
+}
+
+func (self *configLocator) record(path []string, line int) {
+	var key = configPath(path...)
+	if _, ok := self.lines[key]; !ok {
+		self.lines[key] = line
+	}
+}
+
+// parent下面的列表新加一项，返回这一项的名字
+func (self *configLocator) item(parent []string) string {
+	var key = configPath(parent...)
+	self.count[key]++
+	return "#" + strconv.Itoa(self.count[key])
+}
+
+// 列表里的一项有Name
+func (self *configLocator) name(item []string, name string) {
+	if len(item) > 0 && strings.HasPrefix(item[len(item)-1], "#") {
+		self.names[configPath(item...)] = name
+	}
+}
+
+func (self *configLocator) result() map[string]int {
+	var ret = make(map[string]int)
+	for key, line := range self.lines {
+		var parts = strings.Split(key, configPathSep)
+		var resolved = make([]string, len(parts))
+		for i := range parts {
+			resolved[i] = parts[i]
+			if name, ok := self.names[configPath(parts[:i+1]...)]; ok {
+				resolved[i] = name
+			}
+		}
+		ret[configPath(parts...)] = line
+		recordConfigLine(ret, configPath(resolved...), line)
+	}
+	return ret
+}
+
+// 去掉引号
+func unquoteConfigName(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// yaml的块格式：用缩进判断层级，- 开头的是列表里的一项
+func locateYamlKeys(data []byte) map[string]int {
+	type frame struct {
+		indent int
+		name   string
+	}
+	var locator = newConfigLocator()
+	var stack []frame
+	var path = func() []string {
+		var ret = make([]string, len(stack))
+		for i, f := range stack {
+			ret[i] = f.name
+		}
+		return ret
+	}
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		var text = strings.TrimRight(raw, " \t\r")
+		var trimmed = strings.TrimLeft(text, " ")
+		if len(trimmed) == 0 || trimmed[0] == '#' || trimmed == "---" {
+			continue
+		}
+		var indent = len(text) - len(trimmed)
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		for trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			stack = append(stack, frame{indent, locator.item(path())})
+			locator.record(path(), i+1)
+			var rest = strings.TrimLeft(trimmed[1:], " ")
+			indent += len(trimmed) - len(rest)
+			trimmed = rest
+		}
+
+		var idx = strings.Index(trimmed, ":")
+		if strings.HasPrefix(trimmed, "\"") || strings.HasPrefix(trimmed, "'") {
+			if end := strings.IndexByte(trimmed[1:], trimmed[0]); end >= 0 {
+				idx = strings.Index(trimmed[end+2:], ":")
+				if idx >= 0 {
+					idx += end + 2
+				}
+			}
+		}
+		if idx <= 0 || (idx+1 < len(trimmed) && trimmed[idx+1] != ' ') {
+			continue
+		}
+		var key = unquoteConfigName(trimmed[:idx])
+		stack = append(stack, frame{indent, key})
+		locator.record(path(), i+1)
+
+		if normalizeConfigName(key) == "name" {
+			var value = strings.TrimSpace(trimmed[idx+1:])
+			if c := strings.Index(value, " #"); c >= 0 {
+				value = value[:c]
+			}
+			locator.name(path()[:len(stack)-1], unquoteConfigName(value))
+		}
+	}
+	return locator.result()
+}
+
+// toml：[表]，[[列表]]，名字 = 值
+func locateTomlKeys(data []byte) map[string]int {
+	var locator = newConfigLocator()
+	var table []string
+	var split = func(s string) []string {
+		var ret []string
+		for _, v := range strings.Split(s, ".") {
+			ret = append(ret, unquoteConfigName(v))
+		}
+		return ret
+	}
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		var text = strings.TrimSpace(raw)
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[[") {
+			if end := strings.Index(text, "]]"); end > 0 {
+				table = split(text[2:end])
+				table = append(table, locator.item(table))
+				locator.record(table, i+1)
+			}
+			continue
+		}
+		if text[0] == '[' {
+			if end := strings.IndexByte(text, ']'); end > 0 {
+				table = split(text[1:end])
+				locator.record(table, i+1)
+			}
+			continue
+		}
+
+		var idx = strings.IndexByte(text, '=')
+		if idx <= 0 {
+			continue
+		}
+		var key = split(text[:idx])
+		var path = append(append([]string(nil), table...), key...)
+		locator.record(path, i+1)
+		if len(key) == 1 && normalizeConfigName(key[0]) == "name" {
+			var value = strings.TrimSpace(text[idx+1:])
+			if c := strings.Index(value, " #"); c >= 0 {
+				value = value[:c]
+			}
+			locator.name(table, unquoteConfigName(value))
+		}
+	}
+	return locator.result()
+}
+
+// json：逐个字符找到每个对象里的名字
+func locateJsonKeys(data []byte) map[string]int {
+	type frame struct {
+		array bool
+		name  string
+		// 列表里下一个字符开始一项
+		next bool
+	}
+	var locator = newConfigLocator()
+	var stack []*frame
+	var path = func() []string {
+		var ret []string
+		for _, f := range stack {
+			if len(f.name) > 0 {
+				ret = append(ret, f.name)
+			}
+		}
+		return ret
+	}
+	// 读取i开始的字符串，返回内容和结束以后的位置
+	var readString = func(i int) (string, int) {
+		var buf []byte
+		for i++; i < len(data) && data[i] != '"'; i++ {
+			if data[i] == '\\' && i+1 < len(data) {
+				i++
+			}
+			buf = append(buf, data[i])
+		}
+		return string(buf), i + 1
+	}
+	var skipSpace = func(i int) int {
+		for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\r' || data[i] == '\n') {
+			i++
+		}
+		return i
+	}
+
+	var line = 1
+	for i := 0; i < len(data); {
+		var c = data[i]
+		if c == '\n' {
+			line++
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ',' || c == ':' {
+			if c == ',' && len(stack) > 0 && stack[len(stack)-1].array {
+				stack[len(stack)-1].next = true
+			}
+			i++
+			continue
+		}
+
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		// 列表里的一项
+		if top != nil && top.array && top.next && c != ']' {
+			top.next = false
+			top.name = ""
+			top.name = locator.item(path())
+			locator.record(path(), line)
+		}
+
+		switch c {
+		case '{':
+			stack = append(stack, &frame{})
+			i++
+		case '[':
+			stack = append(stack, &frame{array: true, next: true})
+			i++
+		case '}', ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			i++
+		case '"':
+			var s, end = readString(i)
+			line += strings.Count(string(data[i:end]), "\n")
+			var after = skipSpace(end)
+			if top != nil && !top.array && after < len(data) && data[after] == ':' {
+				// 对象里的名字
+				top.name = s
+				locator.record(path(), line)
+				if normalizeConfigName(s) == "name" && len(stack) >= 2 && stack[len(stack)-2].array {
+					if v := skipSpace(after + 1); v < len(data) && data[v] == '"' {
+						var value, _ = readString(v)
+						locator.name(path()[:len(path())-1], value)
+					}
+				}
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return locator.result()
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/ini.v1"
+)
+
+// 写入临时的配置文件，检查以后返回所有问题，每个是 行号 配置段 配置项
+func checkConfigText(t *testing.T, name, text string) map[string]bool {
+	var dir, err = ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var path = filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(text), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var ret = make(map[string]bool)
+	_, err = parseConfig(path, nil)
+	var issues, ok = err.(configErrors)
+	if !ok {
+		t.Fatalf("%s: err = %v, want configErrors", name, err)
+	}
+	for _, v := range issues {
+		ret[fmt.Sprintf("%d %s %s", v.Line, v.Section, v.Key)] = true
+	}
+	return ret
+}
+
+func TestConfigCheckerIssues(t *testing.T) {
+	var cases = []struct {
+		name string
+		text string
+		want []string
+	}{
+		// 语法错误以后接着检查，后面的错误一起报告
+		{"a.ini", `[main]
+Proto = tcp
+Role = 9
+ServerAddr = 127.0.0.1:20201
+bad line
+[target.a
+ServerAddr = x
+[target.b]
+Proto = tcp
+ServerAddr = 1.2.3.4:1
+Foo = 1
+Proto = udp
+`, []string{"3 main Role", "5 main ", "6 target.a ", "11 target.b Foo", "12 target.b Proto"}},
+
+		{"a.yaml", `Proto: tcp
+Role: 9
+ServerAddr: 127.0.0.1:20201
+targets:
+  - Name: game1
+    Proto: tcp
+    ServerAddr: 1.2.3.4:1
+  - Proto: tcp
+    ServerAddr: 1.2.3.4:2
+    Name: game2
+    Foo: 1
+  - ServerAddr: 1.2.3.4:3
+notify:
+  mail:
+    Type: nope
+`, []string{"2 main Role", "11 target.game2 Foo", "12 target ", "14 notify.mail "}},
+
+		{"a.toml", `Proto = "tcp"
+ServerAddr = "127.0.0.1:20201"
+
+[[targets]]
+Name = "game1"
+Proto = "tcp"
+ProbeIntervl = 3
+
+[notify.mail]
+Type = "nope"
+`, []string{"7 target.game1 ProbeIntervl", "9 notify.mail "}},
+
+		{"a.json", `{
+  "Proto": "tcp",
+  "ServerAddr": "127.0.0.1:20201",
+  "Role": 9,
+  "targets": [
+    {"Name": "game1", "Proto": "tcp", "ServerAddr": "1.2.3.4:1"},
+    {
+      "Proto": "tcp",
+      "Name": "game2",
+      "Foo": 1
+    }
+  ]
+}
+`, []string{"4 main Role", "10 target.game2 Foo"}},
+
+		{"b.json", "{\n  \"Role\": 1,\n  \"x\": ,\n}\n", []string{"0  "}},
+	}
+	for _, c := range cases {
+		var got = checkConfigText(t, c.name, c.text)
+		for _, w := range c.want {
+			if !got[w] {
+				t.Errorf("%s: missing issue %q, got %v", c.name, w, got)
+			}
+		}
+	}
+}
+
+func TestLocateConfigKeys(t *testing.T) {
+	var yaml = locateYamlKeys([]byte("a: 1\nb:\n  - Name: x\n    c: 2\n  - c: 3\n    name: \"y\"\n"))
+	var toml = locateTomlKeys([]byte("a = 1\n[[b]]\nName = \"x\"\nc = 2\n[[b]]\nc = 3\nname = 'y'\n"))
+	var json = locateJsonKeys([]byte("{\"a\": 1,\n\"b\": [{\"Name\": \"x\",\n\"c\": 2}, {\n\"c\": 3, \"name\": \"y\"}]}"))
+	var cases = []struct {
+		name  string
+		lines map[string]int
+		want  map[string]int
+	}{
+		{"yaml", yaml, map[string]int{"a": 1, "b": 2, "b.x": 3, "b.x.c": 4, "b.y": 5, "b.y.c": 5}},
+		{"toml", toml, map[string]int{"a": 1, "b.x": 2, "b.x.c": 4, "b.y": 5, "b.y.c": 6}},
+		{"json", json, map[string]int{"a": 1, "b": 2, "b.x": 2, "b.x.c": 3, "b.y": 3, "b.y.c": 4}},
+	}
+	for _, c := range cases {
+		for path, line := range c.want {
+			var key = configPath(strings.Split(path, ".")...)
+			if c.lines[key] != line {
+				t.Errorf("%s %s: line %d, want %d", c.name, path, c.lines[key], line)
+			}
+		}
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	var cases = []struct {
+		env     string
+		section string
+		key     string
+		value   string
+		issue   bool
+	}{
+		{"NETPROF_PROBE_INTERVAL=100", "main", "ProbeInterval", "100", false},
+		{"NETPROF_TARGET__GAME1__SERVER_ADDR=1.2.3.4:1", "target.game1", "ServerAddr", "1.2.3.4:1", false},
+		// 通知命令设置的环境变量
+		{"NETPROF_SUBJECT=x", "", "", "", false},
+		// 名字写错了，不能多出一个目标
+		{"NETPROF_TARGET__GAME2__SERVER_ADDR=1.2.3.4:2", "", "", "", true},
+		{"NETPROF_TARGTE__GAME1__SERVER_ADDR=1.2.3.4:3", "", "", "", true},
+		{"NETPROF_TARGET__GAME1=1", "", "", "", true},
+	}
+	for _, c := range cases {
+		var cfg, _ = ini.Load([]byte("[main]\n[target.game1]\nServerAddr = 1.2.3.4:0\n"))
+		var envs, issues = applyEnvOverrides(cfg, []string{c.env})
+		if (len(issues) > 0) != c.issue {
+			t.Errorf("%s: issues %v, want %v", c.env, issues, c.issue)
+		}
+		if len(cfg.Sections()) != 3 {
+			t.Errorf("%s: sections %v", c.env, cfg.SectionStrings())
+		}
+		if len(c.section) == 0 {
+			if len(envs) != 0 {
+				t.Errorf("%s: applied %v", c.env, envs)
+			}
+			continue
+		}
+		if v := cfg.Section(c.section).Key(c.key).String(); v != c.value {
+			t.Errorf("%s: [%s] %s = %q, want %q", c.env, c.section, c.key, v, c.value)
+		}
+	}
+}
+
+// 超过MTU的udp包对方收不到，只会算成丢包
+func TestCheckProbeSizes(t *testing.T) {
+	var cases = []struct {
+		proto    string
+		sizes    string
+		stuffing int
+		issues   []string
+	}{
+		{"udp", "0,512,1400,1436", 100, nil},
+		{"udp", "0,512,1400,4000", 100, []string{"ProbeSizes"}},
+		{"udp", "1437", 100, []string{"ProbeSizes"}},
+		{"udp", "", 360, []string{"StuffingCount"}},
+		{"tcp", "0,512,1400,4000,65500", 16375, nil},
+		{"tcp", "65501,x", 16376, []string{"StuffingCount", "ProbeSizes", "ProbeSizes"}},
+	}
+	for _, c := range cases {
+		var checker = newConfigChecker("a.ini", nil)
+		checker.checkProbe("main", &TargetConfig{Proto: c.proto, ServerAddr: "1.2.3.4:1", ProbeSizes: c.sizes, StuffingCount: c.stuffing}, true)
+		var keys []string
+		for _, v := range checker.issues {
+			keys = append(keys, v.Key)
+		}
+		if strings.Join(keys, ",") != strings.Join(c.issues, ",") {
+			t.Errorf("%s %q %d: issues %v, want %v", c.proto, c.sizes, c.stuffing, checker.issues, c.issues)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:49
+ * Comment: 内置的网页，打开HttpAddr就能看到每个目标的延迟和丢包曲线、连接状态、最近的断开和告警记录
+ *          页面在 / ，数据在 /api/dashboard
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/davyxu/cellnet/util"
+)
+
+func init() {
+	httpMux.HandleFunc("/", handleDashboard)
+	httpMux.HandleFunc("/api/dashboard", handleDashboardData)
+}
+
+type dashboardWindow struct {
+	Window   string  `json:"window"`
+	Received int64   `json:"received"`
+	Lost     int64   `json:"lost"`
+	Loss     float64 `json:"loss"`
+	Avg      float64 `json:"avg"`
+	P50      int64   `json:"p50"`
+	P90      int64   `json:"p90"`
+	P99      int64   `json:"p99"`
+	Max      int64   `json:"max"`
+}
+
+type dashboardMinute struct {
+	Time     int64 `json:"time"`
+	Received int64 `json:"received"`
+	Lost     int64 `json:"lost"`
+	// 没有数据时是-1
+	Loss float64 `json:"loss"`
+	P50  int64   `json:"p50"`
+	P99  int64   `json:"p99"`
+}
+
+type dashboardEvent struct {
+	Time      int64  `json:"time"`
+	Type      string `json:"type"`
+	SessionId int64  `json:"sessionId"`
+	Duration  int64  `json:"duration"`
+}
+
+type dashboardTarget struct {
+	Name        string            `json:"name"`
+	Proto       string            `json:"proto"`
+	Addr        string            `json:"addr"`
+	Connected   bool              `json:"connected"`
+	SessionId   int64             `json:"sessionId"`
+	ConnectTime int64             `json:"connectTime"`
+	Jitter      float64           `json:"jitter"`
+	ClockOffset int64             `json:"clockOffset"`
+	Windows     []dashboardWindow `json:"windows"`
+	Minutes     []dashboardMinute `json:"minutes"`
+	Events      []dashboardEvent  `json:"events"`
+}
+
+type dashboardAlert struct {
+	Time     int64   `json:"time"`
+	Rule     string  `json:"rule"`
+	Expr     string  `json:"expr"`
+	Severity string  `json:"severity"`
+	Target   string  `json:"target"`
+	State    string  `json:"state"`
+	Value    float64 `json:"value"`
+	Duration int64   `json:"duration"`
+}
+
+type dashboardData struct {
+	Time    int64              `json:"time"`
+	LocalIp string             `json:"localIp"`
+	Node    string             `json:"node"`
+	Role    ERole              `json:"role"`
+	Targets []*dashboardTarget `json:"targets"`
+	Firing  []dashboardAlert   `json:"firing"`
+	Alerts  []dashboardAlert   `json:"alerts"`
+}
+
+func newDashboardAlert(ev *alertEvent) dashboardAlert {
+	return dashboardAlert{
+		Time:     ev.Time,
+		Rule:     ev.Rule.Name,
+		Expr:     ev.Rule.Expr,
+		Severity: ev.Rule.Severity,
+		Target:   ev.Target,
+		State:    ev.State,
+		Value:    ev.Value,
+		Duration: ev.Duration,
+	}
+}
+
+func newDashboardData() *dashboardData {
+	var cfg = currentConfig()
+	var ret = &dashboardData{
+		Time:    TimeNowMs(),
+		LocalIp: util.GetLocalIP(),
+		Role:    cfg.Role,
+		Firing:  []dashboardAlert{},
+		Alerts:  []dashboardAlert{},
+	}
+	if cfg.Role == ERoleMesh {
+		ret.Node = meshNodeName(&cfg)
+	}
+
+	for _, s := range reportSnapshots(false) {
+		var t = &dashboardTarget{
+			Name:        s.Name,
+			Proto:       s.Proto,
+			Addr:        s.Addr,
+			Connected:   s.Connected,
+			SessionId:   s.SessionId,
+			ConnectTime: s.ConnectTime,
+			Jitter:      s.Jitter,
+			ClockOffset: s.ClockOffset,
+			Events:      []dashboardEvent{},
+		}
+		for _, w := range s.Windows {
+			t.Windows = append(t.Windows, dashboardWindow{
+				Window:   windowName(w.Window),
+				Received: w.Counts.Received,
+				Lost:     w.Counts.Lost,
+				Loss:     w.Counts.LossPercent(),
+				Avg:      w.Latency.Avg,
+				P50:      w.Latency.P50,
+				P90:      w.Latency.P90,
+				P99:      w.Latency.P99,
+				Max:      w.Latency.Max,
+			})
+		}
+		for _, m := range s.Minutes {
+			var v = dashboardMinute{Time: m.Time, Received: m.Counts.Received, Lost: m.Counts.Lost, Loss: -1,
+				P50: -1, P99: -1}
+			if m.Counts.Received+m.Counts.Lost > 0 {
+				v.Loss = m.Counts.LossPercent()
+			}
+			if m.Latency.Count > 0 {
+				v.P50 = m.Latency.P50
+				v.P99 = m.Latency.P99
+			}
+			t.Minutes = append(t.Minutes, v)
+		}
+		// 最近的在前面
+		for i := len(s.Events) - 1; i >= 0; i-- {
+			var e = s.Events[i]
+			t.Events = append(t.Events, dashboardEvent{Time: e.Time, Type: e.Type, SessionId: e.SessionId, Duration: e.Duration})
+		}
+		ret.Targets = append(ret.Targets, t)
+	}
+
+	if alerts != nil {
+		for _, ev := range alerts.Firing() {
+			ret.Firing = append(ret.Firing, newDashboardAlert(ev))
+		}
+		var history = alerts.History()
+		for i := len(history) - 1; i >= 0; i-- {
+			ret.Alerts = append(ret.Alerts, newDashboardAlert(history[i]))
+		}
+	}
+	return ret
+}
+
+func handleDashboardData(w http.ResponseWriter, r *http.Request) {
+	defer CheckPanic(netLog)
+
+	var data, err = json.Marshal(newDashboardData())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
+
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardHtml))
+}
+
+// 页面只用原生的js和svg，不依赖外部资源，内网也能打开
+const dashboardHtml = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>network-profiler</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", "Microsoft YaHei", sans-serif; margin: 0; background: #f4f5f7; color: #222; }
+header { background: #263238; color: #fff; padding: 10px 16px; display: flex; justify-content: space-between; }
+header small { color: #b0bec5; }
+main { padding: 12px 16px; }
+.card { background: #fff; border-radius: 4px; box-shadow: 0 1px 2px rgba(0,0,0,.1); padding: 12px; margin-bottom: 12px; }
+.targets { display: grid; grid-template-columns: repeat(auto-fill, minmax(520px, 1fr)); gap: 12px; }
+.targets .card { margin: 0; }
+h2 { font-size: 16px; margin: 0 0 8px 0; }
+h3 { font-size: 14px; margin: 8px 0 4px 0; color: #555; }
+table { border-collapse: collapse; width: 100%; font-size: 12px; }
+th, td { border-bottom: 1px solid #eee; padding: 3px 6px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.up { color: #2e7d32; } .down { color: #c62828; }
+.firing { color: #c62828; font-weight: bold; } .resolved { color: #2e7d32; } .suppressed { color: #999; }
+.critical { background: #ffebee; }
+svg { background: #fafafa; width: 100%; height: 90px; }
+.muted { color: #999; font-size: 12px; }
+</style>
+</head>
+<body>
+<header><div><b>network-profiler</b> <small id="node"></small></div><small id="time"></small></header>
+<main>
+<div class="card"><h2>正在触发的告警</h2><div id="firing"></div></div>
+<div class="targets" id="targets"></div>
+<div class="card" style="margin-top:12px"><h2>告警记录</h2><div id="alerts"></div></div>
+</main>
+<script>
+function esc(s) {
+  return String(s).replace(/[&<>"']/g, function (c) {
+    return {"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;"}[c];
+  });
+}
+function fmtTime(ms) {
+  if (!ms) return "-";
+  var d = new Date(ms);
+  function p(n) { return (n < 10 ? "0" : "") + n; }
+  return (d.getMonth() + 1) + "-" + p(d.getDate()) + " " + p(d.getHours()) + ":" + p(d.getMinutes()) + ":" + p(d.getSeconds());
+}
+function fmtDuration(ms) {
+  var s = Math.round(ms / 1000);
+  if (s < 60) return s + "s";
+  if (s < 3600) return Math.floor(s / 60) + "m" + (s % 60) + "s";
+  return Math.floor(s / 3600) + "h" + Math.floor(s % 3600 / 60) + "m";
+}
+// 折线图，值为负数的点表示没有数据，把线断开
+function chart(series, colors, unit) {
+  var w = 480, h = 90, max = 0, n = series[0].length;
+  series.forEach(function (s) { s.forEach(function (v) { if (v > max) max = v; }); });
+  if (max <= 0) max = 1;
+  var out = '<svg viewBox="0 0 ' + w + ' ' + h + '" preserveAspectRatio="none">';
+  series.forEach(function (s, k) {
+    var pts = [];
+    function flush() {
+      if (pts.length > 1) out += '<polyline fill="none" stroke="' + colors[k] + '" stroke-width="1.5" points="' + pts.join(" ") + '"/>';
+      else if (pts.length == 1) { var xy = pts[0].split(","); out += '<circle cx="' + xy[0] + '" cy="' + xy[1] + '" r="1.5" fill="' + colors[k] + '"/>'; }
+      pts = [];
+    }
+    s.forEach(function (v, i) {
+      if (v < 0) { flush(); return; }
+      var x = n > 1 ? i * w / (n - 1) : 0;
+      var y = h - 4 - v / max * (h - 16);
+      pts.push(x.toFixed(1) + "," + y.toFixed(1));
+    });
+    flush();
+  });
+  out += '<text x="4" y="11" font-size="10" fill="#666">max ' + max.toFixed(1) + unit + '</text></svg>';
+  return out;
+}
+function alertTable(list, empty) {
+  if (!list.length) return '<div class="muted">' + empty + '</div>';
+  var out = '<table><tr><th>时间</th><th>状态</th><th>级别</th><th>目标</th><th>规则</th><th>值</th><th>持续</th></tr>';
+  list.forEach(function (a) {
+    out += '<tr class="' + (a.severity == "critical" && a.state == "firing" ? "critical" : "") + '"><td>' + fmtTime(a.time) +
+      '</td><td class="' + esc(a.state) + '">' + esc(a.state) + '</td><td>' + esc(a.severity) + '</td><td>' + esc(a.target) +
+      '</td><td>' + esc(a.rule) + ' <span class="muted">' + esc(a.expr) + '</span></td><td>' + a.value.toFixed(2) +
+      '</td><td>' + (a.duration ? fmtDuration(a.duration) : "-") + '</td></tr>';
+  });
+  return out + '</table>';
+}
+function render(d) {
+  document.getElementById("node").textContent = (d.node ? d.node + " " : "") + d.localIp;
+  document.getElementById("time").textContent = "更新于 " + fmtTime(d.time);
+  document.getElementById("firing").innerHTML = alertTable(d.firing, "没有");
+  document.getElementById("alerts").innerHTML = alertTable(d.alerts, "没有");
+
+  var html = "";
+  (d.targets || []).forEach(function (t) {
+    html += '<div class="card"><h2>' + esc(t.name) + ' <span class="muted">' + esc(t.proto) + ' ' + esc(t.addr) + '</span></h2>';
+    html += '<div>' + (t.connected
+      ? '<span class="up">● 已连接</span> 会话 #' + t.sessionId + '，连接于 ' + fmtTime(t.connectTime) + '（' + fmtDuration(d.time - t.connectTime) + '）'
+      : '<span class="down">● 未连接</span>') +
+      ' <span class="muted">抖动 ' + t.jitter.toFixed(1) + 'ms，时钟偏差 ' + t.clockOffset + 'ms</span></div>';
+
+    html += '<table><tr><th>窗口</th><th>收到</th><th>丢包</th><th>丢包率</th><th>avg</th><th>p50</th><th>p90</th><th>p99</th><th>max</th></tr>';
+    (t.windows || []).forEach(function (w) {
+      html += '<tr><td>' + w.window + '</td><td>' + w.received + '</td><td>' + w.lost + '</td><td>' + w.loss.toFixed(2) + '%</td><td>' +
+        w.avg.toFixed(1) + '</td><td>' + w.p50 + '</td><td>' + w.p90 + '</td><td>' + w.p99 + '</td><td>' + w.max + '</td></tr>';
+    });
+    html += '</table>';
+
+    var mins = t.minutes || [];
+    html += '<h3>最近一小时延迟 <span style="color:#1e88e5">p50</span> <span style="color:#fb8c00">p99</span></h3>';
+    html += chart([mins.map(function (m) { return m.p50; }), mins.map(function (m) { return m.p99; })], ["#1e88e5", "#fb8c00"], "ms");
+    html += '<h3>最近一小时丢包率</h3>';
+    html += chart([mins.map(function (m) { return m.loss; })], ["#e53935"], "%");
+
+    html += '<h3>最近的连接和断开</h3>';
+    if (!t.events.length) html += '<div class="muted">没有</div>';
+    else {
+      html += '<table><tr><th>时间</th><th>事件</th><th>会话</th><th>连接持续</th></tr>';
+      t.events.forEach(function (e) {
+        html += '<tr><td>' + fmtTime(e.time) + '</td><td class="' + (e.type == "disconnect" ? "down" : "up") + '">' + e.type +
+          '</td><td>#' + e.sessionId + '</td><td>' + (e.type == "disconnect" ? fmtDuration(e.duration) : "-") + '</td></tr>';
+      });
+      html += '</table>';
+    }
+    html += '</div>';
+  });
+  document.getElementById("targets").innerHTML = html || '<div class="card muted">没有探测目标</div>';
+}
+function refresh() {
+  fetch("api/dashboard", {cache: "no-store"}).then(function (r) { return r.json(); }).then(render)
+    .catch(function (e) { document.getElementById("time").textContent = "刷新失败: " + e; });
+}
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardData(t *testing.T) {
+	configMutex.Lock()
+	var oldConfig = globalConfig
+	globalConfig = GlobalConfig{Role: ERoleClient}
+	configMutex.Unlock()
+	defer func() {
+		configMutex.Lock()
+		globalConfig = oldConfig
+		configMutex.Unlock()
+		alerts = nil
+		removeTargetStats("dash-a")
+	}()
+
+	var s = getTargetStats("dash-a")
+	s.SetConnected(true, 7)
+	s.RecordRtt(10, 40)
+	s.SetConnected(false, 0)
+	s.SetConnected(true, 8)
+
+	var rule, err = newAlertRule(&AlertConfig{Name: "loss", Expr: "loss > 2% over 1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts = newAlertManager([]*alertRule{rule})
+	var now = TimeNowMs()
+	for i, lost := range []int64{10, 0, 10} {
+		alerts.Evaluate([]*statsSnapshot{{Name: "dash-a", Windows: []windowSnapshot{{Window: time.Minute,
+			Counts: probeCounts{Received: 100 - lost, Lost: lost}}}}}, now+int64(i)*60000)
+	}
+
+	var rec = httptest.NewRecorder()
+	handleDashboardData(rec, httptest.NewRequest("GET", "/api/dashboard", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("content type = %s", ct)
+	}
+	var data dashboardData
+	if err := json.Unmarshal(rec.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	var target *dashboardTarget
+	for _, v := range data.Targets {
+		if v.Name == "dash-a" {
+			target = v
+		}
+	}
+	if target == nil {
+		t.Fatalf("targets = %+v, want dash-a", data.Targets)
+	}
+	if !target.Connected || target.SessionId != 8 || len(target.Windows) == 0 {
+		t.Fatalf("target = %+v", target)
+	}
+
+	var cases = []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		// 最近的在前面
+		{"events", nil, []string{resultTypeReconnect, resultTypeDisconnect, resultTypeConnect}},
+		{"firing", nil, []string{"firing"}},
+		// 冷却中又触发的记为suppressed
+		{"alerts", nil, []string{"suppressed", "resolved", "firing"}},
+	}
+	for _, e := range target.Events {
+		cases[0].got = append(cases[0].got, e.Type)
+	}
+	for _, a := range data.Firing {
+		cases[1].got = append(cases[1].got, a.State)
+	}
+	for _, a := range data.Alerts {
+		cases[2].got = append(cases[2].got, a.State)
+	}
+	for _, c := range cases {
+		if strings.Join(c.got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if target.Events[1].SessionId != 7 || target.Events[1].Duration < 0 {
+		t.Errorf("disconnect = %+v", target.Events[1])
+	}
+}
+
+func TestDashboardRoutes(t *testing.T) {
+	var cases = []struct {
+		path        string
+		status      int
+		contentType string
+	}{
+		{"/", http.StatusOK, "text/html"},
+		{"/api/dashboard", http.StatusOK, "application/json"},
+		{"/nope", http.StatusNotFound, "text/plain"},
+	}
+	for _, c := range cases {
+		var rec = httptest.NewRecorder()
+		httpMux.ServeHTTP(rec, httptest.NewRequest("GET", c.path, nil))
+		if rec.Code != c.status || !strings.HasPrefix(rec.Header().Get("Content-Type"), c.contentType) {
+			t.Errorf("%s: %d %s, want %d %s", c.path, rec.Code, rec.Header().Get("Content-Type"), c.status, c.contentType)
+		}
+	}
+}
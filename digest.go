@@ -0,0 +1,568 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:41
+ * Comment: 日报和周报，按计划汇总每个目标的可用率、断网时间、最差的几个小时和延迟分布，并和上一个周期对比
+ *          DigestSchedule的格式：daily 09:00, weekly mon 09:00，多个用逗号分隔
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 每小时的统计保留多久，周报要和上一周对比，至少两周
+const digestKeep = 15 * 24 * time.Hour
+
+// 报告里列出几个最差的小时
+const digestWorstHours = 3
+
+// 从存储读取时，等最后一分钟的汇总写入以后再发送
+const digestStoreDelay = storeRollupGrace + 5*time.Second
+
+// 本地时间的毫秒数：加上当时的时区偏移，按它对齐的小时就是本地时间的整点（时区偏移不一定是整小时）
+func localMs(ms int64) int64 {
+	var _, offset = time.Unix(0, ms*int64(time.Millisecond)).Zone()
+	return ms + int64(offset)*1000
+}
+
+// localMs的毫秒数对应的本地时间
+func fromLocalMs(ms int64) time.Time {
+	var t = time.Unix(0, ms*int64(time.Millisecond)).UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+}
+
+// 这一分钟是不是断网了：有发包（或者想发包），但是一个都没有收到
+func isOutageMinute(c probeCounts) bool {
+	return c.Received == 0 && c.Sent+c.Unsent+c.Lost+c.Silence > 0
+}
+
+// 按小时统计有数据的分钟数和断网的分钟数
+type rollingUptime struct {
+	slotMs    int64
+	observed  []int
+	outage    []int
+	slotStart []int64
+}
+
+func newRollingUptime(slot time.Duration, keep time.Duration) *rollingUptime {
+	var n = int(keep / slot)
+	if n < 1 {
+		n = 1
+	}
+	return &rollingUptime{
+		slotMs:    int64(slot / time.Millisecond),
+		observed:  make([]int, n),
+		outage:    make([]int, n),
+		slotStart: make([]int64, n),
+	}
+}
+
+// 记录一分钟的结果，minute是这一分钟开始的时间
+func (self *rollingUptime) Add(minute int64, outage bool) {
+	var start = minute - minute%self.slotMs
+	var idx = int(start/self.slotMs) % len(self.slotStart)
+	if self.slotStart[idx] != start {
+		self.slotStart[idx] = start
+		self.observed[idx] = 0
+		self.outage[idx] = 0
+	}
+	self.observed[idx]++
+	if outage {
+		self.outage[idx]++
+	}
+}
+
+// [from, to)之间开始的槽里，有数据的分钟数和断网的分钟数
+func (self *rollingUptime) Range(from, to int64) (int, int) {
+	var observed, outage int
+	for i, start := range self.slotStart {
+		if start >= from && start < to {
+			observed += self.observed[i]
+			outage += self.outage[i]
+		}
+	}
+	return observed, outage
+}
+
+// 一个小时的统计
+type digestHour struct {
+	Time          time.Time
+	Counts        probeCounts
+	Latency       latencySummary
+	OutageMinutes int
+}
+
+// 一个周期的统计
+type digestPeriod struct {
+	From    time.Time
+	To      time.Time
+	Counts  probeCounts
+	Latency latencySummary
+
+	ObservedMinutes int
+	OutageMinutes   int
+	// 可用率（百分比），没有数据时是-1
+	Availability float64
+}
+
+// 丢包率（百分比），没有数据时是-1
+func (self *digestPeriod) Loss() float64 {
+	if self.Counts.Settled() == 0 {
+		return -1
+	}
+	return self.Counts.LossPercent()
+}
+
+// 延迟的百分位数，没有数据时是-1
+func (self *digestPeriod) Pct(q float64) float64 {
+	if self.Latency.Count == 0 {
+		return -1
+	}
+	switch q {
+	case 50:
+		return float64(self.Latency.P50)
+	case 90:
+		return float64(self.Latency.P90)
+	case 99:
+		return float64(self.Latency.P99)
+	case 99.9:
+		return float64(self.Latency.P999)
+	}
+	return -1
+}
+
+// 断网分钟数，没有数据时是-1，用于和上一个周期比较
+func (self *digestPeriod) Outage() float64 {
+	if self.ObservedMinutes == 0 {
+		return -1
+	}
+	return float64(self.OutageMinutes)
+}
+
+// [from, to)这段时间的汇总，以及其中每个小时（本地时间）的统计
+// 每小时的统计按localMs记录，from和to也换成localMs
+func (self *targetStats) Digest(from, to int64) (*digestPeriod, []digestHour) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var ret = &digestPeriod{
+		From:         time.Unix(0, from*int64(time.Millisecond)),
+		To:           time.Unix(0, to*int64(time.Millisecond)),
+		Availability: -1,
+	}
+
+	var rtt = newHistogram()
+	var hours []digestHour
+	var slotMs = self.hourRtt.SlotMs()
+	var lfrom, lto = localMs(from), localMs(to)
+	for start := lfrom - lfrom%slotMs; start < lto; start += slotMs {
+		if start < lfrom {
+			continue
+		}
+		var h = digestHour{
+			Time:   fromLocalMs(start),
+			Counts: self.hourCounts.Range(start, start+slotMs),
+		}
+		var observed int
+		observed, h.OutageMinutes = self.hourUptime.Range(start, start+slotMs)
+		if s := self.hourRtt.SlotAt(start); s != nil {
+			rtt.Merge(s)
+			h.Latency = s.Summary()
+		}
+
+		ret.Counts.Add(h.Counts)
+		ret.ObservedMinutes += observed
+		ret.OutageMinutes += h.OutageMinutes
+		if observed > 0 || h.Counts.Sent > 0 {
+			hours = append(hours, h)
+		}
+	}
+
+	ret.Latency = rtt.Summary()
+	if ret.ObservedMinutes > 0 {
+		ret.Availability = float64(ret.ObservedMinutes-ret.OutageMinutes) * 100 / float64(ret.ObservedMinutes)
+	}
+	return ret, hours
+}
+
+// 从存储里读取[from, to)之间每个目标的每分钟汇总，进程重启以前的数据也在里面
+func readDigestRollups(dir string, from, to int64) map[string][]*rollupRecord {
+	var ret = make(map[string][]*rollupRecord)
+	readRollupRecords(dir, storeTierMinute, from, to, func(rec *rollupRecord) {
+		ret[rec.Target] = append(ret[rec.Target], rec)
+	})
+	return ret
+}
+
+// 用每分钟的汇总计算[from, to)这段时间的汇总，以及其中每个小时（本地时间）的统计
+// 延迟的分位数由每分钟的分位数按包数加权得到，是近似值
+func rollupDigest(recs []*rollupRecord, from, to int64) (*digestPeriod, []digestHour) {
+	var ret = &digestPeriod{
+		From:         time.Unix(0, from*int64(time.Millisecond)),
+		To:           time.Unix(0, to*int64(time.Millisecond)),
+		Availability: -1,
+	}
+
+	var hourMs = int64(time.Hour / time.Millisecond)
+	var total = &rollupRecord{}
+	var hours []digestHour
+	var hour *rollupRecord
+	var hourStart int64
+	var hourOutage int
+	var flush = func() {
+		if hour != nil {
+			hours = append(hours, digestHour{Time: fromLocalMs(hourStart), Counts: hour.Counts, Latency: hour.Latency, OutageMinutes: hourOutage})
+		}
+	}
+	// recs按时间排序
+	for _, rec := range recs {
+		if rec.Time < from || rec.Time >= to {
+			continue
+		}
+		var start = localMs(rec.Time)
+		start -= start % hourMs
+		if hour == nil || start != hourStart {
+			flush()
+			hour = &rollupRecord{}
+			hourStart = start
+			hourOutage = 0
+		}
+		hour.Merge(rec)
+		total.Merge(rec)
+		ret.ObservedMinutes++
+		if isOutageMinute(rec.Counts) {
+			ret.OutageMinutes++
+			hourOutage++
+		}
+	}
+	flush()
+
+	ret.Counts = total.Counts
+	ret.Latency = total.Latency
+	if ret.ObservedMinutes > 0 {
+		ret.Availability = float64(ret.ObservedMinutes-ret.OutageMinutes) * 100 / float64(ret.ObservedMinutes)
+	}
+	return ret, hours
+}
+
+// 最差的几个小时：断网时间最长的，其次丢包率最高的，再次p99最高的
+func worstHours(hours []digestHour, n int) []digestHour {
+	var ret = append([]digestHour(nil), hours...)
+	sort.SliceStable(ret, func(i, j int) bool {
+		var a, b = ret[i], ret[j]
+		if a.OutageMinutes != b.OutageMinutes {
+			return a.OutageMinutes > b.OutageMinutes
+		}
+		if a.Counts.LossPercent() != b.Counts.LossPercent() {
+			return a.Counts.LossPercent() > b.Counts.LossPercent()
+		}
+		return a.Latency.P99 > b.Latency.P99
+	})
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret
+}
+
+// 汇报的计划
+type digestSchedule struct {
+	Spec string
+	// daily或者weekly
+	Kind    string
+	Weekday time.Weekday
+	Hour    int
+	Minute  int
+
+	// 下一次发送的时间，在configMutex的写锁里读写
+	next time.Time
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// 解析 daily 09:00 或者 weekly mon 09:00，只写时间时是daily
+func parseDigestSchedule(spec string) (*digestSchedule, error) {
+	var fields = strings.Fields(strings.ToLower(spec))
+	var ret = &digestSchedule{Spec: spec, Kind: "daily"}
+
+	if len(fields) > 0 && (fields[0] == "daily" || fields[0] == "weekly") {
+		ret.Kind = fields[0]
+		fields = fields[1:]
+	}
+	if ret.Kind == "weekly" {
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("周报需要指定星期几: %s", spec)
+		}
+		var day = fields[0]
+		if len(day) > 3 {
+			day = day[:3]
+		}
+		var weekday, ok = weekdayNames[day]
+		if !ok {
+			return nil, fmt.Errorf("无效的星期: %s", spec)
+		}
+		ret.Weekday = weekday
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return nil, fmt.Errorf("无效的汇报计划: %s", spec)
+	}
+
+	var hm = strings.Split(fields[0], ":")
+	if len(hm) != 2 {
+		return nil, fmt.Errorf("无效的时间: %s", spec)
+	}
+	var err error
+	if ret.Hour, err = strconv.Atoi(hm[0]); err != nil || ret.Hour < 0 || ret.Hour > 23 {
+		return nil, fmt.Errorf("无效的时间: %s", spec)
+	}
+	if ret.Minute, err = strconv.Atoi(hm[1]); err != nil || ret.Minute < 0 || ret.Minute > 59 {
+		return nil, fmt.Errorf("无效的时间: %s", spec)
+	}
+	return ret, nil
+}
+
+// 逗号分隔的多个计划
+func parseDigestSchedules(s string) ([]*digestSchedule, error) {
+	var ret []*digestSchedule
+	for _, v := range splitList(s) {
+		var sched, err = parseDigestSchedule(v)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, sched)
+	}
+	return ret, nil
+}
+
+// 汇报的周期
+func (self *digestSchedule) Period() time.Duration {
+	if self.Kind == "weekly" {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// after之后的下一次发送时间（本地时间）
+func (self *digestSchedule) Next(after time.Time) time.Time {
+	var t = time.Date(after.Year(), after.Month(), after.Day(), self.Hour, self.Minute, 0, 0, after.Location())
+	for !t.After(after) || (self.Kind == "weekly" && t.Weekday() != self.Weekday) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+func (self *digestSchedule) Name() string {
+	if self.Kind == "weekly" {
+		return "周报"
+	}
+	return "日报"
+}
+
+// 报告里的一个目标
+type digestTarget struct {
+	Name  string
+	Proto string
+	Addr  string
+
+	Current    *digestPeriod
+	Previous   *digestPeriod
+	WorstHours []digestHour
+}
+
+// 日报和周报模板的数据
+type digestData struct {
+	LocalIp string
+	Kind    string
+	Name    string
+	Time    time.Time
+	From    time.Time
+	To      time.Time
+	Targets []*digestTarget
+}
+
+// 截止到end的一个周期的报告
+// 有存储时从存储读取，进程重启过也有完整的数据，已经删除的目标也会列出来；没有存储时用内存里的统计
+// 汇总服务器把各个节点推送的每分钟汇总写入存储，报告里是所有节点的目标（节点/目标）
+func newDigestData(localIp string, sched *digestSchedule, end time.Time) *digestData {
+	var to = end.UnixNano() / int64(time.Millisecond)
+	var period = int64(sched.Period() / time.Millisecond)
+	var ret = &digestData{
+		LocalIp: localIp,
+		Kind:    sched.Kind,
+		Name:    sched.Name(),
+		Time:    time.Now(),
+		From:    end.Add(-sched.Period()),
+		To:      end,
+	}
+
+	var stored map[string][]*rollupRecord
+	if resultStore != nil {
+		stored = readDigestRollups(resultStore.dir, to-2*period, to)
+	}
+
+	var targets = make(map[string]*digestTarget)
+	for _, s := range listTargetStats() {
+		var snap = s.Snapshot(false)
+		var t = &digestTarget{Name: s.Name, Proto: snap.Proto, Addr: snap.Addr}
+		if stored == nil {
+			var hours []digestHour
+			t.Current, hours = s.Digest(to-period, to)
+			t.Previous, _ = s.Digest(to-2*period, to-period)
+			t.WorstHours = worstHours(hours, digestWorstHours)
+		}
+		targets[s.Name] = t
+	}
+	for name, recs := range stored {
+		var t = targets[name]
+		if t == nil {
+			t = &digestTarget{Name: name, Proto: recs[len(recs)-1].Proto}
+			targets[name] = t
+		}
+		var hours []digestHour
+		t.Current, hours = rollupDigest(recs, to-period, to)
+		t.Previous, _ = rollupDigest(recs, to-2*period, to-period)
+		t.WorstHours = worstHours(hours, digestWorstHours)
+	}
+
+	// 汇总服务器上的目标是各个节点推送的，地址在节点的快照里
+	for _, n := range fleet.Nodes() {
+		for _, snap := range n.Snapshots {
+			if t := targets[fleetTargetName(n.Node, snap.Name)]; t != nil && len(t.Addr) == 0 {
+				t.Addr = snap.Addr
+			}
+		}
+	}
+
+	for _, t := range targets {
+		if t.Current == nil {
+			// 存储里没有这个目标的数据
+			t.Current, _ = rollupDigest(nil, to-period, to)
+			t.Previous, _ = rollupDigest(nil, to-2*period, to-period)
+		}
+		ret.Targets = append(ret.Targets, t)
+	}
+	sort.Slice(ret.Targets, func(i, j int) bool {
+		return ret.Targets[i].Name < ret.Targets[j].Name
+	})
+	return ret
+}
+
+const defaultDigestSubjectTemplate = `network-profiler:{{.LocalIp}} {{.Name}} {{.From.Format "01-02 15:04"}} ~ {{.To.Format "01-02 15:04"}}`
+
+const defaultDigestTextTemplate = `{{.Name}}：{{.From.Format "2006-01-02 15:04"}} ~ {{.To.Format "2006-01-02 15:04"}}  本机：{{.LocalIp}}
+括号里是和上一个周期相比的变化
+{{range .Targets}}
+== {{.Name}} ({{.Proto}} {{.Addr}})
+{{with .Current}}可用率={{if ge .Availability 0.0}}{{printf "%.3f" .Availability}}%{{else}}无数据{{end}}{{end}} ({{change .Current.Availability .Previous.Availability}})
+断网={{.Current.OutageMinutes}}分钟 ({{change .Current.Outage .Previous.Outage}})
+丢包率={{printf "%.2f" .Current.Counts.LossPercent}}%({{.Current.Counts.Lost}}/{{.Current.Counts.Settled}}) ({{change .Current.Loss .Previous.Loss}})
+延迟 p50={{.Current.Latency.P50}} ({{change (.Current.Pct 50) (.Previous.Pct 50)}}) p90={{.Current.Latency.P90}} ({{change (.Current.Pct 90) (.Previous.Pct 90)}}) p99={{.Current.Latency.P99}} ({{change (.Current.Pct 99) (.Previous.Pct 99)}}) p99.9={{.Current.Latency.P999}} ({{change (.Current.Pct 99.9) (.Previous.Pct 99.9)}}) max={{.Current.Latency.Max}}
+最差的时段：
+{{range .WorstHours}}  {{.Time.Format "01-02 15:00"}} 断网={{.OutageMinutes}}分钟 丢包率={{printf "%.2f" .Counts.LossPercent}}% p99={{.Latency.P99}}
+{{else}}  无数据
+{{end}}{{end}}`
+
+const defaultDigestHtmlTemplate = `<p><b>{{.Name}}</b>：{{.From.Format "2006-01-02 15:04"}} ~ {{.To.Format "2006-01-02 15:04"}}　本机：{{.LocalIp}}</p>
+<p>括号里是和上一个周期相比的变化</p>
+{{range .Targets}}
+<h3>{{.Name}} ({{.Proto}} {{.Addr}})</h3>
+<table border="1" cellspacing="0" cellpadding="4" style="border-collapse:collapse">
+<tr><th>可用率</th><th>断网(分钟)</th><th>丢包率</th><th>p50</th><th>p90</th><th>p99</th><th>p99.9</th><th>max</th></tr>
+<tr><td>{{with .Current}}{{if ge .Availability 0.0}}{{printf "%.3f" .Availability}}%{{else}}无数据{{end}}{{end}} ({{change .Current.Availability .Previous.Availability}})</td>
+<td>{{.Current.OutageMinutes}} ({{change .Current.Outage .Previous.Outage}})</td>
+<td>{{printf "%.2f" .Current.Counts.LossPercent}}% ({{change .Current.Loss .Previous.Loss}})</td>
+<td>{{.Current.Latency.P50}} ({{change (.Current.Pct 50) (.Previous.Pct 50)}})</td>
+<td>{{.Current.Latency.P90}} ({{change (.Current.Pct 90) (.Previous.Pct 90)}})</td>
+<td>{{.Current.Latency.P99}} ({{change (.Current.Pct 99) (.Previous.Pct 99)}})</td>
+<td>{{.Current.Latency.P999}} ({{change (.Current.Pct 99.9) (.Previous.Pct 99.9)}})</td>
+<td>{{.Current.Latency.Max}}</td></tr>
+</table>
+<p>最差的时段：</p>
+<ul>{{range .WorstHours}}<li>{{.Time.Format "01-02 15:00"}} 断网={{.OutageMinutes}}分钟 丢包率={{printf "%.2f" .Counts.LossPercent}}% p99={{.Latency.P99}}</li>{{else}}<li>无数据</li>{{end}}</ul>
+{{end}}`
+
+var digestSchedules []*digestSchedule
+var digestTemplates *notifyTemplates
+
+func loadDigestTemplates(dir string) (*notifyTemplates, error) {
+	return loadTemplateSet(dir, "digest.", defaultDigestSubjectTemplate, defaultDigestTextTemplate, defaultDigestHtmlTemplate)
+}
+
+// 到时间的计划发送报告，由timerReportData定时调用
+// 在锁里只挑出到时间的计划，更新next；读取存储和生成报告在锁外面，不会挡住重新加载配置
+func checkDigest(localIp string, now time.Time) {
+	type dueDigest struct {
+		sched *digestSchedule
+		end   time.Time
+	}
+	var due []dueDigest
+	configMutex.Lock()
+	for _, sched := range digestSchedules {
+		if sched.next.IsZero() {
+			sched.next = sched.Next(now)
+			netLog.Infoln("下一次"+sched.Name()+":", sched.next.Format("2006-01-02 15:04"))
+			continue
+		}
+		var ready = sched.next
+		if resultStore != nil {
+			ready = ready.Add(digestStoreDelay)
+		}
+		if now.Before(ready) {
+			continue
+		}
+
+		due = append(due, dueDigest{sched, sched.next})
+		sched.next = sched.Next(now)
+	}
+	configMutex.Unlock()
+
+	for _, v := range due {
+		sendDigest(newDigestData(localIp, v.sched, v.end))
+	}
+}
+
+func sendDigest(data *digestData) {
+	defer CheckPanic(netLog)
+
+	var n *Notification
+	if tmpl := currentDigestTemplates(); tmpl != nil {
+		var subject, text, html, err = tmpl.execute(data)
+		if err != nil {
+			netLog.Warnln("模板错误:", err.Error())
+		} else {
+			n = &Notification{Subject: subject, Text: text, Html: html}
+		}
+	}
+	if n == nil {
+		var lines []string
+		for _, t := range data.Targets {
+			lines = append(lines, fmt.Sprintf("%s 可用率=%.3f%%, 断网=%d分钟, 丢包率=%.2f%%, 延迟: %s",
+				t.Name, t.Current.Availability, t.Current.OutageMinutes, t.Current.Counts.LossPercent(), t.Current.Latency))
+		}
+		n = &Notification{
+			Subject: fmt.Sprintf("network-profiler:%s %s", data.LocalIp, data.Name),
+			Text:    strings.Join(lines, "\n"),
+			Html:    strings.Join(lines, "<br>\n"),
+		}
+	}
+	n.Severity = "info"
+	n.Time = data.To.UnixNano() / int64(time.Millisecond)
+
+	netLog.Infoln(data.Name+":", n.Subject)
+	for _, line := range strings.Split(strings.TrimSpace(n.Text), "\n") {
+		netLog.Infoln(data.Name+":", line)
+	}
+	// 推送到汇总服务器时，由汇总服务器统一发送所有节点的报告
+	var cfg = currentConfig()
+	if cfg.NotEmail == 0 && len(cfg.CollectorUrl) == 0 {
+		enqueueNotification(n)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 时区偏移不是整小时
+func withLocal(loc *time.Location) func() {
+	var old = time.Local
+	time.Local = loc
+	return func() { time.Local = old }
+}
+
+func TestDigestLocalHours(t *testing.T) {
+	defer withLocal(time.FixedZone("IST", 5*3600+1800))()
+
+	// 本地时间10:20和10:50，按UTC对齐会分到04:00和05:00两个小时
+	var day = time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local)
+	var s = newTargetStats("a")
+	for _, m := range []time.Duration{10*time.Hour + 20*time.Minute, 10*time.Hour + 50*time.Minute, 11*time.Hour + 30*time.Minute} {
+		var ms = storeMs(day.Add(m))
+		s.hourRtt.Record(localMs(ms), 10)
+		s.addCounts(ms, probeCounts{Sent: 1, Received: 1})
+	}
+
+	var period, hours = s.Digest(storeMs(day), storeMs(day.Add(24*time.Hour)))
+	if len(hours) != 2 {
+		t.Fatalf("hours = %+v, want 2", hours)
+	}
+	if !hours[0].Time.Equal(day.Add(10*time.Hour)) || hours[0].Counts.Received != 2 || hours[0].Latency.Count != 2 {
+		t.Fatalf("first hour = %+v", hours[0])
+	}
+	if !hours[1].Time.Equal(day.Add(11*time.Hour)) || hours[1].Counts.Received != 1 {
+		t.Fatalf("second hour = %+v", hours[1])
+	}
+	// 最后一分钟还没结束
+	if period.Counts.Received != 3 || period.ObservedMinutes != 2 {
+		t.Fatalf("period = %+v", period)
+	}
+}
+
+func TestRollupDigest(t *testing.T) {
+	defer withLocal(time.FixedZone("IST", 5*3600+1800))()
+
+	var day = time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local)
+	var rollup = func(m time.Duration, received, lost int64, rtt int64) *rollupRecord {
+		var rec = &rollupRecord{Time: storeMs(day.Add(m)), Span: 60000, Target: "a", Proto: "udp",
+			Counts: probeCounts{Received: received, Lost: lost}, RttSum: rtt * received}
+		if received > 0 {
+			rec.Latency = latencySummary{Count: received, Min: rtt, Max: rtt, Avg: float64(rtt), P50: rtt, P90: rtt, P99: rtt, P999: rtt}
+		}
+		return rec
+	}
+	var recs = []*rollupRecord{
+		// 前一天，不在这个周期里
+		rollup(-time.Minute, 10, 0, 5),
+		rollup(10*time.Hour+20*time.Minute, 10, 0, 10),
+		rollup(10*time.Hour+50*time.Minute, 0, 10, 0),
+		rollup(11*time.Hour, 10, 0, 30),
+	}
+
+	var period, hours = rollupDigest(recs, storeMs(day), storeMs(day.Add(24*time.Hour)))
+	if period.ObservedMinutes != 3 || period.OutageMinutes != 1 || period.Counts.Received != 20 || period.Counts.Lost != 10 {
+		t.Fatalf("period = %+v", period)
+	}
+	if period.Latency.Count != 20 || period.Latency.Min != 10 || period.Latency.Max != 30 || period.Latency.Avg != 20 {
+		t.Fatalf("latency = %+v", period.Latency)
+	}
+	if len(hours) != 2 || !hours[0].Time.Equal(day.Add(10*time.Hour)) || hours[0].OutageMinutes != 1 || hours[0].Counts.Lost != 10 ||
+		!hours[1].Time.Equal(day.Add(11*time.Hour)) || hours[1].OutageMinutes != 0 {
+		t.Fatalf("hours = %+v", hours)
+	}
+
+	var empty, none = rollupDigest(nil, 0, 1)
+	if empty.Availability != -1 || empty.Outage() != -1 || empty.Loss() != -1 || len(none) != 0 {
+		t.Fatalf("empty = %+v", empty)
+	}
+}
+
+// 重启以后内存里没有数据，从存储里读取
+func TestDigestFromStore(t *testing.T) {
+	var dir = tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	defer func() { resultStore = nil }()
+
+	var end = time.Now().Truncate(time.Minute)
+	var w = &storeWriter{tier: findStoreTier(defaultStoreTiers, storeTierMinute), dir: dir}
+	for _, m := range []time.Duration{-30 * time.Hour, -2 * time.Hour, -time.Hour} {
+		var at = end.Add(m)
+		w.Write(at, (&rollupRecord{Time: storeMs(at), Span: 60000, Target: "digest-stored", Proto: "tcp",
+			Counts: probeCounts{Received: 9, Lost: 1}}).encode())
+	}
+	w.Close()
+
+	resultStore = newProbeStore(dir, defaultStoreTiers)
+	var data = newDigestData("127.0.0.1", &digestSchedule{Kind: "daily"}, end)
+	var target *digestTarget
+	for _, v := range data.Targets {
+		if v.Name == "digest-stored" {
+			target = v
+		}
+	}
+	if target == nil {
+		t.Fatalf("targets = %+v, want digest-stored", data.Targets)
+	}
+	if target.Proto != "tcp" || target.Current.ObservedMinutes != 2 || target.Current.Counts.Received != 18 ||
+		target.Previous.ObservedMinutes != 1 || len(target.WorstHours) != 2 {
+		t.Fatalf("target = %+v current %+v previous %+v", target, target.Current, target.Previous)
+	}
+}
+
+// 丢包率和括号里的数字用同一个分母
+func TestDigestTextLossDenominator(t *testing.T) {
+	var tmpl, err = loadDigestTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cur, _ = rollupDigest([]*rollupRecord{{Time: 0, Counts: probeCounts{Received: 3, Lost: 1}}}, 0, 60000)
+	var prev, _ = rollupDigest(nil, -60000, 0)
+	var data = &digestData{Name: "日报", Targets: []*digestTarget{{Name: "a", Current: cur, Previous: prev}}}
+	_, text, _, err := tmpl.execute(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "丢包率=25.00%(1/4)") {
+		t.Fatalf("text = %s", text)
+	}
+}
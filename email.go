@@ -7,61 +7,81 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"gopkg.in/gomail.v2"
+	"strings"
 )
 
-type IEmail interface {
-	SendEmailTo(to, subject, body string) error
-}
-func NewEmail(host string, port int, account string, password string) IEmail {
-	var e = &oneEmail{}
-	e.Host = host
-	e.Port = port
-	e.Account = account
-	e.Password = password
+// SMTP邮件通知，支持多个收件人，Security为ssl时直接使用SSL连接，为starttls时使用STARTTLS
+type smtpNotifier struct {
+	name string
 
-	return e
-}
-func NewEmailExample() IEmail {
-	return NewEmail("smtp.qq.com", 465, "563568850@qq.com", "password-xxxxxx")
-}
-
-type oneEmail struct {
 	Host     string
 	Port     int
 	Account  string
 	Password string
+	From     string
+	To       []string
+	Security string
+}
+
+func newSmtpNotifier(cfg *NotifierConfig) (INotifier, error) {
+	var e = &smtpNotifier{
+		name:     cfg.Name,
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Account:  cfg.Account,
+		Password: cfg.Password,
+		From:     cfg.From,
+		To:       splitList(cfg.To),
+		Security: strings.ToLower(cfg.Security),
+	}
+
+	if len(e.Host) == 0 || len(e.To) == 0 {
+		return nil, errors.New("smtp需要Host和To")
+	}
+	if e.Port == 0 {
+		e.Port = 465
+	}
+	if len(e.From) == 0 {
+		e.From = e.Account
+	}
+	if len(e.Security) == 0 {
+		e.Security = "starttls"
+		if e.Port == 465 {
+			e.Security = "ssl"
+		}
+	}
+	if e.Security != "ssl" && e.Security != "starttls" {
+		return nil, errors.New("smtp的Security只能是ssl或者starttls: " + cfg.Security)
+	}
+	return e, nil
 }
-func (self *oneEmail) SendEmail(subject, body string) error {
-	var to = "505700330@qq.com"
-	return self.SendEmailTo(to, subject, body)
+
+func (self *smtpNotifier) Name() string {
+	return self.name
 }
-func (self *oneEmail) SendEmailTo(to, subject, body string) error {
 
-	var from = self.Account
+func (self *smtpNotifier) Notify(n *Notification) error {
 
 	m := gomail.NewMessage()
-	m.SetAddressHeader("From", from, from)  // 发件人
-	m.SetHeader("To",  // 收件人
-		m.FormatAddress(to, to),
-	)
-	m.SetHeader("Subject", subject)  // 主题
-	m.SetBody("text/html", body)  // 正文
-	//m.Attach("/home/Alex/lolcat.jpg")
+	m.SetAddressHeader("From", self.From, self.From) // 发件人
 
-	//d := gomail.NewDialer("smtp.qq.com", 465, from, "xaodkjrskzzmbfec")
-	d := gomail.NewDialer(self.Host, self.Port, self.Account, self.Password)
-	var err = d.DialAndSend(m)
+	var to []string
+	for _, v := range self.To {
+		to = append(to, m.FormatAddress(v, v))
+	}
+	m.SetHeader("To", to...)          // 收件人
+	m.SetHeader("Subject", n.Subject) // 主题
+	m.SetBody("text/plain", n.Text)   // 正文
+	if len(n.Html) > 0 {
+		m.AddAlternative("text/html", n.Html)
+	}
 
-	return err
-}
+	d := gomail.NewDialer(self.Host, self.Port, self.Account, self.Password)
+	d.SSL = self.Security == "ssl"
+	d.TLSConfig = &tls.Config{ServerName: self.Host}
 
-var emailWorker = NewEmail("smtp.qq.com", 465, "563568850@qq.com", "----")
-func sendEmail(subject, body string) bool {
-	var to = "505700330@qq.com"
-	var e = emailWorker.SendEmailTo(to, subject, body)
-	if e != nil {
-		netLog.Warnln("发送邮件错误:", e.Error())
-	}
-	return e == nil
+	return d.DialAndSend(m)
 }
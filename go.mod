@@ -3,6 +3,7 @@ module network_profiler
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v0.4.1
 	github.com/badforlabor/gocrazy v0.0.0-20200321110225-50bc2c4f4605
 	github.com/davyxu/cellnet v4.1.0+incompatible
 	github.com/davyxu/golog v0.1.0
@@ -12,4 +13,5 @@ require (
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
 	gopkg.in/ini.v1 v1.57.0
+	gopkg.in/yaml.v2 v2.4.0
 )
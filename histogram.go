@@ -0,0 +1,287 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:24
+ * Comment: 延迟直方图，参照HDR Histogram的分桶方式，每个数量级（2的幂）分成固定数量的子桶
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	// 每个数量级32个子桶，相对误差约3%
+	histSubBits  = 5
+	histSubCount = 1 << histSubBits
+
+	// 能记录的最大值，约4.6小时（毫秒），超过的算在最后一个桶里
+	histMaxBits  = 24
+	histMaxValue = int64(1)<<histMaxBits - 1
+
+	histBucketCount = (histMaxBits-histSubBits)*histSubCount + histSubCount
+)
+
+func histBucketIndex(v int64) int {
+	if v < 0 {
+		v = 0
+	}
+	if v > histMaxValue {
+		v = histMaxValue
+	}
+	if v < 2*histSubCount {
+		return int(v)
+	}
+	var shift = bits.Len64(uint64(v)) - histSubBits - 1
+	var top = int(v >> uint(shift))
+	return (shift+1)*histSubCount + top - histSubCount
+}
+
+// 这个桶里能放的最大值
+func histBucketHighest(idx int) int64 {
+	if idx < 2*histSubCount {
+		return int64(idx)
+	}
+	var shift = idx/histSubCount - 1
+	var top = int64(idx%histSubCount + histSubCount)
+	return (top+1)<<uint(shift) - 1
+}
+
+type histogram struct {
+	// 用到的时候才分配
+	counts []uint32
+
+	count int64
+	sum   int64
+	sumSq float64
+	min   int64
+	max   int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{}
+}
+
+func (self *histogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	if self.counts == nil {
+		self.counts = make([]uint32, histBucketCount)
+	}
+	self.counts[histBucketIndex(v)]++
+
+	if self.count == 0 || v < self.min {
+		self.min = v
+	}
+	if self.count == 0 || v > self.max {
+		self.max = v
+	}
+	self.count++
+	self.sum += v
+	self.sumSq += float64(v) * float64(v)
+}
+
+func (self *histogram) Merge(other *histogram) {
+	if other == nil || other.count == 0 {
+		return
+	}
+	if self.counts == nil {
+		self.counts = make([]uint32, histBucketCount)
+	}
+	for i, c := range other.counts {
+		self.counts[i] += c
+	}
+
+	if self.count == 0 || other.min < self.min {
+		self.min = other.min
+	}
+	if self.count == 0 || other.max > self.max {
+		self.max = other.max
+	}
+	self.count += other.count
+	self.sum += other.sum
+	self.sumSq += other.sumSq
+}
+
+func (self *histogram) Reset() {
+	for i := range self.counts {
+		self.counts[i] = 0
+	}
+	self.count = 0
+	self.sum = 0
+	self.sumSq = 0
+	self.min = 0
+	self.max = 0
+}
+
+// 百分位数，q取值0~100
+func (self *histogram) Percentile(q float64) int64 {
+	if self.count == 0 {
+		return 0
+	}
+	var target = int64(math.Ceil(q / 100 * float64(self.count)))
+	if target < 1 {
+		target = 1
+	}
+
+	var total int64
+	for i, c := range self.counts {
+		total += int64(c)
+		if total >= target {
+			var v = histBucketHighest(i)
+			if v > self.max {
+				v = self.max
+			}
+			if v < self.min {
+				v = self.min
+			}
+			return v
+		}
+	}
+	return self.max
+}
+
+func (self *histogram) Summary() latencySummary {
+	var ret = latencySummary{Count: self.count}
+	if self.count == 0 {
+		return ret
+	}
+
+	ret.Min = self.min
+	ret.Max = self.max
+	ret.Avg = float64(self.sum) / float64(self.count)
+	var variance = self.sumSq/float64(self.count) - ret.Avg*ret.Avg
+	if variance > 0 {
+		ret.StdDev = math.Sqrt(variance)
+	}
+	ret.P50 = self.Percentile(50)
+	ret.P90 = self.Percentile(90)
+	ret.P99 = self.Percentile(99)
+	ret.P999 = self.Percentile(99.9)
+	return ret
+}
+
+// 延迟分布的汇总，单位都是毫秒
+type latencySummary struct {
+	Count  int64
+	Min    int64
+	Max    int64
+	Avg    float64
+	StdDev float64
+	P50    int64
+	P90    int64
+	P99    int64
+	P999   int64
+}
+
+func (self latencySummary) String() string {
+	if self.Count == 0 {
+		return "无数据"
+	}
+	return fmt.Sprintf("n=%d, min=%d, avg=%.1f, p50=%d, p90=%d, p99=%d, p99.9=%d, max=%d, std=%.1f",
+		self.Count, self.Min, self.Avg, self.P50, self.P90, self.P99, self.P999, self.Max, self.StdDev)
+}
+
+// 滚动窗口的直方图，把时间切成固定长度的槽，每个槽一个直方图
+type rollingHistogram struct {
+	slotMs    int64
+	slots     []*histogram
+	slotStart []int64
+
+	// 每个窗口里已经结束的槽合并的结果，已经结束的槽不会再变，当前的槽变了以后才重新合并
+	cache map[time.Duration]*windowCache
+	// 最近记录的槽，记录到更早的槽里时（时钟回拨）缓存失效
+	last int64
+}
+
+type windowCache struct {
+	first   int64
+	current int64
+	done    *histogram
+}
+
+func newRollingHistogram(slot time.Duration, keep time.Duration) *rollingHistogram {
+	var n = int(keep / slot)
+	if n < 1 {
+		n = 1
+	}
+	var ret = &rollingHistogram{
+		slotMs:    int64(slot / time.Millisecond),
+		slots:     make([]*histogram, n),
+		slotStart: make([]int64, n),
+	}
+	for i := range ret.slots {
+		ret.slots[i] = newHistogram()
+	}
+	return ret
+}
+
+func (self *rollingHistogram) slotOf(now int64) *histogram {
+	var start = now - now%self.slotMs
+	var idx = int(start/self.slotMs) % len(self.slots)
+	if self.slotStart[idx] != start {
+		self.slotStart[idx] = start
+		self.slots[idx].Reset()
+	}
+	return self.slots[idx]
+}
+
+func (self *rollingHistogram) Record(now int64, v int64) {
+	var start = now - now%self.slotMs
+	if start < self.last {
+		self.cache = nil
+	}
+	self.last = start
+	self.slotOf(now).Record(v)
+}
+
+// 合并最近一段时间的数据（包括当前还没结束的槽）
+// 包括哪些槽只和from所在的槽、当前的槽有关，这两个没变时只需要重新合并当前的槽
+func (self *rollingHistogram) Window(now int64, window time.Duration) *histogram {
+	var from = now - int64(window/time.Millisecond)
+	var first = from - from%self.slotMs
+	var current = now - now%self.slotMs
+
+	var c = self.cache[window]
+	if c == nil || c.first != first || c.current != current {
+		c = &windowCache{first: first, current: current, done: newHistogram()}
+		for i, h := range self.slots {
+			var start = self.slotStart[i]
+			if start+self.slotMs > from && start < current {
+				c.done.Merge(h)
+			}
+		}
+		if self.cache == nil {
+			self.cache = make(map[time.Duration]*windowCache)
+		}
+		self.cache[window] = c
+	}
+
+	var ret = newHistogram()
+	ret.Merge(c.done)
+	ret.Merge(self.SlotAt(current))
+	return ret
+}
+
+// 从start开始的那个槽，已经被覆盖或者没有数据时返回nil
+func (self *rollingHistogram) SlotAt(start int64) *histogram {
+	var idx = int(start/self.slotMs) % len(self.slots)
+	if self.slotStart[idx] != start {
+		return nil
+	}
+	return self.slots[idx]
+}
+
+// 槽的长度（毫秒）
+func (self *rollingHistogram) SlotMs() int64 {
+	return self.slotMs
+}
+
+// 能支持的最长窗口
+func (self *rollingHistogram) Span() time.Duration {
+	return time.Duration(self.slotMs*int64(len(self.slots))) * time.Millisecond
+}
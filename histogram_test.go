@@ -0,0 +1,198 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHistBucketBoundaries(t *testing.T) {
+	var cases = []struct {
+		v       int64
+		idx     int
+		lowest  int64
+		highest int64
+	}{
+		{0, 0, 0, 0},
+		{1, 1, 1, 1},
+		{63, 63, 63, 63},
+		{64, 64, 64, 65},
+		{65, 64, 64, 65},
+		{66, 65, 66, 67},
+		{100, 82, 100, 101},
+		{101, 82, 100, 101},
+		{127, 95, 126, 127},
+		{128, 96, 128, 131},
+		{500, 158, 496, 503},
+		{1000, 190, 992, 1007},
+		{1007, 190, 992, 1007},
+		{1008, 191, 1008, 1023},
+		{histMaxValue, histBucketCount - 1, histMaxValue - 1<<(histMaxBits-histSubBits-1) + 1, histMaxValue},
+		// 超出范围的算在第一个和最后一个桶里
+		{-5, 0, 0, 0},
+		{histMaxValue + 100, histBucketCount - 1, histMaxValue - 1<<(histMaxBits-histSubBits-1) + 1, histMaxValue},
+	}
+	for _, c := range cases {
+		var idx = histBucketIndex(c.v)
+		if idx != c.idx {
+			t.Errorf("histBucketIndex(%d) = %d, want %d", c.v, idx, c.idx)
+			continue
+		}
+		if h := histBucketHighest(idx); h != c.highest {
+			t.Errorf("histBucketHighest(%d) = %d, want %d", idx, h, c.highest)
+		}
+		var lowest int64
+		if idx > 0 {
+			lowest = histBucketHighest(idx-1) + 1
+		}
+		if lowest != c.lowest {
+			t.Errorf("bucket %d starts at %d, want %d", idx, lowest, c.lowest)
+		}
+	}
+}
+
+// 每个值都落在自己的桶里，桶是连续的，相对误差不超过1/32
+func TestHistBucketContiguous(t *testing.T) {
+	var last = -1
+	for v := int64(0); v <= 1<<20; v++ {
+		var idx = histBucketIndex(v)
+		if idx != last && idx != last+1 {
+			t.Fatalf("value %d jumps from bucket %d to %d", v, last, idx)
+		}
+		last = idx
+
+		var h = histBucketHighest(idx)
+		if h < v {
+			t.Fatalf("value %d above its bucket %d highest %d", v, idx, h)
+		}
+		if idx > 0 && histBucketHighest(idx-1) >= v {
+			t.Fatalf("value %d also fits in bucket %d", v, idx-1)
+		}
+		if float64(h-v) > float64(v)/histSubCount {
+			t.Fatalf("value %d: bucket highest %d exceeds relative error", v, h)
+		}
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	var rnd = rand.New(rand.NewSource(1))
+	var sets = map[string][]int64{
+		"single": {42},
+		"small":  {1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		"same":   {500, 500, 500, 500},
+	}
+	var uniform, exp []int64
+	for i := 0; i < 5000; i++ {
+		uniform = append(uniform, rnd.Int63n(2000))
+		exp = append(exp, int64(rnd.ExpFloat64()*80))
+	}
+	sets["uniform"] = uniform
+	sets["exponential"] = exp
+
+	for name, values := range sets {
+		var h = newHistogram()
+		for _, v := range values {
+			h.Record(v)
+		}
+		var sorted = append([]int64(nil), values...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		for _, q := range []float64{0, 1, 50, 90, 99, 99.9, 100} {
+			var rank = int(math.Ceil(q / 100 * float64(len(sorted))))
+			if rank < 1 {
+				rank = 1
+			}
+			var want = sorted[rank-1]
+			var got = h.Percentile(q)
+
+			// 直方图返回oracle所在桶的上界，不超过最大值
+			var bound = histBucketHighest(histBucketIndex(want))
+			if bound > sorted[len(sorted)-1] {
+				bound = sorted[len(sorted)-1]
+			}
+			if got != bound {
+				t.Errorf("%s p%v = %d, want %d (oracle %d)", name, q, got, bound, want)
+			}
+		}
+
+		var s = h.Summary()
+		if s.Count != int64(len(values)) || s.Min != sorted[0] || s.Max != sorted[len(sorted)-1] {
+			t.Errorf("%s summary = %+v", name, s)
+		}
+	}
+
+	if p := newHistogram().Percentile(50); p != 0 {
+		t.Errorf("empty p50 = %d", p)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var a, b, all = newHistogram(), newHistogram(), newHistogram()
+	for v := int64(0); v < 300; v++ {
+		if v%3 == 0 {
+			a.Record(v)
+		} else {
+			b.Record(v)
+		}
+		all.Record(v)
+	}
+	a.Merge(b)
+	if a.Summary() != all.Summary() {
+		t.Fatalf("merged %v, want %v", a.Summary(), all.Summary())
+	}
+}
+
+func TestRollingHistogramWindow(t *testing.T) {
+	var r = newRollingHistogram(10*time.Second, time.Minute)
+	var base = int64(1000000)
+	for i := int64(0); i < 12; i++ {
+		r.Record(base+i*10000, i)
+	}
+	var now = base + 11*10000
+
+	// 最近10秒和当前的槽、上一个槽都有重叠
+	if n := r.Window(now, 10*time.Second).count; n != 2 {
+		t.Errorf("10s window count = %d, want 2", n)
+	}
+	// 只保留了6个槽
+	if n := r.Window(now, time.Minute).count; n != 6 {
+		t.Errorf("1m window count = %d, want 6", n)
+	}
+	// 被覆盖的槽
+	if h := r.SlotAt(base); h != nil {
+		t.Errorf("overwritten slot still returned")
+	}
+}
+
+// 缓存的结果和每次全部合并的结果一样
+func TestRollingHistogramWindowCache(t *testing.T) {
+	var r = newRollingHistogram(10*time.Second, time.Minute)
+	var brute = func(now int64, window time.Duration) latencySummary {
+		var ret = newHistogram()
+		var from = now - int64(window/time.Millisecond)
+		for i, h := range r.slots {
+			if start := r.slotStart[i]; start+r.slotMs > from && start <= now {
+				ret.Merge(h)
+			}
+		}
+		return ret.Summary()
+	}
+
+	var rnd = rand.New(rand.NewSource(1))
+	var now = int64(1000000)
+	for i := 0; i < 2000; i++ {
+		now += rnd.Int63n(700)
+		if i == 1000 {
+			// 时钟回拨
+			now -= 30000
+		}
+		r.Record(now, rnd.Int63n(500))
+		for _, w := range []time.Duration{10 * time.Second, 30 * time.Second, time.Minute} {
+			if got, want := r.Window(now, w).Summary(), brute(now, w); got != want {
+				t.Fatalf("step %d window %s: %v, want %v", i, w, got, want)
+			}
+		}
+	}
+}
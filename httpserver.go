@@ -0,0 +1,30 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:30
+ * Comment: 内置的HTTP服务，各个功能在init中把自己的处理函数注册到httpMux上
+ */
+
+package main
+
+import (
+	"net/http"
+)
+
+var httpMux = http.NewServeMux()
+
+func startHttpServer(addr string) {
+	if len(addr) == 0 {
+		return
+	}
+
+	netLog.Infoln("open http server:", addr)
+
+	go func() {
+		defer CheckPanic(netLog)
+
+		var err = http.ListenAndServe(addr, httpMux)
+		if err != nil {
+			netLog.Errorln("http服务错误:", err.Error())
+		}
+	}()
+}
@@ -0,0 +1,34 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:25
+ * Comment: 到达间隔抖动，算法参照RFC 3550 6.4.1
+ */
+
+package main
+
+// J(i) = J(i-1) + (|D(i-1,i)| - J(i-1))/16
+// D(i-1,i) = (Rj - Ri) - (Sj - Si)，S是发送时间（PtAck.Time），R是收到的时间
+type jitterEstimator struct {
+	lastTransit int64
+	hasLast     bool
+	jitter      float64
+}
+
+// 收到一个包后更新抖动，返回当前的抖动（毫秒）
+func (self *jitterEstimator) Update(sendTime, recvTime int64) float64 {
+	var transit = recvTime - sendTime
+	if self.hasLast {
+		var d = transit - self.lastTransit
+		if d < 0 {
+			d = -d
+		}
+		self.jitter += (float64(d) - self.jitter) / 16
+	}
+	self.lastTransit = transit
+	self.hasLast = true
+	return self.jitter
+}
+
+func (self *jitterEstimator) Jitter() float64 {
+	return self.jitter
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestJitterEstimator(t *testing.T) {
+	var cases = []struct {
+		name string
+		// 发送时间和收到的时间
+		send []int64
+		recv []int64
+		want []float64
+	}{
+		{"first packet", []int64{0}, []int64{10}, []float64{0}},
+		{"constant transit", []int64{0, 20, 40}, []int64{10, 30, 50}, []float64{0, 0, 0}},
+		{"rfc 3550 steps",
+			[]int64{0, 20, 40, 60},
+			[]int64{10, 30, 55, 70},
+			// D = 0, 5, 5; J += (|D| - J) / 16
+			[]float64{0, 0, 0.3125, 0.60546875}},
+		{"negative difference counts as absolute",
+			[]int64{0, 20, 40},
+			[]int64{30, 30, 50},
+			// transit 30, 10, 10
+			[]float64{0, 1.25, 1.171875}},
+	}
+	for _, c := range cases {
+		var j jitterEstimator
+		for i := range c.send {
+			var got = j.Update(c.send[i], c.recv[i])
+			if math.Abs(got-c.want[i]) > 1e-9 {
+				t.Errorf("%s: packet %d jitter = %v, want %v", c.name, i, got, c.want[i])
+			}
+		}
+		if j.Jitter() != c.want[len(c.want)-1] {
+			t.Errorf("%s: Jitter() = %v", c.name, j.Jitter())
+		}
+	}
+}
+
+// 到达间隔一直差d时，抖动收敛到d
+func TestJitterConverges(t *testing.T) {
+	var j jitterEstimator
+	for i := int64(0); i < 500; i++ {
+		var extra int64
+		if i%2 == 1 {
+			extra = 8
+		}
+		j.Update(i*20, i*20+10+extra)
+	}
+	if math.Abs(j.Jitter()-8) > 0.01 {
+		t.Fatalf("jitter = %v, want about 8", j.Jitter())
+	}
+}
@@ -9,7 +9,6 @@ package main
 import (
 	"fmt"
 	"github.com/badforlabor/gocrazy/alg"
-	"io"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -19,7 +18,7 @@ import (
 )
 
 var MaxLogSize int64 = 64 * 1000 * 1000	//64M
-var MaxKeepLogCount = 30
+var MaxKeepLogCount int32 = 30
 var LogExt = ".log"
 
 type logData struct {
@@ -29,26 +28,48 @@ type logData struct {
 type rollingLogWriter struct {
 	folder     string
 	fileName   string
+	ext        string
+	// 每个新文件开头写的内容，例如csv的表头
+	header     []byte
 
 	channel chan []byte
 	quit chan bool
+	// 写完关闭文件后通知Quit
+	done chan bool
+	// 同时输出到控制台
+	console bool
+	// 已经关闭了，之后写的内容只输出到控制台
+	closed bool
+	sendMutex sync.Mutex
 
 	fileHandle *os.File // 当前写入的日志文件句柄
 	fileSize   int64
 	mutex      sync.Mutex
 }
+// 写完还没写的内容，关闭文件之后才返回
 func (self *rollingLogWriter) Quit() {
+	self.sendMutex.Lock()
+	if self.closed {
+		self.sendMutex.Unlock()
+		return
+	}
+	self.closed = true
+	self.sendMutex.Unlock()
+
 	self.quit <- true
+	<-self.done
 }
-func (self *rollingLogWriter) init(folderName, fileName string) {
+func (self *rollingLogWriter) init(folderName, fileName, ext string) {
 
 	self.folder = folderName
 	self.fileName = fileName
+	self.ext = ext
 
 	self.checkFile()
 
 	self.channel = make(chan []byte, 100)
 	self.quit = make(chan bool)
+	self.done = make(chan bool)
 
 	go func() {
 		var quit = false
@@ -66,7 +87,23 @@ func (self *rollingLogWriter) init(folderName, fileName string) {
 					quit = true
 			}
 		}
+
+		// 把还没写的写完
+		for len(self.channel) > 0 {
+			var d = <-self.channel
+			if self.fileHandle != nil {
+				self.fileHandle.Write(d)
+			}
+		}
+		self.mutex.Lock()
+		if self.fileHandle != nil {
+			self.fileHandle.Sync()
+			self.fileHandle.Close()
+			self.fileHandle = nil
+		}
+		self.mutex.Unlock()
 		fmt.Println("退出写文件日志")
+		close(self.done)
 	}()
 }
 func (self *rollingLogWriter) checkFile() {
@@ -85,7 +122,7 @@ func (self *rollingLogWriter) checkFile() {
 
 	folder, err = os.Stat(self.folder)
 	if folder == nil {
-		fmt.Errorf("\n无法创建文件夹%s\n", folder)
+		fmt.Fprintf(os.Stderr, "\n无法创建文件夹%s\n", self.folder)
 		return
 	}
 
@@ -101,14 +138,14 @@ func (self *rollingLogWriter) checkFile() {
 				return filepath.SkipDir
 			}
 
-			if strings.HasSuffix( info.Name(), LogExt) {
+			if strings.HasSuffix( info.Name(), self.ext) {
 				allfile = append(allfile, info)
 			}
 
 			return nil
 		})
 
-		if len(allfile) >= MaxKeepLogCount {
+		if len(allfile) >= int(atomic.LoadInt32(&MaxKeepLogCount)) {
 			alg.Sort(&allfile, func(left interface{}, right interface{}) bool {
 				var a = left.(os.FileInfo)
 				var b = right.(os.FileInfo)
@@ -116,7 +153,7 @@ func (self *rollingLogWriter) checkFile() {
 			})
 		}
 
-		for len(allfile) >= MaxKeepLogCount {
+		for len(allfile) >= int(atomic.LoadInt32(&MaxKeepLogCount)) {
 			var f = allfile[0]
 			os.Remove(filepath.Join(self.folder, f.Name()))
 			allfile = allfile[1:]
@@ -124,7 +161,7 @@ func (self *rollingLogWriter) checkFile() {
 
 		var n int64 = 0
 		for _, v := range allfile {
-			var trim = v.Name()[0:len(v.Name()) - len(LogExt)]
+			var trim = v.Name()[0:len(v.Name()) - len(self.ext)]
 			var idx = strings.LastIndexByte(trim, '.')
 			if idx >= 0 {
 				var nn, _ = strconv.ParseInt(trim[idx+1:], 10, 0)
@@ -136,7 +173,7 @@ func (self *rollingLogWriter) checkFile() {
 
 		n = n + 1
 
-		var filename = filepath.Join(self.folder, self.fileName + "." + strconv.Itoa(int(n)) + ".log")
+		var filename = filepath.Join(self.folder, self.fileName + "." + strconv.Itoa(int(n)) + self.ext)
 		self.fileHandle, _ = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 		if self.fileHandle != nil {
 
@@ -144,6 +181,10 @@ func (self *rollingLogWriter) checkFile() {
 
 			var s, _ = self.fileHandle.Stat()
 			self.fileSize = s.Size()
+			if self.fileSize == 0 && len(self.header) > 0 {
+				self.fileHandle.Write(self.header)
+				self.fileSize = int64(len(self.header))
+			}
 		} else {
 			self.fileSize = 0
 		}
@@ -151,7 +192,7 @@ func (self *rollingLogWriter) checkFile() {
 
 
 	if self.fileHandle == nil {
-		fmt.Errorf("\n无法创建文件%s\n", filepath.Join(self.fileName))
+		fmt.Fprintf(os.Stderr, "\n无法创建文件%s\n", filepath.Join(self.fileName))
 	}
 }
 func (self *rollingLogWriter) checkRolling(n int) {
@@ -174,24 +215,40 @@ func (self *rollingLogWriter) checkRolling(n int) {
 
 func (self *rollingLogWriter)Write(p []byte) (n int, err error) {
 
-	self.channel <- p
+	// golog会重复使用p，需要复制一份
+	var d = make([]byte, len(p))
+	copy(d, p)
+
+	self.sendMutex.Lock()
+	if !self.closed {
+		self.channel <- d
+	}
+	self.sendMutex.Unlock()
+
+	if self.console {
+		os.Stdout.Write(d)
+	}
 
 	return len(p), nil
 }
 
-func CrazyLogWriter(folderName, fileName string, toConsole bool) io.Writer {
+// 退出前调用Quit，把还没写的日志写完
+func CrazyLogWriter(folderName, fileName string, toConsole bool) *rollingLogWriter {
 
 	if len(LogExt) == 0 {
 		panic("err log extension.")
 	}
 
-	var r rollingLogWriter
+	var r = &rollingLogWriter{console: toConsole}
 
-	r.init(folderName, fileName)
+	r.init(folderName, fileName, LogExt)
 
-	if !toConsole {
-		return &r
-	}
+	return r
+}
 
-	return io.MultiWriter(&r, os.Stdout)
+// 和日志一样切割和清理的文件，用于其他格式的输出，ext是扩展名，例如.csv
+func newRollingWriter(folderName, fileName, ext string, header []byte) *rollingLogWriter {
+	var r = &rollingLogWriter{header: header}
+	r.init(folderName, fileName, ext)
+	return r
 }
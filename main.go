@@ -7,13 +7,13 @@
 package main
 
 import (
-	"flag"
+	"fmt"
 	"github.com/davyxu/golog"
-	"gopkg.in/ini.v1"
 	"network_profiler/base"
 	"os"
 	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 )
 
@@ -22,23 +22,90 @@ type GlobalConfig struct {
 	// 最大等待时间（毫秒），超过这个时间点额，将记录在日志中
 	MaxWaitTime int64
 
+	// 探测包超时时间（毫秒），超过这个时间没有返回，判定为丢包，默认3000
+	ProbeTimeout int64
+
 	// 协议类型(TCP, UDP)
 	Proto string
 
 	// 客户端，服务器
 	ServerAddr string
 
-	// 是否是客户端（1是服务器，2是客户端）
+	// 是否是客户端（1是客户端，2是服务器，3是网状模式，4是汇总服务器）
 	Role ERole
 
+	// 网状模式下本节点的名字，默认是主机名
+	NodeName string
+
+	// 网状模式下的所有节点，格式：名字=地址，逗号分隔，可以包含自己
+	Peers string
+
 	// 最多多少个日志
 	LogMaxCount int
 
 	// 每个数据包额外带多少数据
 	StuffingCount int
 
-	// 是否邮件通知
+	// 发包间隔（毫秒），最小10，默认1000
+	ProbeInterval int64
+
+	// 每次连续发几个包，默认1
+	ProbeBurst int
+
+	// 发包间隔的分布（fixed固定间隔, poisson泊松分布）
+	ProbeMode string
+
+	// 轮换使用的附带数据大小（字节），逗号分隔，例如 0,512,1400，为空时使用StuffingCount
+	// udp最大1436，超过MTU的包对方收不到；tcp最大65500
+	ProbeSizes string
+
+	// 是否停止通知（所有的通知渠道）
 	NotEmail int
+
+	// 未发送的通知保存在哪个文件夹，默认spool
+	SpoolDir string
+
+	// 同一个目标的通知，等多少秒合并成一条再发送，默认10
+	NotifyBatchDelay int
+
+	// 每个通知渠道每分钟最多发几条，默认6
+	NotifyRateLimit int
+
+	// 通知模板所在的文件夹，里面可以有subject.tmpl, body.txt.tmpl, body.html.tmpl，没有的使用默认模板
+	TemplateDir string
+
+	// 日报和周报的发送时间，例如 daily 09:00, weekly mon 09:00，多个用逗号分隔，为空时不发送
+	DigestSchedule string
+
+	// 探测结果的存储文件夹，为空时不保存
+	StoreDir string
+
+	// 每个探测包的原始记录保留几天，默认7
+	StoreRawDays int
+
+	// 每分钟的汇总保留几天，默认30
+	StoreMinuteDays int
+
+	// 每小时的汇总保留几天，默认365
+	StoreHourDays int
+
+	// 每个探测包和连接事件输出一行结构化的结果：json, csv，为空时不输出
+	ResultFormat string
+
+	// 结果文件所在的文件夹，默认results
+	ResultDir string
+
+	// 汇总服务器的地址，例如 http://10.0.0.1:9100/api/push，为空时不推送
+	CollectorUrl string
+
+	// 推送到汇总服务器时使用的令牌，汇总服务器上配置同样的值
+	CollectorToken string
+
+	// 内置HTTP服务的地址（网页在/，指标在/metrics），例如 :9100，为空时不开启
+	HttpAddr string
+
+	// 所有的探测目标，来自[target.xxx]配置段
+	Targets []*TargetConfig `ini:"-"`
 }
 
 type ERole int32
@@ -46,40 +113,35 @@ const (
 	ERoleNone ERole = iota
 	ERoleClient
 	ERoleServer
+	ERoleMesh   // 网状模式，既是服务器又是客户端
+	ERoleCollector // 汇总服务器，接收各个节点推送的统计
 )
 
 var globalConfig GlobalConfig
 
 func main() {
-	flag.Parse()
+	// 子命令和命令行参数
+	var opt = parseCommandLine(os.Args[1:])
 
-	defer CheckPanic(netLog)
+	defer exitOnPanic()
 
 	// 最小化窗口
 	base.ShowConsoleAsync(base.SW_MINIMIZE)
 
 	// 延迟启动几秒
-	time.Sleep(3 * time.Second)
+	time.Sleep(opt.delay)
 
 	// 配置文件
-	var iniCfg, err = ini.Load("config.ini")
-	if err == nil {
-		err = iniCfg.Section("main").MapTo(&globalConfig)
-	}
+	var err = loadConfig(opt.config, opt.overrides)
 	if err != nil {
-		var cfg = ini.Empty()
-		cfg.Section("main").ReflectFrom(&globalConfig)
-		cfg.SaveTo("config.ini")
-		panic("读取配置文件错误!")
-		return
-	}
-
-	if globalConfig.LogMaxCount > 0 {
-		MaxKeepLogCount = globalConfig.LogMaxCount
+		fmt.Fprintln(os.Stderr, "读取配置文件错误!")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
 	}
 
 	// 日志
 	var w = CrazyLogWriter("logs", "net-" + globalConfig.Proto + "-" + strconv.Itoa(int(globalConfig.Role)), true)
+	logOutput = w
 
 	golog.VisitLogger(".*", func(logger *golog.Logger) bool {
 		logger.SetLevel(golog.Level_Info)
@@ -88,32 +150,46 @@ func main() {
 	})
 
 	netLog.Infoln("配置文件:", globalConfig)
+	for _, t := range globalConfig.Targets {
+		netLog.Infoln("探测目标:", *t)
+	}
 
-	var worker IDevice
-
-	if globalConfig.Role == ERoleClient {
-		var client = NewClient(globalConfig.Proto)
-		client.OpenClient(globalConfig.ServerAddr)
-		worker = client
-	} else if globalConfig.Role == ERoleServer {
-		var server = NewServer(globalConfig.Proto)
-		server.OpenServer(globalConfig.ServerAddr)
-		worker = server
-	} else {
-		panic("无效的配置文件")
+	// 探测结果的存储，要在开始探测之前打开
+	if len(globalConfig.StoreDir) > 0 {
+		resultStore = openProbeStore(globalConfig.StoreDir, configStoreTiers(&globalConfig))
+	}
+	if len(globalConfig.ResultFormat) > 0 {
+		var dir = globalConfig.ResultDir
+		if len(dir) == 0 {
+			dir = "results"
+		}
+		resultOutput, err = newResultSink(dir, "result-" + globalConfig.Proto + "-" + strconv.Itoa(int(globalConfig.Role)), globalConfig.ResultFormat)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// 服务器和客户端，重新加载配置后按新的配置调整
+	var worker = newProbeWorkers()
+	worker.Apply(&globalConfig)
+
+	startHttpServer(globalConfig.HttpAddr)
+
+	if globalConfig.NotEmail == 0 {
+		startNotifyQueueWithConfig(&globalConfig)
 	}
 
 	go timerReportData()
 
-	// 监听终止
+	// 配置文件变了或者收到SIGHUP时重新加载
+	go watchConfig(opt, worker)
+
+	// 监听终止，退出前写完日志和结果
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	netLog.Infoln("等待中止信号")
-	<-c
-	netLog.Infoln("收到中止信号")
-
-	worker.Close()
+	var sig = <-c
+	netLog.Infoln("收到中止信号:", sig)
 
+	os.Exit(shutdown(worker, opt.shutdownTimeout, c))
 }
-
-
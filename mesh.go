@@ -0,0 +1,140 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:29
+ * Comment: 网状模式，每个节点既是回显服务器，又探测所有的其他节点
+ *          所有节点使用同一份配置，Peers里包含自己也没关系，会自动跳过
+ *          [target.节点名字]单独设置到这个节点的探测参数，其他的[target.xxx]是额外的探测目标
+ */
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+type meshPeer struct {
+	Name string
+	Addr string
+}
+
+// 格式：名字=地址，多个用逗号分隔，名字可以省略，例如 dc1=10.0.0.1:20201, 10.0.1.1:20201
+func parsePeers(peers string) []meshPeer {
+	var ret []meshPeer
+	for _, v := range strings.Split(peers, ",") {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+
+		var p meshPeer
+		if idx := strings.IndexByte(v, '='); idx >= 0 {
+			p.Name = strings.TrimSpace(v[:idx])
+			p.Addr = strings.TrimSpace(v[idx+1:])
+		} else {
+			p.Addr = v
+		}
+		if len(p.Name) == 0 {
+			p.Name = p.Addr
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+// 本节点的名字，没有配置时使用主机名
+func meshNodeName(cfg *GlobalConfig) string {
+	if len(cfg.NodeName) > 0 {
+		return cfg.NodeName
+	}
+	var name, err = os.Hostname()
+	if err != nil || len(name) == 0 {
+		return "localhost"
+	}
+	return name
+}
+
+// 是不是本节点自己：名字相同，或者是本机的IP并且端口和本节点侦听的端口相同
+func isSelfPeer(p meshPeer, nodeName string, listenAddr string) bool {
+	if p.Name == nodeName {
+		return true
+	}
+
+	var host, port, err = net.SplitHostPort(p.Addr)
+	if err != nil {
+		return false
+	}
+	var _, listenPort, _ = net.SplitHostPort(listenAddr)
+	if port != listenPort {
+		return false
+	}
+
+	var ips, _ = net.LookupIP(host)
+	var addrs, _ = net.InterfaceAddrs()
+	for _, ip := range ips {
+		if ip.IsLoopback() {
+			return true
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 每个其他节点是一个探测目标，名字是 本节点->对方节点
+// sections是[target.xxx]配置段：和节点同名的覆盖这个节点的探测参数，地址还是用Peers里的；其他的是额外的探测目标
+func meshTargets(cfg *GlobalConfig, sections []*TargetConfig) []*TargetConfig {
+	var nodeName = meshNodeName(cfg)
+	var extra = make(map[string]*TargetConfig)
+	for _, t := range sections {
+		extra[t.Name] = t
+	}
+
+	var targets []*TargetConfig
+	for _, p := range parsePeers(cfg.Peers) {
+		var t, ok = extra[p.Name]
+		delete(extra, p.Name)
+		if isSelfPeer(p, nodeName, cfg.ServerAddr) {
+			continue
+		}
+		if !ok {
+			t = newTargetConfig(p.Name, cfg)
+		}
+		var peer = *t
+		peer.Name = nodeName + "->" + p.Name
+		peer.ServerAddr = p.Addr
+		targets = append(targets, &peer)
+	}
+	for _, t := range sections {
+		if _, ok := extra[t.Name]; ok {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// Peers里有没有这个名字的节点
+func isMeshPeerName(peers string, name string) bool {
+	for _, p := range parsePeers(peers) {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 本节点到其他所有节点的一行矩阵数据：丢包率和延迟
+func meshReport(snaps []*statsSnapshot, window time.Duration) string {
+	var parts []string
+	for _, s := range snaps {
+		var w = s.Window(window)
+		parts = append(parts, fmt.Sprintf("%s: 丢包率=%.2f%%, p50=%d, p99=%d", s.Name, w.Counts.LossPercent(), w.Latency.P50, w.Latency.P99))
+	}
+	return strings.Join(parts, " | ")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 网状模式下的[target.xxx]：和节点同名的覆盖探测参数，其他的是额外的目标
+func TestMeshTargetSections(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "mesh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var path = filepath.Join(dir, "mesh.ini")
+	ioutil.WriteFile(path, []byte(`[main]
+Role = 3
+Proto = tcp
+NodeName = a
+ServerAddr = :20201
+ProbeInterval = 100
+Peers = a=127.0.0.1:20201, b=10.0.0.2:20201, c=10.0.0.3:20201
+[target.b]
+ProbeInterval = 50
+[target.x]
+ServerAddr = 10.0.0.9:1
+`), 0666)
+	var cfg *loadedConfig
+	if cfg, err = parseConfig(path, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var want = []struct {
+		name     string
+		addr     string
+		interval int64
+	}{
+		{"a->b", "10.0.0.2:20201", 50},
+		{"a->c", "10.0.0.3:20201", 100},
+		{"x", "10.0.0.9:1", 100},
+	}
+	var targets = cfg.global.Targets
+	if len(targets) != len(want) {
+		t.Fatalf("targets = %s", targetNames(targets))
+	}
+	for i, w := range want {
+		if targets[i].Name != w.name || targets[i].ServerAddr != w.addr || targets[i].ProbeInterval != w.interval {
+			t.Errorf("target %d = %+v, want %+v", i, targets[i], w)
+		}
+	}
+}
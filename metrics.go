@@ -0,0 +1,219 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:30
+ * Comment: Prometheus格式的指标，地址是/metrics
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 往返延迟直方图的桶（毫秒）
+var metricsRttBuckets = []int64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}
+
+func init() {
+	httpMux.HandleFunc("/metrics", handleMetrics)
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	defer CheckPanic(netLog)
+
+	var buf bytes.Buffer
+	writeMetrics(&buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+type metricsWriter struct {
+	buf *bytes.Buffer
+}
+
+func (self *metricsWriter) Header(name, typ, help string) {
+	fmt.Fprintf(self.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (self *metricsWriter) Value(name string, labels string, v float64) {
+	self.buf.WriteString(name)
+	if len(labels) > 0 {
+		self.buf.WriteString("{" + labels + "}")
+	}
+	self.buf.WriteString(" " + strconv.FormatFloat(v, 'g', -1, 64) + "\n")
+}
+
+func metricsLabel(name, value string) string {
+	var r = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return name + `="` + r.Replace(value) + `"`
+}
+
+func targetLabels(s *statsSnapshot) string {
+	return metricsLabel("target", s.Name) + "," + metricsLabel("proto", s.Proto)
+}
+
+func writeMetrics(buf *bytes.Buffer) {
+	var w = &metricsWriter{buf: buf}
+	var all = snapshotAll(false)
+
+	var labels = make([]string, len(all))
+	for i, s := range all {
+		labels[i] = targetLabels(s)
+	}
+
+	var counters = []struct {
+		name string
+		help string
+		get  func(c probeCounts) int64
+	}{
+		{"netprof_probes_sent_total", "Probes sent.", func(c probeCounts) int64 { return c.Sent }},
+		{"netprof_probes_received_total", "Probe replies received.", func(c probeCounts) int64 { return c.Received }},
+		{"netprof_probes_lost_total", "Probes without a reply before ProbeTimeout.", func(c probeCounts) int64 { return c.Lost }},
+		{"netprof_probes_late_total", "Probe replies slower than MaxWaitTime, including replies to probes already counted as lost.", func(c probeCounts) int64 { return c.Late }},
+		{"netprof_probes_reordered_total", "Probe replies that arrived out of order.", func(c probeCounts) int64 { return c.Reorder }},
+		{"netprof_probes_duplicate_total", "Duplicated probe replies.", func(c probeCounts) int64 { return c.Duplicate }},
+		{"netprof_probes_corrupt_total", "Probe replies with bad content or unknown id.", func(c probeCounts) int64 { return c.Corrupt }},
+		{"netprof_probes_unsent_total", "Probes not sent because the session was down.", func(c probeCounts) int64 { return c.Unsent }},
+		{"netprof_silence_seconds_total", "Seconds without any reply from the target.", func(c probeCounts) int64 { return c.Silence }},
+		{"netprof_disconnects_total", "Session disconnects.", func(c probeCounts) int64 { return c.Disconnect }},
+	}
+	for _, c := range counters {
+		w.Header(c.name, "counter", c.help)
+		for i, s := range all {
+			w.Value(c.name, labels[i], float64(c.get(s.Totals)))
+		}
+	}
+
+	w.Header("netprof_rtt_ms", "histogram", "Probe round trip time in milliseconds.")
+	for i, s := range all {
+		for j, le := range metricsRttBuckets {
+			w.Value("netprof_rtt_ms_bucket", labels[i]+","+metricsLabel("le", strconv.FormatInt(le, 10)), float64(s.RttBuckets[j]))
+		}
+		w.Value("netprof_rtt_ms_bucket", labels[i]+`,le="+Inf"`, float64(s.RttCount))
+		w.Value("netprof_rtt_ms_sum", labels[i], float64(s.RttSum))
+		w.Value("netprof_rtt_ms_count", labels[i], float64(s.RttCount))
+	}
+
+	w.Header("netprof_jitter_ms", "gauge", "RFC 3550 interarrival jitter in milliseconds.")
+	for i, s := range all {
+		w.Value("netprof_jitter_ms", labels[i], s.Jitter)
+	}
+
+	w.Header("netprof_clock_offset_ms", "gauge", "Estimated server clock minus client clock in milliseconds.")
+	for i, s := range all {
+		w.Value("netprof_clock_offset_ms", labels[i], float64(s.ClockOffset))
+	}
+
+	w.Header("netprof_session_up", "gauge", "Whether the session to the target is connected.")
+	for i, s := range all {
+		var v float64
+		if s.Connected {
+			v = 1
+		}
+		w.Value("netprof_session_up", labels[i], v)
+	}
+
+	writeServerClientMetrics(w)
+}
+
+// 一个客户端主机所有会话的汇总
+type serverClientMetric struct {
+	Host  string
+	Proto string
+	// 所有会话的累计计数
+	Totals serverClientCounts
+	// 最近的会话开始的时间
+	ConnectTime int64
+	LastSeen    int64
+	Connected   bool
+}
+
+// 按客户端的主机（不含端口）和协议汇总，重连和新的udp会话不会多出新的时间序列
+// 会话ID和端口在仪表盘和报告里看
+func serverClientMetrics(clients []*serverClient) []*serverClientMetric {
+	var hosts = make(map[string]*serverClientMetric)
+	var ret []*serverClientMetric
+	for _, c := range clients {
+		var host, _, err = net.SplitHostPort(c.Addr)
+		if err != nil {
+			host = c.Addr
+		}
+		var m = hosts[c.Proto+" "+host]
+		if m == nil {
+			m = &serverClientMetric{Host: host, Proto: c.Proto}
+			hosts[c.Proto+" "+host] = m
+			ret = append(ret, m)
+		}
+		m.Totals.Add(c.Totals)
+		if c.ConnectTime > m.ConnectTime {
+			m.ConnectTime = c.ConnectTime
+		}
+		if c.LastSeen > m.LastSeen {
+			m.LastSeen = c.LastSeen
+		}
+		m.Connected = m.Connected || c.Connected
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Host != ret[j].Host {
+			return ret[i].Host < ret[j].Host
+		}
+		return ret[i].Proto < ret[j].Proto
+	})
+	return ret
+}
+
+// 服务器端看到的每个客户端
+func writeServerClientMetrics(w *metricsWriter) {
+	var clients = serverClientMetrics(serverClients.Snapshot(false))
+	var now = TimeNowMs()
+
+	var labels = make([]string, len(clients))
+	for i, c := range clients {
+		labels[i] = metricsLabel("client", c.Host) + "," + metricsLabel("proto", c.Proto)
+	}
+
+	var counters = []struct {
+		name string
+		help string
+		get  func(c serverClientCounts) int64
+	}{
+		{"netprof_server_client_packets_received_total", "Probes received by the server from the client.", func(c serverClientCounts) int64 { return c.PacketsIn }},
+		{"netprof_server_client_packets_sent_total", "Probe replies sent by the server to the client.", func(c serverClientCounts) int64 { return c.PacketsOut }},
+		{"netprof_server_client_message_bytes_received_total", "Probe message bytes (ltv header and body, without transport headers) received by the server from the client.", func(c serverClientCounts) int64 { return c.BytesIn }},
+		{"netprof_server_client_message_bytes_sent_total", "Probe reply message bytes (ltv header and body, without transport headers) sent by the server to the client.", func(c serverClientCounts) int64 { return c.BytesOut }},
+		{"netprof_server_client_sequence_gaps_total", "Probe ids skipped by the client, i.e. probes lost on the uplink.", func(c serverClientCounts) int64 { return c.Gaps }},
+		{"netprof_server_client_reordered_total", "Probes that arrived with an id older than the last one.", func(c serverClientCounts) int64 { return c.Reorder }},
+		{"netprof_server_client_sequence_resets_total", "Probes whose id jumped too far from the last one to be a gap or a reorder.", func(c serverClientCounts) int64 { return c.Resync }},
+	}
+	for _, c := range counters {
+		w.Header(c.name, "counter", c.help)
+		for i, client := range clients {
+			w.Value(c.name, labels[i], float64(c.get(client.Totals)))
+		}
+	}
+
+	w.Header("netprof_server_client_connected_seconds", "gauge", "Seconds since the latest session from the client started.")
+	for i, c := range clients {
+		w.Value("netprof_server_client_connected_seconds", labels[i], float64(now-c.ConnectTime)/1000)
+	}
+
+	w.Header("netprof_server_client_last_seen_seconds", "gauge", "Seconds since the last probe from the client.")
+	for i, c := range clients {
+		w.Value("netprof_server_client_last_seen_seconds", labels[i], float64(now-c.LastSeen)/1000)
+	}
+
+	w.Header("netprof_server_client_up", "gauge", "Whether any session from the client is connected.")
+	for i, c := range clients {
+		var v float64
+		if c.Connected {
+			v = 1
+		}
+		w.Value("netprof_server_client_up", labels[i], v)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// 重连和新的udp会话算到同一个客户端上，不会多出新的时间序列
+func TestServerClientMetrics(t *testing.T) {
+	var clients = []*serverClient{
+		{Proto: "udp", Addr: "10.0.0.1:5000", SessionId: 1, ConnectTime: 100, LastSeen: 200, Totals: serverClientCounts{PacketsIn: 10, Gaps: 1}},
+		{Proto: "udp", Addr: "10.0.0.1:5000", SessionId: 2, ConnectTime: 300, LastSeen: 400, Connected: true, Totals: serverClientCounts{PacketsIn: 5}},
+		{Proto: "tcp", Addr: "10.0.0.1:5001", SessionId: 3, ConnectTime: 150, LastSeen: 160, Totals: serverClientCounts{PacketsIn: 2}},
+		{Proto: "tcp", Addr: "10.0.0.1:5002", SessionId: 4, ConnectTime: 170, LastSeen: 180, Totals: serverClientCounts{PacketsIn: 3}},
+		{Proto: "tcp", Addr: "0xc000010000", ConnectTime: 10, LastSeen: 20, Totals: serverClientCounts{PacketsIn: 1}},
+	}
+	var want = []serverClientMetric{
+		{Host: "0xc000010000", Proto: "tcp", ConnectTime: 10, LastSeen: 20, Totals: serverClientCounts{PacketsIn: 1}},
+		{Host: "10.0.0.1", Proto: "tcp", ConnectTime: 170, LastSeen: 180, Totals: serverClientCounts{PacketsIn: 5}},
+		{Host: "10.0.0.1", Proto: "udp", ConnectTime: 300, LastSeen: 400, Connected: true, Totals: serverClientCounts{PacketsIn: 15, Gaps: 1}},
+	}
+	var got = serverClientMetrics(clients)
+	if len(got) != len(want) {
+		t.Fatalf("metrics = %+v", got)
+	}
+	for i := range want {
+		if *got[i] != want[i] {
+			t.Errorf("metric %d = %+v, want %+v", i, *got[i], want[i])
+		}
+	}
+
+	serverClients.mutex.Lock()
+	var old = serverClients.clients
+	serverClients.clients = map[string]*serverClient{"a": clients[0], "b": clients[1]}
+	serverClients.mutex.Unlock()
+	defer func() {
+		serverClients.mutex.Lock()
+		serverClients.clients = old
+		serverClients.mutex.Unlock()
+	}()
+
+	var buf bytes.Buffer
+	writeServerClientMetrics(&metricsWriter{buf: &buf})
+	var text = buf.String()
+	if strings.Contains(text, "session=") ||
+		!strings.Contains(text, `netprof_server_client_packets_received_total{client="10.0.0.1",proto="udp"} 15`+"\n") ||
+		strings.Count(text, "netprof_server_client_up{") != 1 {
+		t.Fatalf("metrics:\n%s", text)
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"github.com/davyxu/cellnet/peer"
 	"github.com/davyxu/cellnet/proc"
 	"github.com/davyxu/cellnet/timer"
-	"github.com/davyxu/cellnet/util"
 	"github.com/davyxu/golog"
 	"time"
 
@@ -24,12 +23,18 @@ import (
 
 var netLog = golog.New("net")
 
+// 关闭客户端时最多等事件队列退出多久
+const clientCloseWait = 5 * time.Second
+
 type NetServer struct {
 	Protocol string
 	Processor string
 
 	queue cellnet.EventQueue
 	peer cellnet.GenericPeer
+
+	// 检查udp客户端是否断开
+	loopExpire *timer.Loop
 }
 
 func (self *NetServer) OpenServer(addr string) {
@@ -42,7 +47,12 @@ func (self *NetServer) OpenServer(addr string) {
 
 	// 创建一个tcp的侦听器，名称为server，连接地址为127.0.0.1:8801，所有连接将事件投递到queue队列,单线程的处理（收发封包过程是多线程）
 	// addr = "127.0.0.1:8801"
-	p := peer.NewGenericPeer( self.Protocol + ".Acceptor", self.Protocol + ".server", addr, queue)
+	// udp用自己的侦听器，每个客户端有单独的会话和地址
+	var peerType = self.Protocol + ".Acceptor"
+	if self.Protocol == "udp" {
+		peerType = udpAcceptorType
+	}
+	p := peer.NewGenericPeer(peerType, self.Protocol + ".server", addr, queue)
 	self.peer = p
 
 	// tcp设置读写5秒超时
@@ -58,6 +68,11 @@ func (self *NetServer) OpenServer(addr string) {
 	// 开始侦听
 	p.Start()
 
+	self.loopExpire = timer.NewLoop(queue, time.Second, func(loop *timer.Loop) {
+		serverClients.Expire(TimeNowMs())
+	}, nil)
+	self.loopExpire.Start()
+
 	// 事件队列开始循环
 	queue.StartLoop()
 
@@ -66,6 +81,10 @@ func (self *NetServer) OpenServer(addr string) {
 
 }
 func (self *NetServer) Close() {
+	self.queue.Post(func() {
+		self.loopExpire.Stop()
+	})
+	self.peer.Stop()
 	self.queue.StopLoop()
 }
 
@@ -77,18 +96,26 @@ func (self *NetServer) onMsg(ev cellnet.Event) {
 	// 有新的连接
 	case *cellnet.SessionAccepted:
 		netLog.Debugln("server accepted")
+		var remoteAddr = sessionRemoteAddr(ev.Session())
+		serverClients.Accepted(self.Protocol, remoteAddr, ev.Session().ID(), TimeNowMs())
 	// 有连接断开
 	case *cellnet.SessionClosed:
 		netLog.Debugln("session closed: ", ev.Session().ID())
+		var remoteAddr = sessionRemoteAddr(ev.Session())
+		serverClients.Closed(self.Protocol, remoteAddr, ev.Session().ID(), TimeNowMs())
 
 	case *PtAck:
 
 		var ret = *msg
+		ret.ServerRecvTime = TimeNowMs()
 		//var s = ev.Session().Raw()
 		//var remoteAddr = s.(net.Conn).RemoteAddr().String()
-		var remoteAddr, _ = util.GetRemoteAddrss(ev.Session())
+		var remoteAddr = sessionRemoteAddr(ev.Session())
 		netLog.Infof("收到信息, from=[%s], msg=[%d]", remoteAddr, ret.Id)
+		serverClients.Received(self.Protocol, remoteAddr, ev.Session().ID(), msg, ret.ServerRecvTime)
+		ret.ServerSendTime = TimeNowMs()
 		ev.Session().Send(ret)
+		serverClients.Sent(self.Protocol, remoteAddr, ev.Session().ID(), &ret)
 	}
 }
 
@@ -96,6 +123,7 @@ type NetClient struct {
 	Protocol string
 	Processor string
 
+	target *TargetConfig
 	host string
 
 	queue cellnet.EventQueue
@@ -104,16 +132,37 @@ type NetClient struct {
 
 	loopCheck1Second *timer.Loop
 
-	lastAck PtAck
+	// 发包计划
+	schedule *probeSchedule
+	probeTimer timer.AfterStopper
+
+	// 下一个要发出的包
+	nextAck PtAck
+	// 发出去还没有结束的探测包
+	tracker *probeTracker
+	stats *targetStats
+	jitter jitterEstimator
+	oneWay oneWayEstimator
 	lastRcvTime int64
 
 	// udp没有重连机制，改成手动的
 	udpDisconnectCount  int
+	// 连接过，再连上算重连
+	everConnected bool
+	// 已经关闭了，不再处理收到的事件
+	closed bool
+	// 退出前停止发包，等待已经发出去的包返回
+	stopped bool
 }
 func (self *NetClient) OpenClient(addr string) {
 	netLog.Infoln("open client. host:", addr, self.Protocol, self.Processor)
 
 	self.host = addr
+	self.tracker = newProbeTracker(self.target.ProbeTimeout, self.target.MaxWaitTime)
+	self.stats = getTargetStats(self.target.Name)
+	self.stats.SetInfo(self.Protocol, addr)
+	self.schedule = newProbeSchedule(self.target.ProbeInterval, self.target.ProbeBurst, self.target.ProbeMode,
+		self.target.ProbeSizes, self.target.StuffingCount)
 
 	// 创建一个事件处理队列，整个客户端只有这一个队列处理事件，客户端属于单线程模型
 	queue := cellnet.NewEventQueue()
@@ -143,41 +192,89 @@ func (self *NetClient) OpenClient(addr string) {
 	}, nil)
 	self.loopCheck1Second.Start()
 
+	self.scheduleProbe()
+
 	// 事件队列开始循环
 	queue.StartLoop()
 }
-func (self *NetClient) timeEvery1Second() {
-	self.lastAck.Id = AddId(self.lastAck.Id)
-	self.lastAck.Time = TimeNowMs()
-	if len(self.lastAck.Stuffing) != globalConfig.StuffingCount && globalConfig.StuffingCount > 0{
-		self.lastAck.Stuffing = make([]int32, globalConfig.StuffingCount)
+func (self *NetClient) scheduleProbe() {
+	if self.closed || self.stopped {
+		return
+	}
+	self.probeTimer = timer.After(self.queue, self.schedule.NextDelay(), func() {
+		defer self.scheduleProbe()
+		for i := 0; i < self.schedule.Burst(); i++ {
+			self.sendProbe(self.schedule.NextSize())
+		}
+	}, nil)
+}
+func (self *NetClient) sendProbe(stuffingCount int) {
+	// 只有发出去的包才占用Id，服务器端按Id的缺口统计上行丢包
+	var id = AddId(self.nextAck.Id)
+	if self.session != nil {
+		self.nextAck.Id = id
+	}
+	self.nextAck.Time = TimeNowMs()
+	if len(self.nextAck.Stuffing) != stuffingCount {
+		self.nextAck.Stuffing = make([]int32, stuffingCount)
 	}
-	if len(self.lastAck.Stuffing) > 0 {
-		self.lastAck.Stuffing[len(self.lastAck.Stuffing)-1] = self.lastAck.Id
+	if len(self.nextAck.Stuffing) > 0 {
+		self.nextAck.Stuffing[len(self.nextAck.Stuffing)-1] = id
 	}
 
-	var msg = self.lastAck
+	var msg = self.nextAck
+	msg.Id = id
 	if self.session != nil {
 		self.session.Send(&msg)
+		if lost, ok := self.tracker.Sent(msg.Id, msg.Time, len(msg.Stuffing)); ok {
+			self.recordLost(lost)
+		}
+		self.stats.RecordSent()
 	} else {
-		netLog.Warnln("网络断开了，无法发包:", self.lastAck.Id)
-		disconnectCount++
+		netLog.Warnln("网络断开了，无法发包:", id, self.target.Name)
+		self.stats.AddCounts(probeCounts{Unsent: 1})
+		self.storeProbe(probeOutcome{Id: msg.Id, Result: EProbeUnsent, Size: len(msg.Stuffing)}, msg.Time, 0)
+	}
+}
+func (self *NetClient) recordLost(v probeOutcome) {
+	netLog.Warnf("丢包了, id=%d, wait(ms)=%d, host=%s\n", v.Id, v.Rtt, self.target.Name)
+	self.stats.RecordLost(v.Size * 4)
+	self.storeProbe(v, TimeNowMs() - v.Rtt, 0)
+}
+func (self *NetClient) timeEvery1Second() {
+	// 关闭之前已经投递到队列里的
+	if self.closed {
+		return
+	}
+
+	// 超时没有返回的，判定为丢包
+	for _, v := range self.tracker.Expire(TimeNowMs()) {
+		self.recordLost(v)
 	}
 
-	// 超过1.5秒没有收到数据包
-	if self.lastRcvTime > 0 && TimeNowMs() - self.lastRcvTime > 1500 {
-		netLog.Warnln("网络断开了，无法收到包")
-		disconnectCount++
+	// 超过1.5秒（发包间隔比较大时，1.5个发包间隔）没有收到数据包
+	var silence int64 = 1500
+	if interval := int64(self.schedule.Interval() / time.Millisecond) * 3 / 2; interval > silence {
+		silence = interval
+	}
+	if self.stopped {
+		// 不再发包了，收不到包是正常的
+	} else if self.lastRcvTime > 0 && TimeNowMs() - self.lastRcvTime > silence {
+		netLog.Warnln("网络断开了，无法收到包", self.target.Name)
+		self.stats.AddCounts(probeCounts{Silence: 1})
 
 		self.udpDisconnectCount++
 		if self.udpDisconnectCount > 10 {
 			self.udpDisconnectCount = 0
 			if self.Protocol == "udp" {
+				self.stats.SetConnected(false, 0)
+				self.writeEvent(resultTypeDisconnect)
 				self.peer.Stop()
-				go func() {
-					time.Sleep(time.Second)
-					self.peer.Start()
-				}()
+				timer.After(self.queue, time.Second, func() {
+					if !self.closed {
+						self.peer.Start()
+					}
+				}, nil)
 			}
 		}
 	} else {
@@ -188,53 +285,186 @@ func (self *NetClient) onMsg(ev cellnet.Event) {
 
 	defer CheckPanic(netLog)
 
+	if self.closed {
+		return
+	}
+
 	switch msg := ev.Message().(type) {
 	case *cellnet.SessionConnected:
 		self.session = ev.Session()
+		self.stats.SetConnected(true, self.session.ID())
+		if self.everConnected {
+			self.writeEvent(resultTypeReconnect)
+		} else {
+			self.writeEvent(resultTypeConnect)
+		}
+		self.everConnected = true
 		netLog.Infoln("client connected")
 	case *cellnet.SessionClosed:
 		self.session = nil
+		self.stats.SetConnected(false, 0)
+		self.writeEvent(resultTypeDisconnect)
 		netLog.Infoln("client error")
 	case *PtAck:
-		recordAck(self.host, &self.lastAck, msg)
+		self.recordAck(msg)
 		self.lastRcvTime = TimeNowMs()
 	}
 }
 func (self *NetClient) Close() {
+	// 在事件队列里停止，关闭之后的事件不再处理
+	self.queue.Post(func() {
+		self.closed = true
+		self.loopCheck1Second.Stop()
+		if self.probeTimer != nil {
+			self.probeTimer.Stop()
+		}
+	})
 	self.peer.Stop()
+	// 上面的清理执行完以后，事件队列的协程退出
+	self.queue.StopLoop()
+
+	// 等队列里剩下的事件处理完，之后不会再有结果记到这个目标的统计里，
+	// 改了协议或者地址重新连接时，同名的新客户端接着用这些统计
+	var done = make(chan struct{})
+	go func() {
+		self.queue.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(clientCloseWait):
+		netLog.Warnln("客户端的事件队列没有退出:", self.host)
+	}
+}
+
+// 停止发包，连接保持，继续接收已经发出去的包
+func (self *NetClient) StopProbe() {
+	self.queue.Post(func() {
+		self.stopped = true
+		if self.probeTimer != nil {
+			self.probeTimer.Stop()
+		}
+	})
+}
+
+// 还在等待返回的包，事件队列没有响应时返回-1
+func (self *NetClient) PendingCount() int {
+	var ret = make(chan int, 1)
+	self.queue.Post(func() {
+		ret <- self.tracker.PendingCount()
+	})
+	select {
+	case n := <-ret:
+		return n
+	case <-time.After(time.Second):
+		return -1
+	}
+}
+
+// 配置变了，协议和地址没变时不用重新连接
+func (self *NetClient) Update(target *TargetConfig) {
+	self.queue.Post(func() {
+		self.target = target
+		self.tracker.SetLimits(target.ProbeTimeout, target.MaxWaitTime)
+		self.schedule = newProbeSchedule(target.ProbeInterval, target.ProbeBurst, target.ProbeMode,
+			target.ProbeSizes, target.StuffingCount)
+	})
 }
 
-func recordAck(host string, old, msg *PtAck) {
+func (self *NetClient) recordAck(msg *PtAck) {
+
+	var host = self.target.Name
 
+	var now = TimeNowMs()
 	if len(msg.Stuffing) > 0 {
 		if msg.Stuffing[len(msg.Stuffing) - 1] != msg.Id {
-			netLog.Warnln("收到的协议是错误的！", old.Id, host)
-			errCount++
+			netLog.Warnln("收到的协议是错误的！", msg.Id, host)
+			self.stats.AddCounts(probeCounts{Corrupt: 1})
+			self.storeProbe(self.tracker.Corrupt(msg.Id, msg.Time, len(msg.Stuffing), now), msg.Time, now)
+			return
 		}
 	}
 
-	if msg.Id == old.Id {
-		if old.Time != msg.Time {
-			netLog.Warnln("收到的协议是错误的！", old.Id, host)
-			errCount++
-		} else {
-			var delta = TimeNowMs() - msg.Time
-			if delta > globalConfig.MaxWaitTime {
-				netLog.Warnf("收到协议返回，超时了, id=%d, cost(ms)=%d, host=%s\n", old.Id, delta, host)
-				overtimeCount++
-			} else {
-				netLog.Infof("收到协议返回, id=%d, cost(ms)=%d, host=%s\n", old.Id, delta, host)
-			}
+	var ret = self.tracker.Received(msg.Id, msg.Time, now)
+	self.storeProbe(ret, msg.Time, now)
+	switch ret.Result {
+	case EProbeOnTime, EProbeLate, EProbeReorder:
+		self.stats.RecordRtt(ret.Rtt, ret.Size * 4)
+		self.stats.SetJitter(self.jitter.Update(msg.Time, now))
+		if d, ok := self.oneWay.Update(msg.Time, msg.ServerRecvTime, msg.ServerSendTime, now); ok {
+			self.stats.RecordOneWay(d)
 		}
-	} else {
-		var delta = TimeNowMs() - msg.Time
-		if delta > globalConfig.MaxWaitTime {
-			netLog.Warnf("协议错乱，超时了, id=%d, cost(ms)=%d, host=%s\n", msg.Id, delta, host)
-			errCount++
-			overtimeCount++
-		} else {
-			netLog.Warnf("协议错乱，id=%d, cost(ms)=%d, host=%s\n", msg.Id, delta, host)
-			errCount++
+	}
+
+	switch ret.Result {
+	case EProbeOnTime:
+		netLog.Infof("收到协议返回, id=%d, cost(ms)=%d, host=%s\n", ret.Id, ret.Rtt, host)
+	case EProbeLate:
+		netLog.Warnf("收到协议返回，超时了, id=%d, cost(ms)=%d, host=%s\n", ret.Id, ret.Rtt, host)
+		self.stats.AddCounts(probeCounts{Late: 1})
+	case EProbeReorder:
+		netLog.Warnf("协议乱序, id=%d, cost(ms)=%d, host=%s\n", ret.Id, ret.Rtt, host)
+		var c = probeCounts{Reorder: 1}
+		if ret.Rtt > self.target.MaxWaitTime {
+			c.Late = 1
 		}
+		self.stats.AddCounts(c)
+	case EProbeLostLate:
+		// 已经算成丢包了，不再算成收到，只记一次超时返回
+		netLog.Warnf("丢包以后又收到了返回, id=%d, cost(ms)=%d, host=%s\n", ret.Id, ret.Rtt, host)
+		self.stats.AddCounts(probeCounts{Late: 1})
+	case EProbeDuplicate:
+		netLog.Warnf("协议重复, id=%d, cost(ms)=%d, host=%s\n", ret.Id, ret.Rtt, host)
+		self.stats.AddCounts(probeCounts{Duplicate: 1})
+	default:
+		netLog.Warnf("协议错乱, id=%d, cost(ms)=%d, host=%s\n", ret.Id, ret.Rtt, host)
+		self.stats.AddCounts(probeCounts{Corrupt: 1})
 	}
-}
\ No newline at end of file
+}
+
+// 探测结果写入本地存储和结果文件
+func (self *NetClient) storeProbe(ret probeOutcome, sendTime, recvTime int64) {
+	if resultStore != nil {
+		resultStore.Append(&probeRecord{
+			Target:   self.target.Name,
+			Proto:    self.Protocol,
+			Seq:      ret.Id,
+			SendTime: sendTime,
+			RecvTime: recvTime,
+			Rtt:      ret.Rtt,
+			Size:     ret.Size * 4,
+			Result:   ret.Result,
+		})
+	}
+
+	if resultOutput != nil {
+		var ev = &resultEvent{
+			Time:     TimeNowMs(),
+			Type:     resultTypeProbe,
+			Target:   self.target.Name,
+			Proto:    self.Protocol,
+			Addr:     self.host,
+			Seq:      ret.Id,
+			SendTime: sendTime,
+			RecvTime: recvTime,
+			Rtt:      ret.Rtt,
+			Size:     ret.Size * 4,
+			Result:   ret.Result.String(),
+		}
+		resultOutput.Write(ev)
+	}
+}
+
+// 连接事件写入结果文件
+func (self *NetClient) writeEvent(typ string) {
+	if resultOutput == nil {
+		return
+	}
+	resultOutput.Write(&resultEvent{
+		Time:   TimeNowMs(),
+		Type:   typ,
+		Target: self.target.Name,
+		Proto:  self.Protocol,
+		Addr:   self.host,
+	})
+}
@@ -8,6 +8,8 @@ package main
 
 import (
 	"strings"
+	"sync"
+	"time"
 )
 
 type IDevice interface {
@@ -23,12 +25,15 @@ type IServer interface {
 type IClient interface {
 	IDevice
 	OpenClient(serverAddr string)
+	Update(target *TargetConfig)
+	StopProbe()
+	PendingCount() int
 }
 
-func NewClient(protocol string) IClient {
-	protocol = strings.ToLower(protocol)
+func NewClient(target *TargetConfig) IClient {
+	var protocol = strings.ToLower(target.Proto)
 
-	return &NetClient{Protocol:protocol, Processor:protocol + ".ltv"}
+	return &NetClient{Protocol:protocol, Processor:protocol + ".ltv", target:target}
 }
 
 func NewServer(protocol string) IServer {
@@ -37,3 +42,166 @@ func NewServer(protocol string) IServer {
 	return &NetServer{Protocol:protocol, Processor:protocol + ".ltv"}
 }
 
+// 正在运行的服务器和客户端，配置变了的时候增加、删除或者更新
+type probeWorkers struct {
+	mutex sync.Mutex
+
+	server IServer
+	// 协议和地址，变了要重新侦听
+	serverKey string
+
+	clients map[string]IClient
+	targets map[string]TargetConfig
+
+	// 退出时关闭了，不再按配置打开
+	closed bool
+}
+
+func newProbeWorkers() *probeWorkers {
+	return &probeWorkers{clients: make(map[string]IClient), targets: make(map[string]TargetConfig)}
+}
+
+// 按配置打开需要的服务器和客户端，配置没变的保持连接和统计
+func (self *probeWorkers) Apply(cfg *GlobalConfig) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.closed {
+		return
+	}
+
+	var serverKey string
+	if cfg.Role == ERoleServer || cfg.Role == ERoleMesh {
+		serverKey = strings.ToLower(cfg.Proto) + " " + cfg.ServerAddr
+	}
+	if serverKey != self.serverKey {
+		if self.server != nil {
+			netLog.Infoln("关闭服务器:", self.serverKey)
+			self.server.Close()
+			self.server = nil
+		}
+		if len(serverKey) > 0 {
+			self.server = NewServer(cfg.Proto)
+			self.server.OpenServer(cfg.ServerAddr)
+		}
+		self.serverKey = serverKey
+	}
+
+	var wanted []*TargetConfig
+	if cfg.Role == ERoleClient || cfg.Role == ERoleMesh {
+		wanted = cfg.Targets
+	}
+	var names = make(map[string]*TargetConfig)
+	for _, t := range wanted {
+		names[t.Name] = t
+	}
+
+	for name, client := range self.clients {
+		var t, ok = names[name]
+		var old = self.targets[name]
+		switch {
+		case !ok:
+			netLog.Infoln("删除探测目标:", name)
+			client.Close()
+			delete(self.clients, name)
+			delete(self.targets, name)
+			removeTargetStats(name)
+			if alerts != nil {
+				alerts.Forget(name)
+			}
+		case old == *t:
+			// 没有变化
+		case strings.EqualFold(old.Proto, t.Proto) && old.ServerAddr == t.ServerAddr:
+			netLog.Infoln("更新探测目标:", *t)
+			client.Update(t)
+			self.targets[name] = *t
+		default:
+			// Close等旧的客户端的事件队列退出以后才返回，新的客户端开始之前旧的不会再记录统计
+			netLog.Infoln("重新连接探测目标:", *t)
+			client.Close()
+			delete(self.clients, name)
+		}
+	}
+
+	for _, t := range wanted {
+		if _, ok := self.clients[t.Name]; ok {
+			continue
+		}
+		var client = NewClient(t)
+		client.OpenClient(t.ServerAddr)
+		self.clients[t.Name] = client
+		self.targets[t.Name] = *t
+	}
+}
+
+// 所有客户端停止发包，等待已经发出去的包返回，最多等到探测包超时或者limit
+// 返回没有等到的包数，以及事件队列没有响应、不知道还有多少包的客户端数
+// 等待时不占用锁，重新加载配置不会被挡住
+func (self *probeWorkers) Drain(limit time.Duration) (pending int, unknown int) {
+	self.mutex.Lock()
+	var clients []IClient
+	var wait time.Duration
+	for name, client := range self.clients {
+		client.StopProbe()
+		clients = append(clients, client)
+
+		var timeout = self.targets[name].ProbeTimeout
+		if timeout <= 0 {
+			timeout = DefaultProbeTimeout
+		}
+		if d := time.Duration(timeout) * time.Millisecond; d > wait {
+			wait = d
+		}
+	}
+	self.mutex.Unlock()
+
+	if wait > limit {
+		wait = limit
+	}
+
+	var deadline = time.Now().Add(wait)
+	for {
+		pending, unknown = pendingCounts(clients)
+		if (pending == 0 && unknown == 0) || time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// 同时问所有的客户端，没有响应的客户端各自最多等1秒，不会一个接一个地累加
+func pendingCounts(clients []IClient) (pending int, unknown int) {
+	var counts = make([]int, len(clients))
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client IClient) {
+			defer wg.Done()
+			counts[i] = client.PendingCount()
+		}(i, client)
+	}
+	wg.Wait()
+
+	for _, n := range counts {
+		if n > 0 {
+			pending += n
+		} else if n < 0 {
+			unknown++
+		}
+	}
+	return
+}
+
+func (self *probeWorkers) Close() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.closed = true
+
+	for _, v := range self.clients {
+		v.Close()
+	}
+	if self.server != nil {
+		self.server.Close()
+	}
+}
@@ -0,0 +1,116 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:33
+ * Comment: 告警通知，每个[notify.名字]配置段是一个通知渠道，Type决定渠道的类型
+ */
+
+package main
+
+import (
+	"fmt"
+	"gopkg.in/ini.v1"
+	"strings"
+)
+
+const notifySectionPrefix = "notify."
+
+// 一条通知
+type Notification struct {
+	Subject string
+	Html    string // html格式的正文，邮件使用
+	Text    string // 纯文本的正文，机器人和命令使用
+
+	Severity string
+	Target   string
+	Time     int64
+}
+
+type INotifier interface {
+	Name() string
+	Notify(n *Notification) error
+}
+
+type NotifierConfig struct {
+	Name string `ini:"-"`
+
+	// smtp, webhook, slack, dingtalk, wecom, feishu, command
+	Type string
+
+	// smtp：服务器、端口、账号、密码、发件人、收件人（逗号分隔）、加密方式（ssl, starttls）
+	Host     string
+	Port     int
+	Account  string
+	Password string
+	From     string
+	To       string
+	Security string
+
+	// webhook和各种机器人的地址，钉钉和飞书的加签密钥
+	Url    string
+	Secret string
+
+	// command：要执行的命令，用sh -c执行（Windows上是cmd /C），标题和正文通过环境变量和标准输入传入
+	Command string
+
+	// 超时（秒），默认10
+	Timeout int
+}
+
+var notifierCreators = map[string]func(cfg *NotifierConfig) (INotifier, error){
+	"smtp":     newSmtpNotifier,
+	"webhook":  newWebhookNotifier,
+	"slack":    newSlackNotifier,
+	"dingtalk": newDingTalkNotifier,
+	"wecom":    newWeComNotifier,
+	"feishu":   newFeishuNotifier,
+	"command":  newCommandNotifier,
+}
+
+func newNotifier(cfg *NotifierConfig) (INotifier, error) {
+	var creator, ok = notifierCreators[strings.ToLower(cfg.Type)]
+	if !ok {
+		return nil, fmt.Errorf("不支持的通知类型: %s", cfg.Type)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10
+	}
+	return creator(cfg)
+}
+
+// 读取所有的[notify.xxx]配置段
+func loadNotifiers(iniCfg *ini.File) ([]INotifier, error) {
+	var ret []INotifier
+
+	for _, section := range iniCfg.Sections() {
+		if !strings.HasPrefix(section.Name(), notifySectionPrefix) {
+			continue
+		}
+
+		var cfg = &NotifierConfig{Name: strings.TrimPrefix(section.Name(), notifySectionPrefix)}
+		if err := section.MapTo(cfg); err != nil {
+			return nil, err
+		}
+
+		var n, err = newNotifier(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] %s", section.Name(), err.Error())
+		}
+		ret = append(ret, n)
+	}
+
+	return ret, nil
+}
+
+var notifiers []INotifier
+
+// 逗号分隔的列表
+func splitList(s string) []string {
+	var ret []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if len(v) > 0 {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
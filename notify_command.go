@@ -0,0 +1,67 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:33
+ * Comment: 执行本地命令的通知，标题等信息放在环境变量里，纯文本正文从标准输入传入
+ *          命令用sh -c执行（Windows上是cmd /C），可以带引号和参数
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type commandNotifier struct {
+	name    string
+	command string
+	timeout time.Duration
+}
+
+func newCommandNotifier(cfg *NotifierConfig) (INotifier, error) {
+	var command = strings.TrimSpace(cfg.Command)
+	if len(command) == 0 {
+		return nil, errors.New("command需要Command")
+	}
+	return &commandNotifier{
+		name:    cfg.Name,
+		command: command,
+		timeout: time.Duration(cfg.Timeout) * time.Second,
+	}, nil
+}
+
+func (self *commandNotifier) Name() string {
+	return self.name
+}
+
+func (self *commandNotifier) Notify(n *Notification) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), self.timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", self.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", self.command)
+	}
+	cmd.Env = append(os.Environ(),
+		"NETPROF_SUBJECT="+n.Subject,
+		"NETPROF_SEVERITY="+n.Severity,
+		"NETPROF_TARGET="+n.Target,
+		"NETPROF_TIME="+strconv.FormatInt(n.Time, 10),
+	)
+	cmd.Stdin = strings.NewReader(n.Text)
+
+	var out, err = cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// 带引号的参数不能被拆开
+func TestCommandNotifierQuotedArgs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh only")
+	}
+	var dir, err = ioutil.TempDir("", "command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var out = filepath.Join(dir, "out")
+	var n, _ = newNotifier(&NotifierConfig{Name: "hook", Type: "command",
+		Command: `printf '%s|%s|%s' "game servers" "$NETPROF_SUBJECT" "$(cat)" > ` + out})
+	if err := n.Notify(&Notification{Subject: "loss > 2%", Text: "body"}); err != nil {
+		t.Fatal(err)
+	}
+	var data, _ = ioutil.ReadFile(out)
+	if string(data) != "game servers|loss > 2%|body" {
+		t.Fatalf("output = %q", data)
+	}
+}
@@ -0,0 +1,171 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:33
+ * Comment: 通过HTTP发送的通知：通用的webhook、Slack、钉钉、企业微信、飞书机器人
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type webhookNotifier struct {
+	name   string
+	url    string
+	secret string
+	client *http.Client
+
+	// 钉钉、企业微信、飞书出错时也返回200，要检查返回的json里的错误码
+	checkResult bool
+
+	// 把通知转换成各家要求的json
+	build func(self *webhookNotifier, n *Notification) (string, interface{})
+}
+
+func newHttpNotifier(cfg *NotifierConfig, checkResult bool, build func(self *webhookNotifier, n *Notification) (string, interface{})) (INotifier, error) {
+	if len(cfg.Url) == 0 {
+		return nil, errors.New(cfg.Type + "需要Url")
+	}
+	return &webhookNotifier{
+		name:        cfg.Name,
+		url:         cfg.Url,
+		secret:      cfg.Secret,
+		client:      &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		checkResult: checkResult,
+		build:       build,
+	}, nil
+}
+
+func (self *webhookNotifier) Name() string {
+	return self.name
+}
+
+func (self *webhookNotifier) Notify(n *Notification) error {
+	var addr, body = self.build(self, n)
+
+	var data, err = json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := self.client.Post(addr, "application/json; charset=utf-8", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var ret, _ = ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("http %d: %s", resp.StatusCode, string(ret))
+	}
+	if !self.checkResult {
+		return nil
+	}
+
+	// 错误码在json里
+	var result struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+		Code    int    `json:"code"`
+		Msg     string `json:"msg"`
+	}
+	if json.Unmarshal(ret, &result) == nil {
+		if result.ErrCode != 0 {
+			return fmt.Errorf("errcode %d: %s", result.ErrCode, result.ErrMsg)
+		}
+		if result.Code != 0 {
+			return fmt.Errorf("code %d: %s", result.Code, result.Msg)
+		}
+	}
+	return nil
+}
+
+// 机器人消息的文本：标题加纯文本正文
+func notificationText(n *Notification) string {
+	return n.Subject + "\n" + n.Text
+}
+
+// 通用的webhook，直接发送整个通知，只看HTTP状态码，返回的内容由对方决定
+func newWebhookNotifier(cfg *NotifierConfig) (INotifier, error) {
+	return newHttpNotifier(cfg, false, func(self *webhookNotifier, n *Notification) (string, interface{}) {
+		return self.url, map[string]interface{}{
+			"subject":  n.Subject,
+			"text":     n.Text,
+			"html":     n.Html,
+			"severity": n.Severity,
+			"target":   n.Target,
+			"time":     n.Time,
+		}
+	})
+}
+
+// Slack的Incoming Webhook，兼容Mattermost、Rocket.Chat等
+func newSlackNotifier(cfg *NotifierConfig) (INotifier, error) {
+	return newHttpNotifier(cfg, false, func(self *webhookNotifier, n *Notification) (string, interface{}) {
+		return self.url, map[string]interface{}{
+			"text": "*" + n.Subject + "*\n" + n.Text,
+		}
+	})
+}
+
+// 钉钉机器人，配置了Secret时加签
+func newDingTalkNotifier(cfg *NotifierConfig) (INotifier, error) {
+	return newHttpNotifier(cfg, true, func(self *webhookNotifier, n *Notification) (string, interface{}) {
+		var addr = self.url
+		if len(self.secret) > 0 {
+			var timestamp = strconv.FormatInt(TimeNowMs(), 10)
+			var mac = hmac.New(sha256.New, []byte(self.secret))
+			mac.Write([]byte(timestamp + "\n" + self.secret))
+			var sign = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+			var sep = "?"
+			if strings.Contains(addr, "?") {
+				sep = "&"
+			}
+			addr += sep + "timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+		}
+		return addr, map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": notificationText(n)},
+		}
+	})
+}
+
+// 企业微信群机器人
+func newWeComNotifier(cfg *NotifierConfig) (INotifier, error) {
+	return newHttpNotifier(cfg, true, func(self *webhookNotifier, n *Notification) (string, interface{}) {
+		return self.url, map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": notificationText(n)},
+		}
+	})
+}
+
+// 飞书群机器人，配置了Secret时加签
+func newFeishuNotifier(cfg *NotifierConfig) (INotifier, error) {
+	return newHttpNotifier(cfg, true, func(self *webhookNotifier, n *Notification) (string, interface{}) {
+		var body = map[string]interface{}{
+			"msg_type": "text",
+			"content":  map[string]string{"text": notificationText(n)},
+		}
+		if len(self.secret) > 0 {
+			var timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+			var mac = hmac.New(sha256.New, []byte(timestamp+"\n"+self.secret))
+			body["timestamp"] = timestamp
+			body["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		}
+		return self.url, body
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookResultCheck(t *testing.T) {
+	var cases = []struct {
+		kind   string
+		status int
+		body   string
+		ok     bool
+	}{
+		// 通用的webhook返回什么内容由对方决定
+		{"webhook", 200, `{"code": 1, "msg": "queued"}`, true},
+		{"webhook", 500, `{}`, false},
+		{"slack", 200, `ok`, true},
+		{"dingtalk", 200, `{"errcode": 310000, "errmsg": "sign not match"}`, false},
+		{"dingtalk", 200, `{"errcode": 0, "errmsg": "ok"}`, true},
+		{"wecom", 200, `{"errcode": 93000, "errmsg": "invalid webhook url"}`, false},
+		{"feishu", 200, `{"code": 19021, "msg": "sign match fail"}`, false},
+		{"feishu", 200, `{"code": 0, "msg": "success"}`, true},
+	}
+	for _, c := range cases {
+		var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.status)
+			w.Write([]byte(c.body))
+		}))
+		var n, err = newNotifier(&NotifierConfig{Name: c.kind, Type: c.kind, Url: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = n.Notify(&Notification{Subject: "s", Text: "t"})
+		if (err == nil) != c.ok {
+			t.Errorf("%s %d %s: err %v, want ok %v", c.kind, c.status, c.body, err, c.ok)
+		}
+		server.Close()
+	}
+}
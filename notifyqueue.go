@@ -0,0 +1,461 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:35
+ * Comment: 通知的发送队列，每个通知渠道一个队列和一个发送协程
+ *          同一个目标的通知合并成一条发送，相同标题的只保留最新的
+ *          发送失败时按指数退避重试，队列写到磁盘上，重启后继续发送
+ *          还没有配置通知渠道时也写到磁盘上，配置了渠道以后发送
+ *          每个渠道有发送频率的限制，渠道出问题时不会阻塞探测
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	notifyRetryMin = 10 * time.Second
+	notifyRetryMax = 30 * time.Minute
+	// 超过这个时间还没有发出去的通知丢弃掉
+	notifyMaxAge = 24 * time.Hour
+	// 没有配置通知渠道时最多保存多少条通知，超过了丢弃最早的
+	notifyPendingMax = 100
+)
+
+type queuedNotification struct {
+	Id           string
+	Notification *Notification
+	Enqueued     int64
+	Attempts     int
+	NextTry      int64
+}
+
+// 令牌桶，每分钟最多rate条
+type rateLimiter struct {
+	rate   float64
+	tokens float64
+	last   int64
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{rate: float64(perMinute), tokens: float64(perMinute), last: TimeNowMs()}
+}
+
+func (self *rateLimiter) Allow(now int64) bool {
+	self.tokens += float64(now-self.last) * self.rate / 60000
+	if self.tokens > self.rate {
+		self.tokens = self.rate
+	}
+	self.last = now
+
+	if self.tokens < 1 {
+		return false
+	}
+	self.tokens--
+	return true
+}
+
+type notifyChannel struct {
+	name     string
+	notifier INotifier
+	dir      string
+	batch    int64
+	limiter  *rateLimiter
+
+	mutex   sync.Mutex
+	items   []*queuedNotification
+	stopped bool
+}
+
+var fileNameInvalid = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+func newNotifyChannel(notifier INotifier, spoolDir string, batch time.Duration, rate int) *notifyChannel {
+	var ret = &notifyChannel{
+		name:     notifier.Name(),
+		notifier: notifier,
+		dir:      filepath.Join(spoolDir, fileNameInvalid.ReplaceAllString(notifier.Name(), "_")),
+		batch:    int64(batch / time.Millisecond),
+		limiter:  newRateLimiter(rate),
+	}
+	os.MkdirAll(ret.dir, os.ModePerm)
+	ret.load()
+	if len(ret.items) > 0 {
+		netLog.Infoln("读取到未发送的通知:", ret.name, len(ret.items))
+	}
+	return ret
+}
+
+// 读取上次没有发出去的通知
+func (self *notifyChannel) load() {
+	var files, _ = ioutil.ReadDir(self.dir)
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		var data, err = ioutil.ReadFile(filepath.Join(self.dir, f.Name()))
+		var item queuedNotification
+		if err == nil {
+			err = json.Unmarshal(data, &item)
+		}
+		if err != nil || item.Notification == nil {
+			netLog.Warnln("无效的通知文件:", f.Name())
+			os.Remove(filepath.Join(self.dir, f.Name()))
+			continue
+		}
+		self.items = append(self.items, &item)
+	}
+
+	// 文件名是时间和序号，同一毫秒的按文件名的顺序
+	sort.SliceStable(self.items, func(i, j int) bool {
+		return self.items[i].Enqueued < self.items[j].Enqueued
+	})
+}
+
+func (self *notifyChannel) save(item *queuedNotification) {
+	var data, err = json.Marshal(item)
+	if err != nil {
+		return
+	}
+	var name = filepath.Join(self.dir, item.Id+".json")
+	if err = ioutil.WriteFile(name+".tmp", data, 0666); err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		netLog.Warnln("保存通知失败:", name, err.Error())
+	}
+}
+
+func (self *notifyChannel) remove(item *queuedNotification) {
+	os.Remove(filepath.Join(self.dir, item.Id+".json"))
+}
+
+func (self *notifyChannel) Push(item *queuedNotification) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.items = append(self.items, item)
+	self.save(item)
+}
+
+// 丢弃太久没有发出去的通知
+func (self *notifyChannel) expire(now int64) {
+	var left = self.items[:0]
+	for _, v := range self.items {
+		if now-v.Enqueued > int64(notifyMaxAge/time.Millisecond) {
+			netLog.Warnln("通知太久没有发出去，丢弃:", self.name, v.Notification.Subject)
+			self.remove(v)
+			continue
+		}
+		left = append(left, v)
+	}
+	self.items = left
+}
+
+// 可以发送的通知，按目标分组
+// 一组里最早的还没有发送过的通知等够了batch，整组一起发送，后面几秒进来的通知也合并在里面
+func (self *notifyChannel) ready(now int64) [][]*queuedNotification {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.expire(now)
+
+	var groups = make(map[string][]*queuedNotification)
+	var oldest = make(map[string]int64)
+	var order []string
+	for _, v := range self.items {
+		if v.NextTry > now {
+			continue
+		}
+		var key = v.Notification.Target
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], v)
+		if t, ok := oldest[key]; v.Attempts == 0 && (!ok || v.Enqueued < t) {
+			oldest[key] = v.Enqueued
+		}
+	}
+
+	var ret [][]*queuedNotification
+	for _, k := range order {
+		if t, ok := oldest[k]; ok && now-t < self.batch {
+			continue
+		}
+		ret = append(ret, groups[k])
+	}
+	return ret
+}
+
+func (self *notifyChannel) done(group []*queuedNotification, err error, now int64) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if err == nil {
+		var sent = make(map[*queuedNotification]bool)
+		for _, v := range group {
+			sent[v] = true
+			self.remove(v)
+		}
+		var left = self.items[:0]
+		for _, v := range self.items {
+			if !sent[v] {
+				left = append(left, v)
+			}
+		}
+		self.items = left
+		return
+	}
+
+	for _, v := range group {
+		v.Attempts++
+		var backoff = notifyRetryMin << uint(v.Attempts-1)
+		if backoff > notifyRetryMax || backoff <= 0 {
+			backoff = notifyRetryMax
+		}
+		v.NextTry = now + int64(backoff/time.Millisecond)
+		self.save(v)
+	}
+}
+
+// 重新加载配置后换成新的设置，队列里的通知不变
+func (self *notifyChannel) SetNotifier(notifier INotifier) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.notifier = notifier
+}
+
+// 渠道删除了，停止发送，没有发出去的通知留在spool里
+func (self *notifyChannel) Stop() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.stopped = true
+}
+
+func (self *notifyChannel) flush(now int64) {
+	self.mutex.Lock()
+	var notifier = self.notifier
+	self.mutex.Unlock()
+
+	for _, group := range self.ready(now) {
+		if !self.limiter.Allow(now) {
+			netLog.Warnln("通知太频繁，稍后再发:", self.name)
+			return
+		}
+
+		var n = mergeNotifications(group)
+		var err = notifier.Notify(n)
+		if err != nil {
+			netLog.Warnln("发送通知错误:", self.name, err.Error())
+		}
+		self.done(group, err, TimeNowMs())
+	}
+}
+
+func (self *notifyChannel) run() {
+	for true {
+		time.Sleep(time.Second)
+
+		self.mutex.Lock()
+		var stopped = self.stopped
+		self.mutex.Unlock()
+		if stopped {
+			return
+		}
+
+		func() {
+			defer CheckPanic(netLog)
+			self.flush(TimeNowMs())
+		}()
+	}
+}
+
+var severityOrder = map[string]int{"info": 1, "warning": 2, "critical": 3}
+
+// 合并同一个目标的多条通知，相同标题的只保留最新的
+func mergeNotifications(group []*queuedNotification) *Notification {
+	var latest = make(map[string]*Notification)
+	var subjects []string
+	for _, v := range group {
+		var n = v.Notification
+		if _, ok := latest[n.Subject]; !ok {
+			subjects = append(subjects, n.Subject)
+		}
+		latest[n.Subject] = n
+	}
+	if len(subjects) == 1 {
+		return latest[subjects[0]]
+	}
+
+	var ret = &Notification{
+		Subject: fmt.Sprintf("%s 等%d条通知", subjects[0], len(subjects)),
+		Target:  latest[subjects[0]].Target,
+	}
+	var texts, htmls []string
+	for _, s := range subjects {
+		var n = latest[s]
+		texts = append(texts, n.Subject+"\n"+n.Text)
+		htmls = append(htmls, "<h3>"+n.Subject+"</h3>\n"+n.Html)
+		if severityOrder[n.Severity] > severityOrder[ret.Severity] {
+			ret.Severity = n.Severity
+		}
+		if n.Time > ret.Time {
+			ret.Time = n.Time
+		}
+	}
+	ret.Text = strings.Join(texts, "\n\n")
+	ret.Html = strings.Join(htmls, "<hr>\n")
+	return ret
+}
+
+// 没有配置通知渠道时，通知先保存在这个文件夹里，配置了渠道以后放到每个渠道的队列里
+// 渠道的文件夹名字里的#都换成了_，不会重名
+const notifyPendingDir = "#pending"
+
+var notifyChannels []*notifyChannel
+var notifyChannelsMutex sync.Mutex
+var notifySeq int64
+
+// 队列的设置，启动后不再改变
+var notifySpoolDir string
+var notifyBatch time.Duration
+var notifyRate int
+
+func startNotifyQueue(spoolDir string, batch time.Duration, rate int) {
+	notifySpoolDir, notifyBatch, notifyRate = spoolDir, batch, rate
+	updateNotifyQueue(currentNotifiers())
+}
+
+// 按配置启动，没有配置的使用默认值
+func startNotifyQueueWithConfig(cfg *GlobalConfig) {
+	var spoolDir = cfg.SpoolDir
+	if len(spoolDir) == 0 {
+		spoolDir = "spool"
+	}
+	var batch = cfg.NotifyBatchDelay
+	if batch <= 0 {
+		batch = 10
+	}
+	var rate = cfg.NotifyRateLimit
+	if rate <= 0 {
+		rate = 6
+	}
+	startNotifyQueue(spoolDir, time.Duration(batch)*time.Second, rate)
+}
+
+func notifyQueueStarted() bool {
+	return len(notifySpoolDir) > 0
+}
+
+// 重新加载配置后，按名字对应通知渠道：新的开始发送，删除的停止，其他的换成新的设置
+func updateNotifyQueue(list []INotifier) {
+	notifyChannelsMutex.Lock()
+	defer notifyChannelsMutex.Unlock()
+
+	var old = make(map[string]*notifyChannel)
+	for _, c := range notifyChannels {
+		old[c.name] = c
+	}
+
+	var channels []*notifyChannel
+	for _, v := range list {
+		if c, ok := old[v.Name()]; ok {
+			c.SetNotifier(v)
+			delete(old, v.Name())
+			channels = append(channels, c)
+			continue
+		}
+		var c = newNotifyChannel(v, notifySpoolDir, notifyBatch, notifyRate)
+		channels = append(channels, c)
+		go c.run()
+	}
+	for _, c := range old {
+		netLog.Infoln("停止通知渠道:", c.name)
+		c.Stop()
+	}
+	notifyChannels = channels
+
+	if len(channels) > 0 {
+		var pending = pendingNotifications()
+		for _, item := range pending.items {
+			for _, c := range channels {
+				var copy = *item
+				c.Push(&copy)
+			}
+			pending.remove(item)
+		}
+	}
+}
+
+// 还没有渠道可以发送的通知，太久的丢弃掉
+func pendingNotifications() *notifyChannel {
+	var ret = &notifyChannel{name: notifyPendingDir, dir: filepath.Join(notifySpoolDir, notifyPendingDir)}
+	ret.load()
+	ret.expire(TimeNowMs())
+	return ret
+}
+
+// 退出时停止所有渠道，没有发出去的通知留在spool里，重启后继续发送
+func stopNotifyQueue() {
+	notifyChannelsMutex.Lock()
+	defer notifyChannelsMutex.Unlock()
+
+	for _, c := range notifyChannels {
+		c.Stop()
+	}
+}
+
+// 把通知放到每个渠道的队列里，马上返回
+func enqueueNotification(n *Notification) {
+	notifyChannelsMutex.Lock()
+	var channels = notifyChannels
+	if len(channels) == 0 {
+		// 在锁里保存，updateNotifyQueue取走的时候不会漏掉
+		savePendingNotification(n)
+		notifyChannelsMutex.Unlock()
+		return
+	}
+	notifyChannelsMutex.Unlock()
+
+	for _, c := range channels {
+		c.Push(newQueuedNotification(n))
+	}
+}
+
+// 没有配置通知渠道时保存在spool里，重新加载配置加了渠道以后再发送
+func savePendingNotification(n *Notification) {
+	if !notifyQueueStarted() {
+		netLog.Warnln("没有配置通知渠道:", n.Subject)
+		return
+	}
+	netLog.Warnln("没有配置通知渠道，保存到spool，配置了通知渠道以后发送:", n.Subject)
+	var pending = pendingNotifications()
+	for len(pending.items) >= notifyPendingMax {
+		netLog.Warnln("没有发送的通知太多，丢弃:", pending.items[0].Notification.Subject)
+		pending.remove(pending.items[0])
+		pending.items = pending.items[1:]
+	}
+	os.MkdirAll(pending.dir, os.ModePerm)
+	pending.save(newQueuedNotification(n))
+}
+
+func newQueuedNotification(n *Notification) *queuedNotification {
+	return &queuedNotification{
+		Id:           fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddInt64(&notifySeq, 1)),
+		Notification: n,
+		Enqueued:     TimeNowMs(),
+	}
+}
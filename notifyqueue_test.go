@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordNotifier struct {
+	name  string
+	mutex sync.Mutex
+	sent  []*Notification
+}
+
+func (self *recordNotifier) Name() string { return self.name }
+func (self *recordNotifier) Notify(n *Notification) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.sent = append(self.sent, n)
+	return nil
+}
+
+// 没有配置通知渠道时的通知，配置了渠道以后发送
+func TestNotifyPendingUntilChannel(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		stopNotifyQueue()
+		notifyChannels = nil
+		notifySpoolDir = ""
+	}()
+
+	startNotifyQueue(dir, 0, 60)
+	enqueueNotification(&Notification{Subject: "a", Target: "t1"})
+	enqueueNotification(&Notification{Subject: "b", Target: "t2"})
+	if n := len(pendingNotifications().items); n != 2 {
+		t.Fatalf("pending = %d, want 2", n)
+	}
+
+	var r1, r2 = &recordNotifier{name: "r1"}, &recordNotifier{name: "r2"}
+	updateNotifyQueue([]INotifier{r1, r2})
+	if n := len(pendingNotifications().items); n != 0 {
+		t.Fatalf("pending after update = %d, want 0", n)
+	}
+	for _, c := range notifyChannels {
+		c.flush(TimeNowMs() + int64(time.Second/time.Millisecond))
+	}
+	for _, r := range []*recordNotifier{r1, r2} {
+		if len(r.sent) != 2 {
+			t.Errorf("%s sent %d, want 2", r.name, len(r.sent))
+		}
+	}
+}
+
+// 同一个目标几秒之内的通知合并成一条，从最早的一条开始等
+func TestNotifyChannelBatch(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var r = &recordNotifier{name: "r"}
+	var c = newNotifyChannel(r, dir, 10*time.Second, 60)
+	var start = TimeNowMs()
+	var cases = []struct {
+		at      int64
+		subject string
+		target  string
+		sent    []string
+	}{
+		{0, "a", "t1", nil},
+		{5000, "b", "t1", nil},
+		{9000, "c", "t2", nil},
+		// t1的第一条等够了10秒，后来的b一起发送
+		{10000, "", "", []string{"a 等2条通知"}},
+		{19000, "", "", []string{"c"}},
+	}
+	for _, v := range cases {
+		if len(v.subject) > 0 {
+			c.Push(&queuedNotification{Id: v.subject, Notification: &Notification{Subject: v.subject, Target: v.target}, Enqueued: start + v.at})
+		}
+		r.sent = nil
+		c.flush(start + v.at)
+		var got []string
+		for _, n := range r.sent {
+			got = append(got, n.Subject)
+		}
+		if strings.Join(got, ",") != strings.Join(v.sent, ",") {
+			t.Errorf("at %d: sent %v, want %v", v.at, got, v.sent)
+		}
+	}
+}
+
+// 没有通知渠道时，保存的通知有数量上限，太久的丢弃
+func TestNotifyPendingPruned(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		notifyChannels = nil
+		notifySpoolDir = ""
+	}()
+
+	startNotifyQueue(dir, 0, 60)
+	var pending = pendingNotifications()
+	os.MkdirAll(pending.dir, os.ModePerm)
+	pending.save(&queuedNotification{Id: "old", Notification: &Notification{Subject: "old"},
+		Enqueued: TimeNowMs() - int64(notifyMaxAge/time.Millisecond) - 1})
+	if n := len(pendingNotifications().items); n != 0 {
+		t.Fatalf("pending = %d, want the old one dropped", n)
+	}
+
+	for i := 0; i < notifyPendingMax+10; i++ {
+		enqueueNotification(&Notification{Subject: fmt.Sprint(i)})
+	}
+	var items = pendingNotifications().items
+	if len(items) != notifyPendingMax || items[0].Notification.Subject != "10" {
+		t.Fatalf("pending = %d, first %s", len(items), items[0].Notification.Subject)
+	}
+	if files, _ := ioutil.ReadDir(pending.dir); len(files) != notifyPendingMax {
+		t.Fatalf("files = %d, want %d", len(files), notifyPendingMax)
+	}
+}
@@ -0,0 +1,63 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:26
+ * Comment: 单向延迟，参照NTP/TWAMP的四个时间戳
+ *          T1 客户端发送（PtAck.Time）  T2 服务器收到（PtAck.ServerRecvTime）
+ *          T3 服务器发送（PtAck.ServerSendTime）  T4 客户端收到
+ */
+
+package main
+
+// 用最近多少个样本估算时钟偏差
+const oneWayFilterSize = 64
+
+// 一次探测算出来的单向延迟，单位毫秒
+type oneWayDelay struct {
+	Forward int64 // 上行，客户端到服务器
+	Reverse int64 // 下行，服务器到客户端
+	Process int64 // 服务器处理时间
+	Offset  int64 // 估算的时钟偏差（服务器时钟 - 客户端时钟）
+}
+
+type oneWaySample struct {
+	delay  int64
+	offset int64
+}
+
+// 时钟偏差的估算，和NTP的时钟过滤器一样，取最近一段时间内往返延迟最小的那个样本的偏差，
+// 这样排队造成的不对称不会影响偏差，而会体现在上行或者下行的延迟上
+type oneWayEstimator struct {
+	samples [oneWayFilterSize]oneWaySample
+	count   int
+	next    int
+}
+
+// 服务器没有打时间戳（旧版本的服务器）时，返回false
+func (self *oneWayEstimator) Update(t1, t2, t3, t4 int64) (oneWayDelay, bool) {
+	var ret oneWayDelay
+	if t2 == 0 || t3 == 0 {
+		return ret, false
+	}
+
+	var delay = (t4 - t1) - (t3 - t2)
+	var offset = ((t2 - t1) + (t3 - t4)) / 2
+
+	self.samples[self.next] = oneWaySample{delay: delay, offset: offset}
+	self.next = (self.next + 1) % oneWayFilterSize
+	if self.count < oneWayFilterSize {
+		self.count++
+	}
+
+	var best = self.samples[0]
+	for i := 1; i < self.count; i++ {
+		if self.samples[i].delay < best.delay {
+			best = self.samples[i]
+		}
+	}
+
+	ret.Offset = best.offset
+	ret.Forward = t2 - t1 - best.offset
+	ret.Reverse = t4 - t3 + best.offset
+	ret.Process = t3 - t2
+	return ret, true
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+// 服务器时钟比客户端快offset，按给定的上行、下行、处理时间生成四个时间戳
+func oneWayStamps(t1, offset, forward, process, reverse int64) (int64, int64, int64, int64) {
+	var t2 = t1 + forward + offset
+	var t3 = t2 + process
+	var t4 = t3 - offset + reverse
+	return t1, t2, t3, t4
+}
+
+func TestOneWayEstimator(t *testing.T) {
+	var e oneWayEstimator
+
+	// 对称的路径，偏差可以精确算出来
+	var d, ok = e.Update(oneWayStamps(1000, 50, 10, 2, 10))
+	if !ok || d != (oneWayDelay{Forward: 10, Reverse: 10, Process: 2, Offset: 50}) {
+		t.Fatalf("symmetric = %+v, %v", d, ok)
+	}
+
+	// 上行排队，往返延迟变大，偏差沿用往返延迟最小的样本，排队体现在上行上
+	d, _ = e.Update(oneWayStamps(2000, 50, 30, 2, 10))
+	if d != (oneWayDelay{Forward: 30, Reverse: 10, Process: 2, Offset: 50}) {
+		t.Fatalf("queued uplink = %+v", d)
+	}
+
+	// 往返延迟更小的样本，偏差换成它的（不对称时有误差）
+	d, _ = e.Update(oneWayStamps(3000, 50, 2, 1, 6))
+	if d != (oneWayDelay{Forward: 4, Reverse: 4, Process: 1, Offset: 48}) {
+		t.Fatalf("asymmetric = %+v", d)
+	}
+
+	// 旧版本的服务器没有时间戳
+	if _, ok := e.Update(4000, 0, 0, 4020); ok {
+		t.Fatalf("missing server stamps accepted")
+	}
+}
+
+// 最小延迟的样本被挤出过滤器以后，换成剩下的样本里最小的
+func TestOneWayEstimatorWindow(t *testing.T) {
+	var e oneWayEstimator
+	e.Update(oneWayStamps(0, 50, 1, 0, 1))
+	var d oneWayDelay
+	for i := int64(1); i <= oneWayFilterSize; i++ {
+		d, _ = e.Update(oneWayStamps(i*1000, 70, 5, 0, 5))
+	}
+	if d.Offset != 70 || d.Forward != 5 || d.Reverse != 5 {
+		t.Fatalf("after window = %+v", d)
+	}
+}
@@ -0,0 +1,186 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:23
+ * Comment: 探测包跟踪表，按照Id把每个返回包和它自己的发送记录对应起来
+ */
+
+package main
+
+// 探测包的结果
+type EProbeResult int32
+
+const (
+	EProbeNone      EProbeResult = iota
+	EProbeOnTime                 // 按时返回
+	EProbeLate                   // 返回了，但是超过了MaxWaitTime
+	EProbeDuplicate              // 同一个包返回了多次
+	EProbeReorder                // 乱序，比它后发出的包先返回了
+	EProbeLost                   // 超过ProbeTimeout没有返回，判定丢包
+	EProbeCorrupt                // 内容错误，或者不认识的Id
+	EProbeUnsent                 // 网络断开，没有发出去
+	EProbeLostLate               // 已经判定丢包之后才返回，仍然算丢包，只另外记一次Late
+)
+
+var probeResultNames = [...]string{"none", "ontime", "late", "duplicate", "reorder", "lost", "corrupt", "unsent", "lostlate"}
+
+func (self EProbeResult) String() string {
+	if self >= 0 && int(self) < len(probeResultNames) {
+		return probeResultNames[self]
+	}
+	return "unknown"
+}
+
+// 默认的探测包超时时间（毫秒）
+const DefaultProbeTimeout int64 = 3000
+
+type pendingProbe struct {
+	Id       int32
+	SendTime int64
+	Size     int
+
+	// 本地的发送序号，Id会回绕，用这个判断先后顺序
+	order uint64
+}
+
+// 已经结束的探测包，用来识别重复包和迟到包
+type finishedProbe struct {
+	order      uint64
+	sendTime   int64
+	finishTime int64
+	lost       bool
+}
+
+// 一个探测包的判定结果
+type probeOutcome struct {
+	Id     int32
+	Result EProbeResult
+	Rtt    int64
+	Size   int
+}
+
+// 探测包跟踪表，只在cellnet的事件队列中使用，不需要加锁
+type probeTracker struct {
+	timeout int64
+	maxWait int64
+
+	pending  map[int32]*pendingProbe
+	finished map[int32]*finishedProbe
+
+	nextOrder  uint64
+	ackedOrder uint64 // 已经返回的包中，最后发出的那个的序号
+}
+
+func newProbeTracker(timeout, maxWait int64) *probeTracker {
+	var ret = &probeTracker{
+		pending:  make(map[int32]*pendingProbe),
+		finished: make(map[int32]*finishedProbe),
+	}
+	ret.SetLimits(timeout, maxWait)
+	return ret
+}
+
+// 修改超时时间，已经发出去的包也按新的时间判断
+func (self *probeTracker) SetLimits(timeout, maxWait int64) {
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	self.timeout = timeout
+	self.maxWait = maxWait
+}
+
+// 记录一次发送，Id回绕时同一个Id的包还没有结束，那个包算丢包，返回它的结果
+func (self *probeTracker) Sent(id int32, sendTime int64, size int) (probeOutcome, bool) {
+	self.nextOrder++
+
+	var lost probeOutcome
+	var overwritten = false
+	if p, ok := self.pending[id]; ok {
+		lost = probeOutcome{Id: id, Result: EProbeLost, Rtt: sendTime - p.SendTime, Size: p.Size}
+		overwritten = true
+	}
+
+	// Id回绕后重新使用，旧的记录作废
+	delete(self.finished, id)
+	self.pending[id] = &pendingProbe{Id: id, SendTime: sendTime, Size: size, order: self.nextOrder}
+	return lost, overwritten
+}
+
+// 收到一个返回包，判定它的结果
+func (self *probeTracker) Received(id int32, sendTime int64, now int64) probeOutcome {
+	var ret = probeOutcome{Id: id, Result: EProbeCorrupt, Rtt: now - sendTime}
+
+	if p, ok := self.pending[id]; ok {
+		ret.Size = p.Size
+		if p.SendTime != sendTime {
+			// Id对上了，但时间戳不对
+			return ret
+		}
+
+		delete(self.pending, id)
+		self.finished[id] = &finishedProbe{order: p.order, sendTime: p.SendTime, finishTime: now}
+
+		if p.order < self.ackedOrder {
+			ret.Result = EProbeReorder
+		} else if ret.Rtt > self.maxWait {
+			ret.Result = EProbeLate
+		} else {
+			ret.Result = EProbeOnTime
+		}
+		if p.order > self.ackedOrder {
+			self.ackedOrder = p.order
+		}
+		return ret
+	}
+
+	if f, ok := self.finished[id]; ok && f.sendTime == sendTime {
+		if f.lost {
+			// 已经判定丢包了，又回来了，丢包已经计入统计和存储，不再算成收到
+			f.lost = false
+			f.finishTime = now
+			ret.Result = EProbeLostLate
+		} else {
+			ret.Result = EProbeDuplicate
+		}
+	}
+
+	return ret
+}
+
+// 收到的返回包内容错误，对应的探测包已经有了结果，不再等待，也不会再算成丢包
+func (self *probeTracker) Corrupt(id int32, sendTime int64, size int, now int64) probeOutcome {
+	var ret = probeOutcome{Id: id, Result: EProbeCorrupt, Rtt: now - sendTime, Size: size}
+	if p, ok := self.pending[id]; ok && p.SendTime == sendTime {
+		ret.Size = p.Size
+		delete(self.pending, id)
+		self.finished[id] = &finishedProbe{order: p.order, sendTime: p.SendTime, finishTime: now}
+	}
+	return ret
+}
+
+// 清理超时的探测包，返回这次判定丢失的包
+func (self *probeTracker) Expire(now int64) []probeOutcome {
+	var lost []probeOutcome
+
+	for id, p := range self.pending {
+		if now-p.SendTime < self.timeout {
+			continue
+		}
+		delete(self.pending, id)
+		self.finished[id] = &finishedProbe{order: p.order, sendTime: p.SendTime, finishTime: now, lost: true}
+		lost = append(lost, probeOutcome{Id: id, Result: EProbeLost, Rtt: now - p.SendTime, Size: p.Size})
+	}
+
+	// 结束的记录保留一个超时周期，用来识别重复包和迟到包
+	for id, f := range self.finished {
+		if now-f.finishTime > self.timeout {
+			delete(self.finished, id)
+		}
+	}
+
+	return lost
+}
+
+// 还在等待返回的探测包数量
+func (self *probeTracker) PendingCount() int {
+	return len(self.pending)
+}
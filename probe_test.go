@@ -0,0 +1,138 @@
+package main
+
+import "testing"
+
+type probeStep struct {
+	// send, recv, corrupt, expire
+	op   string
+	id   int32
+	time int64 // send的发送时间，recv、corrupt和expire的当前时间
+	sent int64 // recv时包里带的发送时间
+
+	want []probeOutcome
+}
+
+func runProbeSteps(t *testing.T, tracker *probeTracker, steps []probeStep) {
+	t.Helper()
+	for i, s := range steps {
+		var got []probeOutcome
+		switch s.op {
+		case "send":
+			if lost, ok := tracker.Sent(s.id, s.time, 10); ok {
+				got = []probeOutcome{lost}
+			}
+		case "recv":
+			got = []probeOutcome{tracker.Received(s.id, s.sent, s.time)}
+		case "corrupt":
+			got = []probeOutcome{tracker.Corrupt(s.id, s.sent, 10, s.time)}
+		case "expire":
+			got = tracker.Expire(s.time)
+		}
+		if len(got) != len(s.want) {
+			t.Fatalf("step %d %s id=%d: got %v, want %v", i, s.op, s.id, got, s.want)
+		}
+		for j := range got {
+			if got[j].Id != s.want[j].Id || got[j].Result != s.want[j].Result || got[j].Rtt != s.want[j].Rtt {
+				t.Fatalf("step %d %s id=%d: got %+v, want %+v", i, s.op, s.id, got[j], s.want[j])
+			}
+		}
+	}
+}
+
+func TestProbeTracker(t *testing.T) {
+	var cases = []struct {
+		name  string
+		steps []probeStep
+	}{
+		{"ontime", []probeStep{
+			{op: "send", id: 1, time: 1000},
+			{op: "recv", id: 1, time: 1005, sent: 1000, want: []probeOutcome{{Id: 1, Result: EProbeOnTime, Rtt: 5}}},
+			{op: "expire", time: 5000},
+		}},
+		{"late", []probeStep{
+			{op: "send", id: 1, time: 1000},
+			{op: "recv", id: 1, time: 1050, sent: 1000, want: []probeOutcome{{Id: 1, Result: EProbeLate, Rtt: 50}}},
+		}},
+		{"duplicate", []probeStep{
+			{op: "send", id: 1, time: 1000},
+			{op: "recv", id: 1, time: 1005, sent: 1000, want: []probeOutcome{{Id: 1, Result: EProbeOnTime, Rtt: 5}}},
+			{op: "recv", id: 1, time: 1006, sent: 1000, want: []probeOutcome{{Id: 1, Result: EProbeDuplicate, Rtt: 6}}},
+		}},
+		{"reorder", []probeStep{
+			{op: "send", id: 1, time: 1000},
+			{op: "send", id: 2, time: 1001},
+			{op: "recv", id: 2, time: 1003, sent: 1001, want: []probeOutcome{{Id: 2, Result: EProbeOnTime, Rtt: 2}}},
+			{op: "recv", id: 1, time: 1004, sent: 1000, want: []probeOutcome{{Id: 1, Result: EProbeReorder, Rtt: 4}}},
+		}},
+		{"lost then late", []probeStep{
+			{op: "send", id: 1, time: 1000},
+			{op: "expire", time: 3999},
+			{op: "expire", time: 4000, want: []probeOutcome{{Id: 1, Result: EProbeLost, Rtt: 3000}}},
+			{op: "recv", id: 1, time: 4100, sent: 1000, want: []probeOutcome{{Id: 1, Result: EProbeLostLate, Rtt: 3100}}},
+			{op: "recv", id: 1, time: 4200, sent: 1000, want: []probeOutcome{{Id: 1, Result: EProbeDuplicate, Rtt: 3200}}},
+		}},
+		{"corrupt", []probeStep{
+			{op: "send", id: 1, time: 1000},
+			// 不认识的Id
+			{op: "recv", id: 7, time: 1005, sent: 1000, want: []probeOutcome{{Id: 7, Result: EProbeCorrupt, Rtt: 5}}},
+			// Id对上了，时间戳不对
+			{op: "recv", id: 1, time: 1005, sent: 999, want: []probeOutcome{{Id: 1, Result: EProbeCorrupt, Rtt: 6}}},
+			{op: "recv", id: 1, time: 1006, sent: 1000, want: []probeOutcome{{Id: 1, Result: EProbeOnTime, Rtt: 6}}},
+		}},
+		// 内容错误的包也是这个探测包的结果，不能再算一次丢包
+		{"corrupt reply is not lost", []probeStep{
+			{op: "send", id: 1, time: 1000},
+			{op: "corrupt", id: 1, time: 1005, sent: 1000, want: []probeOutcome{{Id: 1, Result: EProbeCorrupt, Rtt: 5}}},
+			{op: "expire", time: 4000},
+			{op: "recv", id: 1, time: 1006, sent: 1000, want: []probeOutcome{{Id: 1, Result: EProbeDuplicate, Rtt: 6}}},
+		}},
+		{"corrupt reply with unknown send time", []probeStep{
+			{op: "send", id: 1, time: 1000},
+			{op: "corrupt", id: 1, time: 1005, sent: 999, want: []probeOutcome{{Id: 1, Result: EProbeCorrupt, Rtt: 6}}},
+			{op: "expire", time: 4000, want: []probeOutcome{{Id: 1, Result: EProbeLost, Rtt: 3000}}},
+		}},
+		{"finished forgotten after timeout", []probeStep{
+			{op: "send", id: 1, time: 1000},
+			{op: "recv", id: 1, time: 1005, sent: 1000, want: []probeOutcome{{Id: 1, Result: EProbeOnTime, Rtt: 5}}},
+			{op: "expire", time: 4006},
+			{op: "recv", id: 1, time: 4007, sent: 1000, want: []probeOutcome{{Id: 1, Result: EProbeCorrupt, Rtt: 3007}}},
+		}},
+		{"id reused after wrap", []probeStep{
+			{op: "send", id: 5, time: 1000},
+			{op: "recv", id: 5, time: 1005, sent: 1000, want: []probeOutcome{{Id: 5, Result: EProbeOnTime, Rtt: 5}}},
+			{op: "send", id: 5, time: 2000},
+			// 旧的包又回来了，和新的发送记录对不上
+			{op: "recv", id: 5, time: 2001, sent: 1000, want: []probeOutcome{{Id: 5, Result: EProbeCorrupt, Rtt: 1001}}},
+			{op: "recv", id: 5, time: 2003, sent: 2000, want: []probeOutcome{{Id: 5, Result: EProbeOnTime, Rtt: 3}}},
+		}},
+		{"pending id reused counts as lost", []probeStep{
+			{op: "send", id: 5, time: 1000},
+			{op: "send", id: 5, time: 1800, want: []probeOutcome{{Id: 5, Result: EProbeLost, Rtt: 800}}},
+			{op: "recv", id: 5, time: 1801, sent: 1000, want: []probeOutcome{{Id: 5, Result: EProbeCorrupt, Rtt: 801}}},
+			{op: "recv", id: 5, time: 1803, sent: 1800, want: []probeOutcome{{Id: 5, Result: EProbeOnTime, Rtt: 3}}},
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			runProbeSteps(t, newProbeTracker(3000, 10), c.steps)
+		})
+	}
+}
+
+func TestProbeTrackerSetLimits(t *testing.T) {
+	var tracker = newProbeTracker(0, 10)
+	if tracker.timeout != DefaultProbeTimeout {
+		t.Fatalf("timeout = %d, want default %d", tracker.timeout, DefaultProbeTimeout)
+	}
+
+	tracker.Sent(1, 1000, 10)
+	tracker.SetLimits(500, 100)
+	// 已经发出去的包也按新的超时判断
+	runProbeSteps(t, tracker, []probeStep{
+		{op: "expire", time: 1500, want: []probeOutcome{{Id: 1, Result: EProbeLost, Rtt: 500}}},
+	})
+	if n := tracker.PendingCount(); n != 0 {
+		t.Fatalf("pending = %d, want 0", n)
+	}
+}
@@ -19,6 +19,10 @@ type PtAck struct {
 	Id int32
 	Time int64
 	Stuffing []int32
+
+	// 服务器收到和发出的时间，用来计算单向延迟
+	ServerRecvTime int64
+	ServerSendTime int64
 }
 
 func init() {
@@ -0,0 +1,129 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 04:06
+ * Comment: 不重启进程，重新加载配置
+ *          收到SIGHUP，或者配置文件的修改时间变了（每2秒检查一次）时重新加载
+ *          探测目标按名字对应：新的开始探测，删除的停止，没变的保持连接和统计，
+ *          只改了超时、发包间隔这些的在原来的连接上更新，改了协议或者地址的重新连接
+ *          告警规则、通知渠道、模板和日报的设置马上生效
+ *          Role、HttpAddr、存储、结果文件和通知队列的设置需要重启才能生效
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const configPollInterval = 2 * time.Second
+
+// 需要重启才能生效的配置，重新加载时保留原来的值
+var restartOnlyConfig = []string{"HttpAddr", "StoreDir", "StoreRawDays", "StoreMinuteDays", "StoreHourDays",
+	"ResultFormat", "ResultDir", "SpoolDir", "NotifyBatchDelay", "NotifyRateLimit"}
+
+// 文件的修改时间和大小，变了就重新加载
+func configStamp(path string) string {
+	var fi, err = os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprint(fi.ModTime().UnixNano(), fi.Size())
+}
+
+func watchConfig(opt *runOptions, worker *probeWorkers) {
+	var hup = make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var stamp = configStamp(opt.config)
+	var ticker = time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			netLog.Infoln("收到SIGHUP，重新加载配置")
+		case <-ticker.C:
+			if configStamp(opt.config) == stamp {
+				continue
+			}
+			// 等编辑器写完
+			time.Sleep(200 * time.Millisecond)
+			netLog.Infoln("配置文件变了，重新加载:", opt.config)
+		}
+		stamp = configStamp(opt.config)
+		reloadConfig(opt, worker)
+	}
+}
+
+// 生效之前恢复需要重启的配置，返回配置文件里改了的那些
+// checkRole: Role已经在解析时覆盖成原来的值了，只检查配置文件里是不是改了
+func keepRestartOnlyConfig(old *GlobalConfig, cfg *loadedConfig, checkRole bool) []string {
+	var changed []string
+	if checkRole && cfg.fileRole != old.Role {
+		netLog.Warnln("需要重启才能生效:", "Role", cfg.fileRole)
+		changed = append(changed, "Role")
+	}
+
+	var oldValue = reflect.ValueOf(old).Elem()
+	var newValue = reflect.ValueOf(&cfg.global).Elem()
+	for _, name := range restartOnlyConfig {
+		var o, n = oldValue.FieldByName(name), newValue.FieldByName(name)
+		if !reflect.DeepEqual(o.Interface(), n.Interface()) {
+			netLog.Warnln("需要重启才能生效:", name, n.Interface())
+			n.Set(o)
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+func reloadConfig(opt *runOptions, worker *probeWorkers) {
+	defer CheckPanic(netLog)
+
+	// 只有这个协程修改配置，读取不需要加锁
+	var old = globalConfig
+
+	// Role不能修改，和命令行参数一样覆盖配置文件
+	var overrides = make(map[string]string)
+	for k, v := range opt.overrides {
+		overrides[k] = v
+	}
+	overrides["Role"] = strconv.Itoa(int(old.Role))
+
+	var cfg, err = parseConfig(opt.config, overrides)
+	if err != nil {
+		netLog.Errorln("重新加载配置失败，继续使用原来的配置:")
+		for _, line := range strings.Split(err.Error(), "\n") {
+			netLog.Errorln(line)
+		}
+		return
+	}
+
+	// 命令行参数指定了Role时，配置文件里的Role本来就不用
+	var _, roleOverridden = opt.overrides["Role"]
+	keepRestartOnlyConfig(&old, cfg, !roleOverridden)
+
+	publishConfig(cfg)
+
+	worker.Apply(&cfg.global)
+
+	if cfg.global.NotEmail == 0 {
+		if notifyQueueStarted() {
+			updateNotifyQueue(cfg.notifiers)
+		} else {
+			startNotifyQueueWithConfig(&cfg.global)
+		}
+	}
+
+	netLog.Infoln("配置已重新加载:", cfg.global)
+	for _, t := range cfg.global.Targets {
+		netLog.Infoln("探测目标:", *t)
+	}
+}
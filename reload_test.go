@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 重新加载：需要重启的配置保留原来的值，其他的马上生效，没变的日报保持原来的发送时间
+func TestReloadConfig(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configMutex.Lock()
+	var oldConfig, oldSchedules = globalConfig, digestSchedules
+	configMutex.Unlock()
+	defer func() {
+		configMutex.Lock()
+		globalConfig, digestSchedules = oldConfig, oldSchedules
+		configMutex.Unlock()
+		alerts = nil
+	}()
+
+	var path = filepath.Join(dir, "a.ini")
+	var write = func(role ERole, httpAddr string, interval int, digest string) {
+		ioutil.WriteFile(path, []byte(`[main]
+Role = `+strconv.Itoa(int(role))+`
+Proto = tcp
+ServerAddr = 127.0.0.1:20201
+HttpAddr = `+httpAddr+`
+ProbeInterval = `+strconv.Itoa(interval)+`
+DigestSchedule = `+digest+`
+`), 0666)
+	}
+	write(ERoleClient, ":9100", 1000, "daily 09:00, weekly mon 09:00")
+	if err := loadConfig(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	var next = time.Date(2026, 10, 19, 9, 0, 0, 0, time.Local)
+	digestSchedules[0].next = next
+
+	var cases = []struct {
+		role      ERole
+		checkRole bool
+		changed   []string
+	}{
+		{ERoleServer, true, []string{"Role", "HttpAddr"}},
+		// 命令行参数指定了Role
+		{ERoleServer, false, []string{"HttpAddr"}},
+		{ERoleClient, true, []string{"HttpAddr"}},
+	}
+	for _, c := range cases {
+		write(c.role, ":9200", 500, "daily 09:00")
+		var old = currentConfig()
+		var cfg, err = parseConfig(path, map[string]string{"Role": strconv.Itoa(int(old.Role))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var changed = keepRestartOnlyConfig(&old, cfg, c.checkRole)
+		if strings.Join(changed, ",") != strings.Join(c.changed, ",") {
+			t.Errorf("role %d: changed %v, want %v", c.role, changed, c.changed)
+		}
+		publishConfig(cfg)
+
+		var now = currentConfig()
+		if now.Role != ERoleClient || now.HttpAddr != ":9100" || now.ProbeInterval != 500 {
+			t.Errorf("role %d: config %+v", c.role, now)
+		}
+		if len(digestSchedules) != 1 || !digestSchedules[0].next.Equal(next) {
+			t.Errorf("role %d: digest schedules %+v, want next %v", c.role, digestSchedules, next)
+		}
+	}
+}
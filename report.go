@@ -9,39 +9,165 @@ package main
 import (
 	"fmt"
 	"github.com/davyxu/cellnet/util"
+	"sort"
+	"strings"
 	"time"
 )
 
 
-var disconnectCount int // 网络断开了
-var errCount int        // 协议错乱了
-var overtimeCount int   // 协议超时了
-
 func timerReportData() {
 
 	var localIp = util.GetLocalIP()
+	var tick = 0
 
 	for true {
 		time.Sleep(10 * time.Second)
-		if disconnectCount > 0 || errCount > 0 || overtimeCount > 0 {
+
+		// 每分钟在日志里记录一次网络质量，以及这一分钟内的问题计数
+		tick++
+		if tick%6 == 0 {
+			logQualityReport("最近一分钟:")
+		}
+
+		// 日报和周报
+		checkDigest(localIp, time.Now())
+
+		var cfg = currentConfig()
+
+		if cfg.Role == ERoleCollector {
+			fleet.Expire(TimeNowMs())
+		}
+
+		// 推送到汇总服务器
+		if len(cfg.CollectorUrl) > 0 {
+			pushToCollector(localIp, snapshotAll(false))
+		}
+
+		// 计算告警规则，状态切换时通知
+		if alerts == nil {
+			continue
+		}
+		var snaps = reportSnapshots(false)
+		var tmpl = currentTemplates()
+		for _, ev := range alerts.Evaluate(snaps, TimeNowMs()) {
 			func() {
-				CheckPanic(netLog)
-				var subject = "network-profiler:" + localIp
-				var body = fmt.Sprintf(globalConfig.Proto + " 断网:%d, 协议错乱:%d, 超时:%d", disconnectCount, errCount, overtimeCount)
-				body += ". 服务器是：" + globalConfig.ServerAddr
-				var succ = true
-
-				if globalConfig.NotEmail == 0 {
-					succ = sendEmail(subject, body)
+				defer CheckPanic(netLog)
+
+				var n *Notification
+				var err error
+				if tmpl != nil {
+					n, err = tmpl.Render(newAlertTemplateData(localIp, ev, snaps))
+					if err != nil {
+						netLog.Warnln("模板错误:", err.Error())
+					}
+				}
+				if n == nil {
+					var lines = []string{ev.Text()}
+					for _, v := range snaps {
+						if v.Name == ev.Target {
+							lines = append(lines, targetReport([]*statsSnapshot{v})...)
+						}
+					}
+					n = &Notification{
+						Subject:  "network-profiler:" + localIp + " " + ev.Subject(),
+						Html:     strings.Join(lines, "<br>\n"),
+						Text:     strings.Join(lines, "\n"),
+						Severity: ev.Rule.Severity,
+						Target:   ev.Target,
+						Time:     ev.Time,
+					}
 				}
 
-				if succ {
-					disconnectCount = 0
-					errCount = 0
-					overtimeCount = 0
+				netLog.Warnln("告警:", n.Subject, ev.Text())
+				// 推送到汇总服务器时，由汇总服务器统一通知
+				if cfg.NotEmail == 0 && len(cfg.CollectorUrl) == 0 {
+					enqueueNotification(n)
 				}
 			}()
 		}
 	}
 
-}
\ No newline at end of file
+}
+
+// 在日志里记录网络质量，以及上次汇报以来的问题计数，title是问题计数那一行的开头
+func logQualityReport(title string) {
+	var role = currentConfig().Role
+	if role == ERoleCollector {
+		for _, line := range fleetReport(TimeNowMs()) {
+			netLog.Infoln("节点:", line)
+		}
+		return
+	}
+
+	var snaps = snapshotAll(true)
+	if line := pendingReport(snaps); len(line) > 0 {
+		netLog.Warnln(title, line)
+	}
+	for _, line := range targetReport(snaps) {
+		netLog.Infoln("网络质量:", line)
+	}
+	if role == ERoleMesh {
+		netLog.Infoln("网状矩阵:", meshReport(snaps, time.Minute))
+	}
+
+	// 服务器端看到的每个客户端，缺口是上行丢的包
+	if role == ERoleServer || role == ERoleMesh {
+		var clients = serverClients.Snapshot(true)
+		if line := serverClientGapReport(clients); len(line) > 0 {
+			netLog.Warnln("客户端上行丢包:", line)
+		}
+		for _, line := range serverClientReport(clients, TimeNowMs()) {
+			netLog.Infoln("客户端:", line)
+		}
+	}
+}
+
+// 上次汇报以来每个目标的问题计数
+func pendingReport(snaps []*statsSnapshot) string {
+	var parts []string
+	for _, v := range snaps {
+		var c = v.Pending
+		if !c.HasProblem() {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s 断网:%d, 连接断开:%d, 协议错乱:%d, 超时:%d, 丢包:%d, 重复:%d, 乱序:%d",
+			v.Name, c.Unsent+c.Silence, c.Disconnect, c.Corrupt, c.Late, c.Lost, c.Duplicate, c.Reorder))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// 每个目标在各个窗口内的丢包率、抖动和延迟分布（毫秒）
+func targetReport(snaps []*statsSnapshot) []string {
+	var lines []string
+	for _, s := range snaps {
+		for _, w := range s.Windows {
+			var c = w.Counts
+			lines = append(lines, fmt.Sprintf("%s [%s] 丢包率=%.2f%%(%d/%d), 超时:%d, 重复:%d, 乱序:%d, 错乱:%d, 断网:%d, 抖动=%.1f, 延迟: %s",
+				s.Name, windowName(w.Window), c.LossPercent(), c.Lost, c.Received+c.Lost,
+				c.Late, c.Duplicate, c.Reorder, c.Corrupt, c.Unsent+c.Silence, s.Jitter, w.Latency))
+
+			if w.Forward.Count > 0 {
+				lines = append(lines, fmt.Sprintf("%s [%s] 上行: p50=%d, p99=%d, 下行: p50=%d, p99=%d, 服务器处理: avg=%.1f, 时钟偏差=%d",
+					s.Name, windowName(w.Window), w.Forward.P50, w.Forward.P99, w.Reverse.P50, w.Reverse.P99, w.Process.Avg, s.ClockOffset))
+			}
+		}
+
+		// 轮换包大小时，按大小列出丢包率
+		var sizes = s.SizeCounts
+		if len(sizes) > 1 {
+			var keys []int
+			for k := range sizes {
+				keys = append(keys, k)
+			}
+			sort.Ints(keys)
+
+			var parts []string
+			for _, k := range keys {
+				var c = sizes[k]
+				parts = append(parts, fmt.Sprintf("%dB=%.2f%%(%d/%d)", k, c.LossPercent(), c.Lost, c.Received+c.Lost))
+			}
+			lines = append(lines, fmt.Sprintf("%s 按包大小的丢包率: %s", s.Name, strings.Join(parts, ", ")))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,103 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:45
+ * Comment: 结构化的探测结果输出，每个探测包和每个连接事件一行，json或者csv
+ *          文件和日志一样按大小切割，最多保留MaxKeepLogCount个
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	resultTypeProbe      = "probe"
+	resultTypeConnect    = "connect"
+	resultTypeDisconnect = "disconnect"
+	resultTypeReconnect  = "reconnect"
+)
+
+// 一行输出，时间都是毫秒
+type resultEvent struct {
+	Time     int64  `json:"time"`
+	Type     string `json:"type"`
+	Target   string `json:"target"`
+	Proto    string `json:"proto"`
+	Addr     string `json:"addr"`
+	Seq      int32  `json:"seq,omitempty"`
+	SendTime int64  `json:"send_time,omitempty"`
+	RecvTime int64  `json:"recv_time,omitempty"`
+	Rtt      int64  `json:"rtt,omitempty"`
+	Size     int    `json:"size,omitempty"`
+	Result   string `json:"result,omitempty"`
+}
+
+var resultCsvHeader = []string{"time", "type", "target", "proto", "addr", "seq", "send_time", "recv_time", "rtt", "size", "result"}
+
+func (self *resultEvent) csvRow() []string {
+	return []string{
+		time.Unix(0, self.Time*int64(time.Millisecond)).Format("2006-01-02T15:04:05.000Z07:00"),
+		self.Type, self.Target, self.Proto, self.Addr,
+		strconv.Itoa(int(self.Seq)),
+		strconv.FormatInt(self.SendTime, 10),
+		strconv.FormatInt(self.RecvTime, 10),
+		strconv.FormatInt(self.Rtt, 10),
+		strconv.Itoa(self.Size),
+		self.Result,
+	}
+}
+
+type resultSink struct {
+	format string
+	writer *rollingLogWriter
+}
+
+// format是json或者csv
+func newResultSink(folder, fileName, format string) (*resultSink, error) {
+	format = strings.ToLower(format)
+	var ext string
+	var header []byte
+	switch format {
+	case "json":
+		ext = ".jsonl"
+	case "csv":
+		ext = ".csv"
+		header = []byte(strings.Join(resultCsvHeader, ",") + "\n")
+	default:
+		return nil, fmt.Errorf("无效的结果格式: %s", format)
+	}
+
+	netLog.Infoln("open result sink:", folder, format)
+	return &resultSink{format: format, writer: newRollingWriter(folder, fileName, ext, header)}, nil
+}
+
+func (self *resultSink) Write(ev *resultEvent) {
+	var buf bytes.Buffer
+	if self.format == "csv" {
+		var w = csv.NewWriter(&buf)
+		w.Write(ev.csvRow())
+		w.Flush()
+	} else {
+		var data, err = json.Marshal(ev)
+		if err != nil {
+			netLog.Warnln("结果编码失败:", err.Error())
+			return
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	self.writer.Write(buf.Bytes())
+}
+
+func (self *resultSink) Close() {
+	self.writer.Quit()
+}
+
+var resultOutput *resultSink
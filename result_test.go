@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var resultTestEvents = []*resultEvent{
+	{Time: 1000, Type: resultTypeConnect, Target: "t1", Proto: "udp", Addr: "1.2.3.4:80"},
+	{Time: 2000, Type: resultTypeProbe, Target: "t1", Proto: "udp", Addr: "1.2.3.4:80", Seq: 1, SendTime: 1990, RecvTime: 2000, Rtt: 10, Size: 64, Result: "ok"},
+	{Time: 3000, Type: resultTypeProbe, Target: "t1", Proto: "udp", Addr: "1.2.3.4:80", Seq: 2, SendTime: 1000, Size: 64, Result: "lost"},
+	{Time: 4000, Type: resultTypeDisconnect, Target: "t1", Proto: "udp", Addr: "1.2.3.4:80"},
+	{Time: 5000, Type: resultTypeReconnect, Target: "t1", Proto: "udp", Addr: "1.2.3.4:80"},
+}
+
+// 写完以后按序号返回每个文件的内容
+func writeResults(t *testing.T, format, ext string) []string {
+	var dir, err = ioutil.TempDir("", "result")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 每个文件只能写两三行，检查切割以后的文件
+	var oldSize = MaxLogSize
+	MaxLogSize = 200
+	defer func() { MaxLogSize = oldSize }()
+
+	sink, err := newResultSink(dir, "result", format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range resultTestEvents {
+		sink.Write(ev)
+		// 退出时剩下的内容直接写完不切割，等写文件的协程取走再写下一行
+		for len(sink.writer.channel) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	sink.Close()
+
+	var files []string
+	for i := 1; ; i++ {
+		var data, err = ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("result.%d%s", i, ext)))
+		if err != nil {
+			break
+		}
+		files = append(files, string(data))
+	}
+	if len(files) < 2 {
+		t.Fatalf("%s: %d files, want the output rotated", format, len(files))
+	}
+	return files
+}
+
+func TestResultSinkJson(t *testing.T) {
+	var got []*resultEvent
+	for _, data := range writeResults(t, "JSON", ".jsonl") {
+		for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+			var ev resultEvent
+			if err := json.Unmarshal([]byte(line), &ev); err != nil {
+				t.Fatalf("%q: %v", line, err)
+			}
+			got = append(got, &ev)
+
+			// 连接事件没有探测包的字段
+			var fields map[string]interface{}
+			json.Unmarshal([]byte(line), &fields)
+			if _, ok := fields["seq"]; ok != (ev.Type == resultTypeProbe) {
+				t.Errorf("%q: unexpected fields", line)
+			}
+		}
+	}
+	if !reflect.DeepEqual(got, resultTestEvents) {
+		t.Errorf("events %+v, want %+v", got, resultTestEvents)
+	}
+}
+
+func TestResultSinkCsv(t *testing.T) {
+	var rows [][]string
+	for i, data := range writeResults(t, "csv", ".csv") {
+		var records, err = csv.NewReader(strings.NewReader(data)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 切割以后的每个文件都有表头
+		if len(records) < 2 || !reflect.DeepEqual(records[0], resultCsvHeader) {
+			t.Fatalf("file %d: %v, want the header and rows", i+1, records)
+		}
+		rows = append(rows, records[1:]...)
+	}
+
+	if len(rows) != len(resultTestEvents) {
+		t.Fatalf("%d rows, want %d", len(rows), len(resultTestEvents))
+	}
+	for i, ev := range resultTestEvents {
+		var when, err = time.Parse("2006-01-02T15:04:05.000Z07:00", rows[i][0])
+		if err != nil || when.UnixNano()/int64(time.Millisecond) != ev.Time {
+			t.Errorf("row %d: time %s, want %d", i, rows[i][0], ev.Time)
+		}
+		var want = []string{ev.Type, ev.Target, ev.Proto, ev.Addr, fmt.Sprint(ev.Seq), fmt.Sprint(ev.SendTime),
+			fmt.Sprint(ev.RecvTime), fmt.Sprint(ev.Rtt), fmt.Sprint(ev.Size), ev.Result}
+		if !reflect.DeepEqual(rows[i][1:], want) {
+			t.Errorf("row %d: %v, want %v", i, rows[i][1:], want)
+		}
+	}
+}
+
+func TestResultSinkFormat(t *testing.T) {
+	if _, err := newResultSink(os.TempDir(), "result", "xml"); err == nil {
+		t.Fatal("want an error for format xml")
+	}
+}
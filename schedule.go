@@ -0,0 +1,147 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:27
+ * Comment: 探测包的发送计划：发送间隔、突发、泊松分布的间隔、包大小轮换
+ */
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davyxu/cellnet/proc/udp"
+)
+
+const (
+	DefaultProbeInterval int64 = 1000
+	MinProbeInterval     int64 = 10
+
+	// PtAck除了Stuffing以外编码后的字节数：Id 4，Time 8，Stuffing的个数 2，ServerRecvTime 8，ServerSendTime 8
+	ptAckFixedSize = 30
+	// 每个附带数据（int32）的字节数
+	ptAckStuffingSize = 4
+)
+
+type probeSchedule struct {
+	interval time.Duration
+	burst    int
+	poisson  bool
+
+	// 每个包附带的数据个数（PtAck.Stuffing的长度），依次轮换
+	sizes   []int
+	sizeIdx int
+
+	rand *rand.Rand
+}
+
+// interval: 发送间隔（毫秒）；burst: 每次连续发几个包；mode: fixed或者poisson；
+// sizes: 逗号分隔的附带数据字节数，为空时使用stuffingCount
+func newProbeSchedule(interval int64, burst int, mode string, sizes string, stuffingCount int) *probeSchedule {
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+	if interval < MinProbeInterval {
+		interval = MinProbeInterval
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	var ret = &probeSchedule{
+		interval: time.Duration(interval) * time.Millisecond,
+		burst:    burst,
+		poisson:  strings.ToLower(mode) == "poisson",
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	ret.sizes = parseProbeSizes(sizes)
+	if len(ret.sizes) == 0 {
+		ret.sizes = []int{stuffingCount}
+	}
+	return ret
+}
+
+// 一个超时时间内最多发出多少个包，要远小于Id的范围，否则Id回绕时还有包没有结束
+func probeIdsInFlight(interval int64, burst int, timeout int64) int64 {
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+	if interval < MinProbeInterval {
+		interval = MinProbeInterval
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	return int64(burst) * (timeout/interval + 1)
+}
+
+// 一个探测包最多附带多少个数据，再多对方就收不到了
+// udp: cellnet收到的udp封包（含ltv头）超过udp.MTU时直接丢掉
+// tcp: ltv头里的包体大小只有2个字节，包体含2个字节的消息ID
+func maxProbeStuffing(proto string) int {
+	if strings.ToLower(proto) == "udp" {
+		return (udp.MTU - ltvHeaderSize - ptAckFixedSize) / ptAckStuffingSize
+	}
+	return (math.MaxUint16 - 2 - ptAckFixedSize) / ptAckStuffingSize
+}
+
+// 字节数换算成Stuffing的个数
+func probeSizeStuffing(n int) int {
+	return (n + ptAckStuffingSize - 1) / ptAckStuffingSize
+}
+
+// 字节数换算成Stuffing的个数（每个int32占4个字节）
+func parseProbeSizes(sizes string) []int {
+	var ret []int
+	for _, v := range strings.Split(sizes, ",") {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+		var n, err = strconv.Atoi(v)
+		if err != nil || n < 0 {
+			netLog.Warnln("无效的包大小:", v)
+			continue
+		}
+		ret = append(ret, probeSizeStuffing(n))
+	}
+	return ret
+}
+
+// 距离下一次发送的时间
+func (self *probeSchedule) NextDelay() time.Duration {
+	if !self.poisson {
+		return self.interval
+	}
+
+	// 泊松过程的间隔服从指数分布
+	var d = time.Duration(self.rand.ExpFloat64() * float64(self.interval))
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	return d
+}
+
+// 每次连续发几个包
+func (self *probeSchedule) Burst() int {
+	return self.burst
+}
+
+// 下一个包附带的数据个数
+func (self *probeSchedule) NextSize() int {
+	var n = self.sizes[self.sizeIdx]
+	self.sizeIdx = (self.sizeIdx + 1) % len(self.sizes)
+	return n
+}
+
+// 平均发送间隔
+func (self *probeSchedule) Interval() time.Duration {
+	return self.interval
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/davyxu/cellnet/codec"
+	"github.com/davyxu/cellnet/proc/udp"
+)
+
+func TestProbeIdsInFlight(t *testing.T) {
+	var cases = []struct {
+		interval int64
+		burst    int
+		timeout  int64
+		want     int64
+	}{
+		{1000, 1, 3000, 4},
+		// 没有配置的用默认值
+		{0, 0, 0, 4},
+		// 小于最小间隔的按最小间隔
+		{1, 1, 3000, 301},
+		{10, 40, 3000, 12040},
+	}
+	for _, c := range cases {
+		if got := probeIdsInFlight(c.interval, c.burst, c.timeout); got != c.want {
+			t.Errorf("probeIdsInFlight(%d, %d, %d) = %d, want %d", c.interval, c.burst, c.timeout, got, c.want)
+		}
+		if got := probeIdsInFlight(c.interval, c.burst, c.timeout); got >= probeIdModulo/4 {
+			t.Errorf("%d probes in flight would wrap the id space", got)
+		}
+	}
+}
+
+func TestAddIdWraps(t *testing.T) {
+	if id := AddId(probeIdModulo - 1); id != 0 {
+		t.Fatalf("AddId(max) = %d, want 0", id)
+	}
+	if id := AddId(9999); id != 10000 {
+		t.Fatalf("AddId(9999) = %d, ids still wrap at 10000", id)
+	}
+}
+
+// 最大的探测包刚好放得进一个udp封包
+func TestMaxProbeStuffing(t *testing.T) {
+	var cases = []struct {
+		proto string
+		limit int
+	}{
+		{"udp", udp.MTU},
+		{"tcp", 65535 + 2},
+	}
+	for _, c := range cases {
+		var n = maxProbeStuffing(c.proto)
+		for _, stuffing := range []int{n, n + 1} {
+			var data, _, err = codec.EncodeMessage(&PtAck{Stuffing: make([]int32, stuffing)}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fits := ltvHeaderSize+len(data) <= c.limit; fits != (stuffing == n) {
+				t.Errorf("%s: %d stuffing encodes to %d bytes, limit %d", c.proto, stuffing, ltvHeaderSize+len(data), c.limit)
+			}
+		}
+	}
+}
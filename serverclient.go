@@ -0,0 +1,263 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 04:13
+ * Comment: 服务器端每个客户端的统计
+ *          记录地址、会话、连接时间、收发的包数和字节数、最后收到包的时间
+ *          客户端的包Id是连续的，跳过的Id就是上行丢的包，服务器端就能发现上行有问题的客户端
+ *          tcp和udp都按会话区分，udp的会话由udpAddrAcceptor按地址分配，
+ *          超过1分钟没有收到包算断开，断开的保留10分钟
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davyxu/cellnet"
+	"github.com/davyxu/cellnet/util"
+)
+
+const (
+	// udp客户端多久没有包算断开，和udp会话的超时一样
+	serverClientIdle = udpSessionTTL
+	// 断开的客户端保留多久
+	serverClientKeep = 10 * time.Minute
+	// 比最后的Id小这么多以内的包算乱序，客户端发包时保证在途的Id不超过这个数
+	serverClientReorderWindow = probeIdModulo / 4
+	// 比最后的Id大这么多以内的包算跳过了中间的Id，再大的和更早的包一样，
+	// 都不可能是同一个客户端连续发出来的，从这个Id重新开始
+	serverClientGapWindow = probeIdModulo / 2
+	// ltv封包头：2字节包体大小，2字节消息ID
+	ltvHeaderSize = 4
+)
+
+// 一个客户端的计数
+type serverClientCounts struct {
+	PacketsIn  int64
+	PacketsOut int64
+	// ltv封包的字节数，不含tcp、udp和ip的头
+	BytesIn  int64
+	BytesOut int64
+	// 跳过的Id数，也就是上行丢的包
+	Gaps int64
+	// 比前面的包Id小的包，乱序或者重复
+	Reorder int64
+	// Id跳得太远，重新开始计算的次数
+	Resync int64
+}
+
+func (self *serverClientCounts) Add(other serverClientCounts) {
+	self.PacketsIn += other.PacketsIn
+	self.PacketsOut += other.PacketsOut
+	self.BytesIn += other.BytesIn
+	self.BytesOut += other.BytesOut
+	self.Gaps += other.Gaps
+	self.Reorder += other.Reorder
+	self.Resync += other.Resync
+}
+
+// 上行丢包率，缺口占应该收到的包的比例
+func (self serverClientCounts) GapPercent() float64 {
+	if self.PacketsIn+self.Gaps == 0 {
+		return 0
+	}
+	return float64(self.Gaps) * 100 / float64(self.PacketsIn+self.Gaps)
+}
+
+type serverClient struct {
+	Proto          string
+	Addr           string
+	SessionId      int64
+	ConnectTime    int64
+	LastSeen       int64
+	Connected      bool
+	DisconnectTime int64
+
+	// 上次汇报以来的计数
+	Pending serverClientCounts
+	// 从连接开始累计的计数
+	Totals serverClientCounts
+
+	// 最后一个按顺序收到的包Id
+	lastId int64
+	hasId  bool
+}
+
+// 所有客户端，服务器的事件队列里修改，汇报和指标在其他协程里读取
+type serverClientTable struct {
+	mutex   sync.Mutex
+	clients map[string]*serverClient
+}
+
+var serverClients = &serverClientTable{clients: make(map[string]*serverClient)}
+
+// 按会话区分，没有会话ID的按地址区分
+func serverClientKey(proto, addr string, sessionId int64) string {
+	if sessionId != 0 {
+		return proto + "#" + strconv.FormatInt(sessionId, 10)
+	}
+	return proto + " " + addr
+}
+
+// 客户端的地址，tcp会话和udpAddrAcceptor的会话都有RemoteAddr
+// 兜底：其他的会话类型（比如cellnet自带的udp.Acceptor）拿不到地址，用会话的指针，
+// 至少能区分不同的客户端，见TestSessionRemoteAddrFallback
+func sessionRemoteAddr(ses cellnet.Session) string {
+	if addr, ok := util.GetRemoteAddrss(ses); ok {
+		return addr
+	}
+	return fmt.Sprintf("%p", ses.Raw())
+}
+
+// 探测包的ltv封包大小，只有Stuffing的长度会变，不用每个包都编码一次
+func ptAckSize(msg *PtAck) int64 {
+	return int64(ltvHeaderSize + ptAckFixedSize + ptAckStuffingSize*len(msg.Stuffing))
+}
+
+func (self *serverClientTable) get(proto, addr string, sessionId int64, now int64) *serverClient {
+	var key = serverClientKey(proto, addr, sessionId)
+	var c = self.clients[key]
+	if c == nil || !c.Connected {
+		c = &serverClient{Proto: proto, Addr: addr, SessionId: sessionId, ConnectTime: now, LastSeen: now, Connected: true}
+		self.clients[key] = c
+	}
+	return c
+}
+
+// tcp连接上了
+func (self *serverClientTable) Accepted(proto, addr string, sessionId int64, now int64) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	// 重新连接的客户端从新的Id开始
+	self.get(proto, addr, sessionId, now).hasId = false
+}
+
+// tcp断开了
+func (self *serverClientTable) Closed(proto, addr string, sessionId int64, now int64) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if c := self.clients[serverClientKey(proto, addr, sessionId)]; c != nil && c.Connected {
+		c.Connected = false
+		c.DisconnectTime = now
+	}
+}
+
+// 收到一个探测包，按Id找出跳过的包
+func (self *serverClientTable) Received(proto, addr string, sessionId int64, msg *PtAck, now int64) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var c = self.get(proto, addr, sessionId, now)
+	c.LastSeen = now
+
+	var counts = serverClientCounts{PacketsIn: 1, BytesIn: ptAckSize(msg)}
+	c.checkId(int64(msg.Id), &counts)
+	c.Pending.Add(counts)
+	c.Totals.Add(counts)
+}
+
+// 和最后的Id比较：往前跳的是缺口，往回不远的是乱序，太远的重新开始
+func (self *serverClient) checkId(id int64, counts *serverClientCounts) {
+	if !self.hasId {
+		self.lastId = id
+		self.hasId = true
+		return
+	}
+	var ahead = (id - self.lastId + probeIdModulo) % probeIdModulo
+	switch {
+	case ahead == 0:
+		counts.Reorder = 1
+	case ahead <= serverClientGapWindow:
+		counts.Gaps = ahead - 1
+		self.lastId = id
+	case probeIdModulo-ahead <= serverClientReorderWindow:
+		counts.Reorder = 1
+	default:
+		counts.Resync = 1
+		self.lastId = id
+	}
+}
+
+// 回复了一个探测包
+func (self *serverClientTable) Sent(proto, addr string, sessionId int64, msg *PtAck) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if c := self.clients[serverClientKey(proto, addr, sessionId)]; c != nil {
+		var counts = serverClientCounts{PacketsOut: 1, BytesOut: ptAckSize(msg)}
+		c.Pending.Add(counts)
+		c.Totals.Add(counts)
+	}
+}
+
+// udp没有断开的事件，很久没有包的算断开；断开很久的删除
+func (self *serverClientTable) Expire(now int64) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for key, c := range self.clients {
+		if c.Connected && c.Proto == "udp" && now-c.LastSeen > int64(serverClientIdle/time.Millisecond) {
+			c.Connected = false
+			c.DisconnectTime = c.LastSeen
+		}
+		if !c.Connected && now-c.DisconnectTime > int64(serverClientKeep/time.Millisecond) {
+			delete(self.clients, key)
+		}
+	}
+}
+
+// 所有客户端的副本，按连接时间排序，reset时清空上次汇报以来的计数
+func (self *serverClientTable) Snapshot(reset bool) []*serverClient {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var ret []*serverClient
+	for _, c := range self.clients {
+		var v = *c
+		ret = append(ret, &v)
+		if reset {
+			c.Pending = serverClientCounts{}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].ConnectTime != ret[j].ConnectTime {
+			return ret[i].ConnectTime < ret[j].ConnectTime
+		}
+		return ret[i].Addr < ret[j].Addr
+	})
+	return ret
+}
+
+// 每个客户端一行，上次汇报以来的计数和累计的计数
+func serverClientReport(clients []*serverClient, now int64) []string {
+	var lines []string
+	for _, c := range clients {
+		var state = "已连接" + time.Duration((now-c.ConnectTime)*int64(time.Millisecond)).Truncate(time.Second).String()
+		if !c.Connected {
+			state = "已断开"
+		}
+		var p, t = c.Pending, c.Totals
+		lines = append(lines, fmt.Sprintf("%s %s 会话:%d %s, 收:%d(%dB), 发:%d(%dB), 缺口:%d(%.2f%%), 乱序:%d, 重置:%d, 累计 收:%d, 发:%d, 缺口:%d, 乱序:%d, 最后收到:%ds前",
+			c.Proto, c.Addr, c.SessionId, state, p.PacketsIn, p.BytesIn, p.PacketsOut, p.BytesOut, p.Gaps, p.GapPercent(), p.Reorder, p.Resync,
+			t.PacketsIn, t.PacketsOut, t.Gaps, t.Reorder, (now-c.LastSeen)/1000))
+	}
+	return lines
+}
+
+// 上次汇报以来上行丢包的客户端
+func serverClientGapReport(clients []*serverClient) string {
+	var parts []string
+	for _, c := range clients {
+		if c.Pending.Gaps > 0 {
+			parts = append(parts, fmt.Sprintf("%s 缺口:%d(%.2f%%)", c.Addr, c.Pending.Gaps, c.Pending.GapPercent()))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
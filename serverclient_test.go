@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/davyxu/cellnet"
+	"github.com/davyxu/cellnet/codec"
+	"github.com/davyxu/cellnet/peer"
+	"github.com/davyxu/cellnet/proc"
+)
+
+func TestServerClientCheckId(t *testing.T) {
+	var cases = []struct {
+		name string
+		ids  []int64
+		want serverClientCounts
+		last int64
+	}{
+		{"in order", []int64{1, 2, 3, 4}, serverClientCounts{}, 4},
+		{"gap", []int64{1, 2, 5, 6}, serverClientCounts{Gaps: 2}, 6},
+		{"reorder", []int64{1, 3, 2, 4}, serverClientCounts{Gaps: 1, Reorder: 1}, 4},
+		{"duplicate", []int64{1, 2, 2, 3}, serverClientCounts{Reorder: 1}, 3},
+		{"wrap", []int64{probeIdModulo - 2, probeIdModulo - 1, 0, 2}, serverClientCounts{Gaps: 1}, 2},
+		{"reorder across wrap", []int64{probeIdModulo - 1, 1, 0}, serverClientCounts{Gaps: 1, Reorder: 1}, 1},
+		{"long outage", []int64{1, 100002}, serverClientCounts{Gaps: 100000}, 100002},
+		{"jump back far", []int64{serverClientReorderWindow + 10, 5, 6}, serverClientCounts{Resync: 1}, 6},
+		{"jump ahead too far", []int64{1, serverClientGapWindow + 2, serverClientGapWindow + 3}, serverClientCounts{Resync: 1}, serverClientGapWindow + 3},
+	}
+	for _, c := range cases {
+		var client serverClient
+		var got serverClientCounts
+		for _, id := range c.ids {
+			var counts serverClientCounts
+			client.checkId(id, &counts)
+			got.Add(counts)
+		}
+		if got != c.want || client.lastId != c.last {
+			t.Errorf("%s: got %+v last %d, want %+v last %d", c.name, got, client.lastId, c.want, c.last)
+		}
+	}
+}
+
+func TestServerClientReconnect(t *testing.T) {
+	var table = &serverClientTable{clients: make(map[string]*serverClient)}
+	var ack = func(id int32) *PtAck { return &PtAck{Id: id} }
+
+	table.Accepted("tcp", "1.2.3.4:5", 7, 1000)
+	table.Received("tcp", "1.2.3.4:5", 7, ack(1), 1000)
+	table.Received("tcp", "1.2.3.4:5", 7, ack(2), 1001)
+	table.Closed("tcp", "1.2.3.4:5", 7, 1002)
+	// 同一个会话重新连上，Id从头开始，不算缺口
+	table.Accepted("tcp", "1.2.3.4:5", 7, 2000)
+	table.Received("tcp", "1.2.3.4:5", 7, ack(1), 2001)
+
+	var clients = table.Snapshot(false)
+	if len(clients) != 1 {
+		t.Fatalf("clients = %d, want 1", len(clients))
+	}
+	var c = clients[0]
+	if !c.Connected || c.ConnectTime != 2000 || c.Totals.PacketsIn != 1 || c.Totals.Gaps != 0 || c.Totals.Resync != 0 {
+		t.Fatalf("reconnected client = %+v", c)
+	}
+
+	// udp很久没有包算断开，再来的包算新的连接
+	table.Received("udp", "1.2.3.4:6", 8, ack(5), 3000)
+	table.Expire(3000 + int64(serverClientIdle/time.Millisecond) + 1)
+	table.Received("udp", "1.2.3.4:6", 8, ack(1), 3000+int64(serverClientIdle/time.Millisecond)+2)
+	for _, c := range table.Snapshot(false) {
+		if c.Proto == "udp" && (c.Totals.PacketsIn != 1 || c.Totals.Reorder != 0) {
+			t.Fatalf("udp client after idle = %+v", c)
+		}
+	}
+}
+
+// 算出来的大小和编码器编出来的一样
+func TestPtAckSize(t *testing.T) {
+	for _, n := range []int{0, 1, 100} {
+		var msg = &PtAck{Id: 1, Time: 2, Stuffing: make([]int32, n), ServerRecvTime: 3, ServerSendTime: 4}
+		var data, _, err = codec.EncodeMessage(msg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := ptAckSize(msg), int64(ltvHeaderSize+len(data)); got != want {
+			t.Errorf("stuffing %d: size %d, want %d", n, got, want)
+		}
+	}
+}
+
+// 没有RemoteAddr的会话
+type noAddrSession struct {
+	id int64
+}
+
+func (self *noAddrSession) Raw() interface{}     { return self }
+func (self *noAddrSession) Peer() cellnet.Peer   { return nil }
+func (self *noAddrSession) Send(msg interface{}) {}
+func (self *noAddrSession) Close()               {}
+func (self *noAddrSession) ID() int64            { return self.id }
+
+func TestSessionRemoteAddrFallback(t *testing.T) {
+	var a, b = &noAddrSession{1}, &noAddrSession{2}
+	var addrA, addrB = sessionRemoteAddr(a), sessionRemoteAddr(b)
+	if addrA == "" || addrA == addrB || addrA != sessionRemoteAddr(a) {
+		t.Fatalf("fallback addresses %q %q should identify each session", addrA, addrB)
+	}
+}
+
+func udpLtvPacket(t *testing.T, msg interface{}) []byte {
+	var data, meta, err = codec.EncodeMessage(msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pkt = make([]byte, ltvHeaderSize+len(data))
+	binary.LittleEndian.PutUint16(pkt, uint16(len(pkt)))
+	binary.LittleEndian.PutUint16(pkt[2:], uint16(meta.ID))
+	copy(pkt[ltvHeaderSize:], data)
+	return pkt
+}
+
+// 两个udp客户端，服务器拿到各自的地址和会话，回复发回给对应的客户端
+func TestUdpAddrAcceptor(t *testing.T) {
+	var queue = cellnet.NewEventQueue()
+	var p = peer.NewGenericPeer(udpAcceptorType, "test.server", "127.0.0.1:0", queue)
+
+	type recv struct {
+		addr string
+		id   int64
+	}
+	var got = make(chan recv, 10)
+	proc.BindProcessorHandler(p, "udp.ltv", func(ev cellnet.Event) {
+		if msg, ok := ev.Message().(*PtAck); ok {
+			got <- recv{sessionRemoteAddr(ev.Session()), ev.Session().ID()}
+			ev.Session().Send(msg)
+		}
+	})
+	p.Start()
+	queue.StartLoop()
+	defer func() {
+		p.Stop()
+		queue.StopLoop()
+	}()
+
+	var server = p.(*udpAddrAcceptor).conn.LocalAddr().(*net.UDPAddr)
+	var conns []*net.UDPConn
+	for i := 0; i < 2; i++ {
+		var conn, err = net.DialUDP("udp", nil, server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	var sessions []int64
+	for i, conn := range conns {
+		for id := int32(1); id <= 2; id++ {
+			if _, err := conn.Write(udpLtvPacket(t, &PtAck{Id: id})); err != nil {
+				t.Fatal(err)
+			}
+			var r recv
+			select {
+			case r = <-got:
+			case <-time.After(2 * time.Second):
+				t.Fatalf("client %d probe %d not received", i, id)
+			}
+			if r.addr != conn.LocalAddr().String() {
+				t.Fatalf("client %d: server saw %s, want %s", i, r.addr, conn.LocalAddr())
+			}
+			if id == 1 {
+				sessions = append(sessions, r.id)
+			} else if r.id != sessions[i] {
+				t.Fatalf("client %d: session changed %d -> %d", i, sessions[i], r.id)
+			}
+
+			var buf = make([]byte, udpMaxRecvBuffer)
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			var n, err = conn.Read(buf)
+			if err != nil {
+				t.Fatalf("client %d: no reply: %v", i, err)
+			}
+			if want := udpLtvPacket(t, &PtAck{Id: id}); string(buf[:n]) != string(want) {
+				t.Fatalf("client %d: reply %x, want %x", i, buf[:n], want)
+			}
+		}
+	}
+	if sessions[0] == 0 || sessions[0] == sessions[1] {
+		t.Fatalf("sessions %v should be distinct and non-zero", sessions)
+	}
+}
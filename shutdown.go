@@ -0,0 +1,106 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 04:11
+ * Comment: 退出流程
+ *          收到SIGINT或者SIGTERM后：停止发包，等待已经发出去的包返回（最多一个探测超时），
+ *          关闭连接，把还没汇报的统计写到日志里，停止通知（没发出去的留在spool里），
+ *          写完存储、结果文件和日志后退出
+ *          超过-shutdown-timeout还没有完成，或者又收到一次信号时，不再等待直接退出
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"syscall"
+	"time"
+)
+
+// 进程的退出码
+const (
+	exitOk    = 0
+	exitError = 1
+	exitUsage = 2
+	// 退出超时，日志或者结果可能没有写完
+	exitShutdownTimeout = 3
+)
+
+// 日志文件，退出前要写完
+var logOutput *rollingLogWriter
+
+// main里出错时记录下来，写完日志后返回exitError
+func exitOnPanic() {
+	var err = recover()
+	if err == nil {
+		return
+	}
+	netLog.Errorln("exception:", err, string(debug.Stack()))
+	if logOutput != nil {
+		logOutput.Quit()
+	}
+	os.Exit(exitError)
+}
+
+// 按顺序退出，返回进程的退出码
+func shutdown(worker *probeWorkers, timeout time.Duration, signals <-chan os.Signal) int {
+	var done = make(chan bool, 1)
+	go func() {
+		// 出错时也要通知，CheckPanic先执行，然后发送结果
+		var ok bool
+		defer func() {
+			done <- ok
+		}()
+		defer CheckPanic(netLog)
+		shutdownSteps(worker, timeout)
+		ok = true
+	}()
+
+	select {
+	case ok := <-done:
+		if !ok {
+			fmt.Fprintln(os.Stderr, "退出时出错")
+			return exitError
+		}
+		fmt.Println("退出完成")
+		return exitOk
+	case <-time.After(timeout):
+		fmt.Fprintln(os.Stderr, "退出超时，不再等待:", timeout)
+		return exitShutdownTimeout
+	case sig := <-signals:
+		fmt.Fprintln(os.Stderr, "再次收到信号，强制退出:", sig)
+		if s, ok := sig.(syscall.Signal); ok {
+			return 128 + int(s)
+		}
+		return exitError
+	}
+}
+
+func shutdownSteps(worker *probeWorkers, timeout time.Duration) {
+	// 等待探测包最多用一半的时间，剩下的留给写文件
+	var pending, unknown = worker.Drain(timeout / 2)
+	if pending > 0 {
+		netLog.Warnln("退出时还有探测包没有返回:", pending)
+	}
+	if unknown > 0 {
+		netLog.Warnln("退出时不知道还有多少探测包没有返回的目标数:", unknown)
+	}
+	worker.Close()
+
+	logQualityReport("退出前未汇报的问题:")
+
+	stopNotifyQueue()
+
+	if resultStore != nil {
+		resultStore.Close()
+	}
+	if resultOutput != nil {
+		resultOutput.Close()
+	}
+
+	netLog.Infoln("退出")
+	if logOutput != nil {
+		logOutput.Quit()
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// PendingCount依次返回counts里的值，最后一个一直重复
+type drainClient struct {
+	counts []int
+	calls  int32
+	// 事件队列没有响应，PendingCount等这么久
+	hang time.Duration
+}
+
+func (self *drainClient) Close()                       {}
+func (self *drainClient) OpenClient(serverAddr string) {}
+func (self *drainClient) Update(target *TargetConfig)  {}
+func (self *drainClient) StopProbe()                   {}
+func (self *drainClient) PendingCount() int {
+	time.Sleep(self.hang)
+	var i = int(atomic.AddInt32(&self.calls, 1)) - 1
+	if i >= len(self.counts) {
+		i = len(self.counts) - 1
+	}
+	return self.counts[i]
+}
+
+func drainWorkers(clients map[string]*drainClient) *probeWorkers {
+	var w = newProbeWorkers()
+	for name, c := range clients {
+		w.clients[name] = c
+		w.targets[name] = TargetConfig{Name: name, ProbeTimeout: 1000}
+	}
+	return w
+}
+
+func TestProbeWorkersDrain(t *testing.T) {
+	var cases = []struct {
+		name    string
+		clients map[string]*drainClient
+		pending int
+		unknown int
+	}{
+		{"returned", map[string]*drainClient{"a": {counts: []int{3, 1, 0}}, "b": {counts: []int{0}}}, 0, 0},
+		{"still pending", map[string]*drainClient{"a": {counts: []int{2}}}, 2, 0},
+		// 没有响应不能算作0
+		{"unknown", map[string]*drainClient{"a": {counts: []int{0}}, "b": {counts: []int{-1}}}, 0, 1},
+	}
+	for _, c := range cases {
+		var pending, unknown = drainWorkers(c.clients).Drain(300 * time.Millisecond)
+		if pending != c.pending || unknown != c.unknown {
+			t.Errorf("%s: pending %d unknown %d, want %d %d", c.name, pending, unknown, c.pending, c.unknown)
+		}
+	}
+}
+
+// 没有响应的客户端同时等，不会超过limit太多
+func TestProbeWorkersDrainHung(t *testing.T) {
+	var clients = make(map[string]*drainClient)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		clients[name] = &drainClient{counts: []int{-1}, hang: 500 * time.Millisecond}
+	}
+	var start = time.Now()
+	var _, unknown = drainWorkers(clients).Drain(200 * time.Millisecond)
+	if d := time.Since(start); unknown != len(clients) || d > 1500*time.Millisecond {
+		t.Fatalf("unknown %d after %v, want %d within 1.5s", unknown, d, len(clients))
+	}
+}
+
+// 等待时不占用锁
+func TestProbeWorkersDrainUnlocked(t *testing.T) {
+	var w = drainWorkers(map[string]*drainClient{"a": {counts: []int{-1}}})
+	var done = make(chan bool)
+	go func() {
+		w.Drain(time.Second)
+		done <- true
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	var locked = make(chan bool)
+	go func() {
+		w.mutex.Lock()
+		w.mutex.Unlock()
+		locked <- true
+	}()
+	select {
+	case <-locked:
+	case <-done:
+		t.Fatal("drain returned before the lock was free")
+	}
+	<-done
+}
+
+func TestShutdownPanicIsError(t *testing.T) {
+	// 没有worker，第一步就出错
+	if code := shutdown(nil, time.Second, make(chan os.Signal)); code != exitError {
+		t.Fatalf("exit code %d, want %d", code, exitError)
+	}
+}
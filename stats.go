@@ -0,0 +1,572 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:24
+ * Comment: 统计子系统，每个探测目标一个收集器
+ *          cellnet的事件队列写入，汇报（邮件、日志、HTTP）通过Snapshot读取，全部在锁里完成
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 汇报时使用的几个滚动窗口
+var reportWindows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+func isReportWindow(d time.Duration) bool {
+	for _, w := range reportWindows {
+		if w == d {
+			return true
+		}
+	}
+	return false
+}
+
+func reportWindowNames() string {
+	var names []string
+	for _, w := range reportWindows {
+		names = append(names, windowName(w))
+	}
+	return strings.Join(names, ", ")
+}
+
+// 窗口的简短名字，例如1m，5m，1h
+func windowName(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	if d >= time.Minute && d%time.Minute == 0 {
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
+
+type targetStats struct {
+	Name string
+
+	mutex sync.Mutex
+
+	// 10秒一个槽，保留5分钟，用于短窗口
+	fine *rollingHistogram
+	// 1分钟一个槽，保留1小时，用于长窗口
+	coarse *rollingHistogram
+
+	// 收发包的计数，10秒一个槽，保留1小时
+	counts *rollingCounts
+	// 上次汇报以来的计数
+	pending probeCounts
+	// 从启动开始累计的计数和延迟分布
+	totals probeCounts
+	// 落在metricsRttBuckets每个区间(上一个le, le]里的包数，最后一个是超过所有le的
+	// 直方图的桶和le对不齐，所以单独精确计数
+	rttLe    []int64
+	rttSum   int64
+	rttCount int64
+
+	// 协议和地址
+	proto string
+	addr  string
+	// 当前是否连接着
+	connected   bool
+	connectTime int64
+	sessionId   int64
+	// 最近的连接和断开
+	events []connectionEvent
+
+	// 到达间隔抖动（毫秒）
+	jitter float64
+
+	// 单向延迟，1分钟一个槽，保留1小时
+	forward *rollingHistogram
+	reverse *rollingHistogram
+	process *rollingHistogram
+	offset  int64
+
+	// 按包大小（附带数据的字节数）统计的收发包数量，用来发现和包大小有关的丢包
+	sizeCounts map[int]*probeCounts
+
+	// 每小时的统计，保留两个周报周期，用于日报和周报的对比
+	// 按localMs记录，槽对齐到本地时间的整点
+	hourRtt    *rollingHistogram
+	hourCounts *rollingCounts
+	hourUptime *rollingUptime
+	// 当前这一分钟的计数，一分钟结束时判断这一分钟是否断网
+	minuteStart  int64
+	minuteCounts probeCounts
+}
+
+func newTargetStats(name string) *targetStats {
+	return &targetStats{
+		Name:   name,
+		fine:   newRollingHistogram(10*time.Second, 5*time.Minute),
+		coarse: newRollingHistogram(time.Minute, time.Hour),
+		counts: newRollingCounts(10*time.Second, time.Hour),
+
+		rttLe: make([]int64, len(metricsRttBuckets)+1),
+
+		forward: newRollingHistogram(time.Minute, time.Hour),
+		reverse: newRollingHistogram(time.Minute, time.Hour),
+		process: newRollingHistogram(time.Minute, time.Hour),
+
+		sizeCounts: make(map[int]*probeCounts),
+
+		hourRtt:    newRollingHistogram(time.Hour, digestKeep),
+		hourCounts: newRollingCounts(time.Hour, digestKeep),
+		hourUptime: newRollingUptime(time.Hour, digestKeep),
+	}
+}
+
+// 记录一次往返延迟（毫秒），size是附带数据的字节数
+func (self *targetStats) RecordRtt(rtt int64, size int) {
+	var now = TimeNowMs()
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.fine.Record(now, rtt)
+	self.coarse.Record(now, rtt)
+	self.rttLe[sort.Search(len(metricsRttBuckets), func(i int) bool { return metricsRttBuckets[i] >= rtt })]++
+	self.rttSum += rtt
+	self.rttCount++
+	self.hourRtt.Record(localMs(now), rtt)
+	self.addCounts(now, probeCounts{Received: 1})
+	self.sizeCountsOf(size).Received++
+}
+
+func (self *targetStats) RecordSent() {
+	var now = TimeNowMs()
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.addCounts(now, probeCounts{Sent: 1})
+}
+
+func (self *targetStats) RecordLost(size int) {
+	var now = TimeNowMs()
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.addCounts(now, probeCounts{Lost: 1})
+	self.sizeCountsOf(size).Lost++
+}
+
+// 记录其他的事件（超时、重复、乱序等）
+func (self *targetStats) AddCounts(c probeCounts) {
+	var now = TimeNowMs()
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.addCounts(now, c)
+}
+
+func (self *targetStats) addCounts(now int64, c probeCounts) {
+	self.counts.Add(now, c)
+	self.hourCounts.Add(localMs(now), c)
+	self.pending.Add(c)
+	self.totals.Add(c)
+
+	var minute = now - now%int64(time.Minute/time.Millisecond)
+	if minute != self.minuteStart {
+		if self.minuteStart > 0 {
+			self.hourUptime.Add(localMs(self.minuteStart), isOutageMinute(self.minuteCounts))
+		}
+		self.minuteStart = minute
+		self.minuteCounts = probeCounts{}
+	}
+	self.minuteCounts.Add(c)
+}
+
+func (self *targetStats) SetInfo(proto, addr string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.proto = proto
+	self.addr = addr
+}
+
+// 保留最近多少次连接和断开
+const connectionEventSize = 20
+
+// 一次连接或者断开
+type connectionEvent struct {
+	Time      int64
+	Type      string // connect, reconnect, disconnect
+	SessionId int64
+	// 断开时，这次连接持续了多久（毫秒）
+	Duration int64
+}
+
+// 连接建立或者断开，sessionId是cellnet的会话Id
+func (self *targetStats) SetConnected(connected bool, sessionId int64) {
+	var now = TimeNowMs()
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var ev = connectionEvent{Time: now, SessionId: sessionId}
+	if self.connected && !connected {
+		self.addCounts(now, probeCounts{Disconnect: 1})
+		ev.Type = resultTypeDisconnect
+		ev.SessionId = self.sessionId
+		ev.Duration = now - self.connectTime
+	} else if !self.connected && connected {
+		ev.Type = resultTypeConnect
+		if self.connectTime > 0 {
+			ev.Type = resultTypeReconnect
+		}
+	}
+	if len(ev.Type) > 0 {
+		self.events = append(self.events, ev)
+		if len(self.events) > connectionEventSize {
+			self.events = self.events[len(self.events)-connectionEventSize:]
+		}
+	}
+
+	self.connected = connected
+	if connected {
+		self.connectTime = now
+		self.sessionId = sessionId
+	}
+}
+
+func (self *targetStats) sizeCountsOf(size int) *probeCounts {
+	var c, ok = self.sizeCounts[size]
+	if !ok {
+		c = &probeCounts{}
+		self.sizeCounts[size] = c
+	}
+	return c
+}
+
+func (self *targetStats) SetJitter(jitter float64) {
+	self.mutex.Lock()
+	self.jitter = jitter
+	self.mutex.Unlock()
+}
+
+func (self *targetStats) RecordOneWay(d oneWayDelay) {
+	var now = TimeNowMs()
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.forward.Record(now, d.Forward)
+	self.reverse.Record(now, d.Reverse)
+	self.process.Record(now, d.Process)
+	self.offset = d.Offset
+}
+
+// 某个窗口内的统计
+type windowSnapshot struct {
+	Window  time.Duration
+	Counts  probeCounts
+	Latency latencySummary
+
+	// 单向延迟和服务器处理时间
+	Forward latencySummary
+	Reverse latencySummary
+	Process latencySummary
+}
+
+// 一分钟的统计，用来画最近一小时的曲线
+type minuteSnapshot struct {
+	Time    int64
+	Counts  probeCounts
+	Latency latencySummary
+}
+
+// 某个时刻的完整统计，所有的汇报（邮件、日志、HTTP）都从这里读取
+type statsSnapshot struct {
+	Name  string
+	Proto string
+	Addr  string
+	Time  int64
+
+	Connected   bool
+	ConnectTime int64
+	SessionId   int64
+	// 最近的连接和断开，从早到晚
+	Events []connectionEvent
+
+	// 上次汇报以来的计数
+	Pending probeCounts
+	// 从启动开始累计的计数
+	Totals probeCounts
+
+	Jitter      float64
+	ClockOffset int64
+
+	// 和reportWindows一一对应
+	Windows []windowSnapshot
+
+	// 最近一小时每分钟的统计，从早到晚
+	Minutes []minuteSnapshot
+
+	// 按包大小（字节）统计的收发包数量，从启动开始累计
+	SizeCounts map[int]probeCounts
+
+	// 从启动开始累计的延迟分布，和metricsRttBuckets一一对应
+	RttBuckets []int64
+	RttSum     int64
+	RttCount   int64
+}
+
+// 某个窗口的统计，没有这个窗口时返回空的
+func (self *statsSnapshot) Window(window time.Duration) windowSnapshot {
+	for _, w := range self.Windows {
+		if w.Window == window {
+			return w
+		}
+	}
+	return windowSnapshot{Window: window}
+}
+
+// 最近一小时每分钟的p50延迟，没有数据的分钟是-1
+func (self *statsSnapshot) RttSeries() []float64 {
+	var ret []float64
+	for _, m := range self.Minutes {
+		if m.Latency.Count == 0 {
+			ret = append(ret, -1)
+		} else {
+			ret = append(ret, float64(m.Latency.P50))
+		}
+	}
+	return ret
+}
+
+// 最近一小时每分钟的丢包率，没有数据的分钟是-1
+func (self *statsSnapshot) LossSeries() []float64 {
+	var ret []float64
+	for _, m := range self.Minutes {
+		if m.Counts.Received+m.Counts.Lost == 0 {
+			ret = append(ret, -1)
+		} else {
+			ret = append(ret, m.Counts.LossPercent())
+		}
+	}
+	return ret
+}
+
+// 取当前的统计，reset为true时，同时把上次汇报以来的计数清零（在同一个锁里完成，不会丢失计数）
+func (self *targetStats) Snapshot(reset bool) *statsSnapshot {
+	var now = TimeNowMs()
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var ret = &statsSnapshot{
+		Name:        self.Name,
+		Proto:       self.proto,
+		Addr:        self.addr,
+		Time:        now,
+		Connected:   self.connected,
+		ConnectTime: self.connectTime,
+		SessionId:   self.sessionId,
+		Events:      append([]connectionEvent(nil), self.events...),
+		Pending:     self.pending,
+		Totals:      self.totals,
+		Jitter:      self.jitter,
+		ClockOffset: self.offset,
+		SizeCounts:  make(map[int]probeCounts, len(self.sizeCounts)),
+		RttSum:      self.rttSum,
+		RttCount:    self.rttCount,
+	}
+
+	for _, w := range reportWindows {
+		var r = self.coarse
+		if w <= self.fine.Span() {
+			r = self.fine
+		}
+		ret.Windows = append(ret.Windows, windowSnapshot{
+			Window:  w,
+			Counts:  self.counts.Window(now, w),
+			Latency: r.Window(now, w).Summary(),
+			Forward: self.forward.Window(now, w).Summary(),
+			Reverse: self.reverse.Window(now, w).Summary(),
+			Process: self.process.Window(now, w).Summary(),
+		})
+	}
+
+	var slotMs = self.coarse.SlotMs()
+	var current = now - now%slotMs
+	for start := current - 59*slotMs; start <= current; start += slotMs {
+		var m = minuteSnapshot{Time: start, Counts: self.counts.Range(start, start+slotMs)}
+		if h := self.coarse.SlotAt(start); h != nil {
+			m.Latency = h.Summary()
+		}
+		ret.Minutes = append(ret.Minutes, m)
+	}
+
+	for k, v := range self.sizeCounts {
+		ret.SizeCounts[k] = *v
+	}
+
+	// 累计成小于等于le的包数
+	ret.RttBuckets = make([]int64, len(metricsRttBuckets))
+	var below int64
+	for i := range metricsRttBuckets {
+		below += self.rttLe[i]
+		ret.RttBuckets[i] = below
+	}
+
+	if reset {
+		self.pending = probeCounts{}
+	}
+	return ret
+}
+
+var allTargetStats = make(map[string]*targetStats)
+var allTargetStatsMutex sync.Mutex
+
+func getTargetStats(name string) *targetStats {
+	allTargetStatsMutex.Lock()
+	defer allTargetStatsMutex.Unlock()
+
+	var s, ok = allTargetStats[name]
+	if !ok {
+		s = newTargetStats(name)
+		allTargetStats[name] = s
+	}
+	return s
+}
+
+// 目标删除了，不再汇报
+func removeTargetStats(name string) {
+	allTargetStatsMutex.Lock()
+	defer allTargetStatsMutex.Unlock()
+
+	delete(allTargetStats, name)
+}
+
+// 按窗口统计的收发包数量
+type probeCounts struct {
+	Sent     int64
+	Received int64
+	Lost     int64
+
+	Late      int64 // 超时返回，包括已经算成丢包以后才返回的
+	Duplicate int64 // 重复
+	Reorder   int64 // 乱序
+	Corrupt   int64 // 内容错误
+	Unsent    int64 // 网络断开，没有发出去
+	Silence   int64 // 长时间没有收到包（每秒计一次）
+
+	Disconnect int64 // 连接断开的次数
+}
+
+func (self *probeCounts) Add(other probeCounts) {
+	self.Sent += other.Sent
+	self.Received += other.Received
+	self.Lost += other.Lost
+	self.Late += other.Late
+	self.Duplicate += other.Duplicate
+	self.Reorder += other.Reorder
+	self.Corrupt += other.Corrupt
+	self.Unsent += other.Unsent
+	self.Silence += other.Silence
+	self.Disconnect += other.Disconnect
+}
+
+// 有没有需要汇报的问题
+func (self probeCounts) HasProblem() bool {
+	return self.Lost > 0 || self.Late > 0 || self.Duplicate > 0 || self.Reorder > 0 ||
+		self.Corrupt > 0 || self.Unsent > 0 || self.Silence > 0 || self.Disconnect > 0
+}
+
+// 已经有结果（收到或者丢失）的包数，丢包率的分母
+func (self probeCounts) Settled() int64 {
+	return self.Received + self.Lost
+}
+
+// 丢包率（百分比），还没有结果的包不算
+func (self probeCounts) LossPercent() float64 {
+	var total = self.Settled()
+	if total == 0 {
+		return 0
+	}
+	return float64(self.Lost) * 100 / float64(total)
+}
+
+type rollingCounts struct {
+	slotMs    int64
+	slots     []probeCounts
+	slotStart []int64
+}
+
+func newRollingCounts(slot time.Duration, keep time.Duration) *rollingCounts {
+	var n = int(keep / slot)
+	if n < 1 {
+		n = 1
+	}
+	return &rollingCounts{
+		slotMs:    int64(slot / time.Millisecond),
+		slots:     make([]probeCounts, n),
+		slotStart: make([]int64, n),
+	}
+}
+
+func (self *rollingCounts) Add(now int64, c probeCounts) {
+	var start = now - now%self.slotMs
+	var idx = int(start/self.slotMs) % len(self.slots)
+	if self.slotStart[idx] != start {
+		self.slotStart[idx] = start
+		self.slots[idx] = probeCounts{}
+	}
+	self.slots[idx].Add(c)
+}
+
+// [from, to)之间开始的槽的总和
+func (self *rollingCounts) Range(from, to int64) probeCounts {
+	var ret probeCounts
+	for i := range self.slots {
+		var start = self.slotStart[i]
+		if start >= from && start < to {
+			ret.Add(self.slots[i])
+		}
+	}
+	return ret
+}
+
+func (self *rollingCounts) Window(now int64, window time.Duration) probeCounts {
+	var ret probeCounts
+	var from = now - int64(window/time.Millisecond)
+	for i := range self.slots {
+		var start = self.slotStart[i]
+		if start+self.slotMs > from && start <= now {
+			ret.Add(self.slots[i])
+		}
+	}
+	return ret
+}
+
+// 所有目标的统计，按名字排序
+func listTargetStats() []*targetStats {
+	allTargetStatsMutex.Lock()
+	defer allTargetStatsMutex.Unlock()
+
+	var ret = make([]*targetStats, 0, len(allTargetStats))
+	for _, v := range allTargetStats {
+		ret = append(ret, v)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// 所有目标的统计快照，按名字排序
+func snapshotAll(reset bool) []*statsSnapshot {
+	var ret []*statsSnapshot
+	for _, s := range listTargetStats() {
+		ret = append(ret, s.Snapshot(reset))
+	}
+	return ret
+}
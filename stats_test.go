@@ -0,0 +1,24 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Prometheus的le是小于等于，刚好超过le的值不能算进去
+func TestRttBucketsExact(t *testing.T) {
+	var s = newTargetStats("a")
+	for _, rtt := range []int64{0, 1, 2, 3, 100, 101, 500, 503, 10000, 10001, 60000} {
+		s.RecordRtt(rtt, 0)
+	}
+	var snap = s.Snapshot(false)
+
+	// le: 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000
+	var want = []int64{2, 3, 4, 4, 4, 4, 5, 6, 7, 8, 8, 8, 9}
+	if !reflect.DeepEqual(snap.RttBuckets, want) {
+		t.Fatalf("buckets = %v, want %v", snap.RttBuckets, want)
+	}
+	if snap.RttCount != 11 || snap.RttSum != 0+1+2+3+100+101+500+503+10000+10001+60000 {
+		t.Fatalf("count %d sum %d", snap.RttCount, snap.RttSum)
+	}
+}
@@ -0,0 +1,819 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:43
+ * Comment: 探测结果的本地存储，只追加的二进制分段文件
+ *          raw 每个探测包一条记录，一小时一个文件
+ *          1m  每个目标每分钟一条汇总，一天一个文件
+ *          1h  每个目标每小时一条汇总，一个月一个文件
+ *          每一级单独设置保留多久，过期的文件整个删除
+ *          文件名使用UTC时间，例如 data/raw/20261019-01.seg
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	storeTierRaw    = "raw"
+	storeTierMinute = "1m"
+	storeTierHour   = "1h"
+
+	storeSegmentExt = ".seg"
+
+	// 汇总的时间段结束后，再等多久写入文件，等待超时返回的包
+	storeRollupGrace = time.Minute
+
+	storeRecordProbe  = 1
+	storeRecordRollup = 2
+)
+
+// 每个分段文件的开头
+var storeMagic = []byte("NPTS\x01")
+
+var errStoreCorrupt = errors.New("存储文件损坏")
+
+// 一个探测包的结果
+type probeRecord struct {
+	Target   string
+	Proto    string
+	Seq      int32
+	SendTime int64
+	RecvTime int64 // 丢包时是0
+	Rtt      int64
+	Size     int // 附带数据的字节数
+	Result   EProbeResult
+}
+
+// 一段时间内的汇总
+type rollupRecord struct {
+	Time    int64
+	Span    int64
+	Target  string
+	Proto   string
+	Counts  probeCounts
+	RttSum  int64
+	Latency latencySummary
+}
+
+// 存储的一级
+type storeTier struct {
+	Name string
+	// 汇总的时间长度，raw是0
+	Span time.Duration
+	// 文件名的时间格式
+	Layout string
+	Keep   time.Duration
+}
+
+// t所在的分段文件的开始时间
+func (self *storeTier) segmentStart(t time.Time) time.Time {
+	t = t.UTC()
+	switch self.Name {
+	case storeTierRaw:
+		return t.Truncate(time.Hour)
+	case storeTierMinute:
+		return t.Truncate(24 * time.Hour)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (self *storeTier) segmentEnd(start time.Time) time.Time {
+	switch self.Name {
+	case storeTierRaw:
+		return start.Add(time.Hour)
+	case storeTierMinute:
+		return start.Add(24 * time.Hour)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+func newStoreTiers(rawDays, minuteDays, hourDays int) []*storeTier {
+	return []*storeTier{
+		{Name: storeTierRaw, Layout: "20060102-15", Keep: time.Duration(rawDays) * 24 * time.Hour},
+		{Name: storeTierMinute, Span: time.Minute, Layout: "20060102", Keep: time.Duration(minuteDays) * 24 * time.Hour},
+		{Name: storeTierHour, Span: time.Hour, Layout: "200601", Keep: time.Duration(hourDays) * 24 * time.Hour},
+	}
+}
+
+// 默认的保留时间
+var defaultStoreTiers = newStoreTiers(7, 30, 365)
+
+// 按配置的保留天数，没有配置的使用默认值
+func configStoreTiers(cfg *GlobalConfig) []*storeTier {
+	var tiers = newStoreTiers(cfg.StoreRawDays, cfg.StoreMinuteDays, cfg.StoreHourDays)
+	for i, t := range tiers {
+		if t.Keep <= 0 {
+			t.Keep = defaultStoreTiers[i].Keep
+		}
+	}
+	return tiers
+}
+
+func findStoreTier(tiers []*storeTier, name string) *storeTier {
+	for _, v := range tiers {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// 编码
+type storeBuffer struct {
+	b []byte
+}
+
+func (self *storeBuffer) Varint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	var n = binary.PutVarint(tmp[:], v)
+	self.b = append(self.b, tmp[:n]...)
+}
+
+func (self *storeBuffer) String(s string) {
+	self.Varint(int64(len(s)))
+	self.b = append(self.b, s...)
+}
+
+// 解码，出错后后面的读取都返回0
+type storeDecoder struct {
+	b   []byte
+	err error
+}
+
+func (self *storeDecoder) Varint() int64 {
+	if self.err != nil {
+		return 0
+	}
+	var v, n = binary.Varint(self.b)
+	if n <= 0 {
+		self.err = errStoreCorrupt
+		return 0
+	}
+	self.b = self.b[n:]
+	return v
+}
+
+func (self *storeDecoder) String() string {
+	var n = self.Varint()
+	if self.err != nil {
+		return ""
+	}
+	if n < 0 || n > int64(len(self.b)) {
+		self.err = errStoreCorrupt
+		return ""
+	}
+	var s = string(self.b[:n])
+	self.b = self.b[n:]
+	return s
+}
+
+func (self *probeRecord) encode() []byte {
+	var buf storeBuffer
+	buf.Varint(storeRecordProbe)
+	buf.String(self.Target)
+	buf.String(self.Proto)
+	buf.Varint(int64(self.Seq))
+	buf.Varint(self.SendTime)
+	buf.Varint(self.RecvTime)
+	buf.Varint(self.Rtt)
+	buf.Varint(int64(self.Size))
+	buf.Varint(int64(self.Result))
+	return buf.b
+}
+
+func decodeProbeRecord(d *storeDecoder) *probeRecord {
+	var ret = &probeRecord{}
+	ret.Target = d.String()
+	ret.Proto = d.String()
+	ret.Seq = int32(d.Varint())
+	ret.SendTime = d.Varint()
+	ret.RecvTime = d.Varint()
+	ret.Rtt = d.Varint()
+	ret.Size = int(d.Varint())
+	ret.Result = EProbeResult(d.Varint())
+	return ret
+}
+
+func (self *rollupRecord) encode() []byte {
+	var buf storeBuffer
+	buf.Varint(storeRecordRollup)
+	buf.String(self.Target)
+	buf.String(self.Proto)
+	buf.Varint(self.Time)
+	buf.Varint(self.Span)
+	for _, v := range []int64{self.Counts.Received, self.Counts.Lost, self.Counts.Late,
+		self.Counts.Duplicate, self.Counts.Reorder, self.Counts.Corrupt} {
+		buf.Varint(v)
+	}
+	buf.Varint(self.RttSum)
+	for _, v := range []int64{self.Latency.Count, self.Latency.Min, self.Latency.Max,
+		self.Latency.P50, self.Latency.P90, self.Latency.P99, self.Latency.P999} {
+		buf.Varint(v)
+	}
+	buf.Varint(self.Counts.Unsent)
+	return buf.b
+}
+
+func decodeRollupRecord(d *storeDecoder) *rollupRecord {
+	var ret = &rollupRecord{}
+	ret.Target = d.String()
+	ret.Proto = d.String()
+	ret.Time = d.Varint()
+	ret.Span = d.Varint()
+	for _, v := range []*int64{&ret.Counts.Received, &ret.Counts.Lost, &ret.Counts.Late,
+		&ret.Counts.Duplicate, &ret.Counts.Reorder, &ret.Counts.Corrupt} {
+		*v = d.Varint()
+	}
+	ret.RttSum = d.Varint()
+	for _, v := range []*int64{&ret.Latency.Count, &ret.Latency.Min, &ret.Latency.Max,
+		&ret.Latency.P50, &ret.Latency.P90, &ret.Latency.P99, &ret.Latency.P999} {
+		*v = d.Varint()
+	}
+	// 后来加的字段，旧的记录里没有
+	if d.err == nil && len(d.b) > 0 {
+		ret.Counts.Unsent = d.Varint()
+	}
+	if ret.Latency.Count > 0 {
+		ret.Latency.Avg = float64(ret.RttSum) / float64(ret.Latency.Count)
+	}
+	return ret
+}
+
+// 一级存储当前写入的分段文件
+type storeWriter struct {
+	tier  *storeTier
+	dir   string
+	start time.Time
+	file  *os.File
+	buf   *bufio.Writer
+}
+
+// 每条记录：4字节长度 + 内容 + 4字节crc32，写了一半的记录在读取时会被发现
+func (self *storeWriter) Write(now time.Time, payload []byte) error {
+	if err := self.open(now); err != nil {
+		return err
+	}
+
+	var head [4]byte
+	binary.LittleEndian.PutUint32(head[:], uint32(len(payload)))
+	self.buf.Write(head[:])
+	self.buf.Write(payload)
+	binary.LittleEndian.PutUint32(head[:], crc32.ChecksumIEEE(payload))
+	_, err := self.buf.Write(head[:])
+	return err
+}
+
+func (self *storeWriter) open(now time.Time) error {
+	var start = self.tier.segmentStart(now)
+	if self.file != nil && start.Equal(self.start) {
+		return nil
+	}
+	self.Close()
+
+	var folder = filepath.Join(self.dir, self.tier.Name)
+	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+		return err
+	}
+	var name = filepath.Join(folder, start.Format(self.tier.Layout)+storeSegmentExt)
+	var f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	// 上次写了一半的记录留在文件末尾，读取时会停在那里，所以重启后写到新的文件里
+	if info, err := f.Stat(); err == nil && info.Size() > 0 && !storeSegmentIntact(name) {
+		f.Close()
+		name = filepath.Join(folder, start.Format(self.tier.Layout)+"."+time.Now().Format("150405")+storeSegmentExt)
+		if f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666); err != nil {
+			return err
+		}
+	}
+
+	self.file = f
+	self.start = start
+	self.buf = bufio.NewWriterSize(f, 64*1024)
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		self.buf.Write(storeMagic)
+	}
+	return nil
+}
+
+func (self *storeWriter) Flush() {
+	if self.buf != nil {
+		self.buf.Flush()
+	}
+}
+
+func (self *storeWriter) Close() {
+	if self.file == nil {
+		return
+	}
+	self.buf.Flush()
+	self.file.Close()
+	self.file = nil
+	self.buf = nil
+}
+
+// 正在汇总的一段时间
+type storeBucket struct {
+	Time   int64
+	Target string
+	Proto  string
+	Counts probeCounts
+	Rtt    *histogram
+}
+
+func (self *storeBucket) Add(rec *probeRecord) {
+	switch rec.Result {
+	case EProbeOnTime:
+		self.Counts.Received++
+	case EProbeLate:
+		self.Counts.Received++
+		self.Counts.Late++
+	case EProbeReorder:
+		self.Counts.Received++
+		self.Counts.Reorder++
+	case EProbeDuplicate:
+		self.Counts.Duplicate++
+		return
+	case EProbeLost:
+		self.Counts.Lost++
+		return
+	case EProbeLostLate:
+		// 丢包已经记过了
+		self.Counts.Late++
+		return
+	case EProbeUnsent:
+		self.Counts.Unsent++
+		return
+	default:
+		self.Counts.Corrupt++
+		return
+	}
+	self.Rtt.Record(rec.Rtt)
+}
+
+func (self *storeBucket) Rollup(span time.Duration) *rollupRecord {
+	return &rollupRecord{
+		Time:    self.Time,
+		Span:    int64(span / time.Millisecond),
+		Target:  self.Target,
+		Proto:   self.Proto,
+		Counts:  self.Counts,
+		RttSum:  self.Rtt.sum,
+		Latency: self.Rtt.Summary(),
+	}
+}
+
+// 合并同一个时间段的另一条汇总（重启前后各写了一部分）
+// 分位数没法精确合并，按包数加权平均
+func (self *rollupRecord) Merge(other *rollupRecord) {
+	self.Counts.Add(other.Counts)
+	self.RttSum += other.RttSum
+
+	var a, b = self.Latency, other.Latency
+	var n = a.Count + b.Count
+	if b.Count == 0 {
+		return
+	}
+	if a.Count == 0 {
+		self.Latency = b
+		self.Latency.Avg = float64(self.RttSum) / float64(n)
+		return
+	}
+	var weighted = func(x, y int64) int64 {
+		return (x*a.Count + y*b.Count) / n
+	}
+	self.Latency = latencySummary{
+		Count: n,
+		Min:   a.Min,
+		Max:   a.Max,
+		Avg:   float64(self.RttSum) / float64(n),
+		P50:   weighted(a.P50, b.P50),
+		P90:   weighted(a.P90, b.P90),
+		P99:   weighted(a.P99, b.P99),
+		P999:  weighted(a.P999, b.P999),
+	}
+	if b.Min < a.Min {
+		self.Latency.Min = b.Min
+	}
+	if b.Max > a.Max {
+		self.Latency.Max = b.Max
+	}
+}
+
+type probeStore struct {
+	dir   string
+	tiers []*storeTier
+
+	channel chan *probeRecord
+	rollups chan *rollupRecord
+	quit    chan chan bool
+
+	writers map[string]*storeWriter
+	// 每一级汇总正在统计的时间段，key是目标名字和开始时间
+	buckets map[string]map[string]*storeBucket
+	// 直接写入的汇总（汇总服务器收到的每分钟统计）合并成更长的时间段，key和buckets一样
+	merged map[string]map[string]*rollupRecord
+	// 每一级汇总每个目标最后写入文件的时间段的开始时间，
+	// 这之前的时间段再收到记录时不再汇总，否则会写入第二条汇总
+	flushed map[string]map[string]int64
+	// 时间段写入以后才到、只写入raw的记录数
+	late int64
+
+	// 写不过来丢掉的记录数
+	dropped int64
+}
+
+func newProbeStore(dir string, tiers []*storeTier) *probeStore {
+	var ret = &probeStore{
+		dir:     dir,
+		tiers:   tiers,
+		channel: make(chan *probeRecord, 4096),
+		rollups: make(chan *rollupRecord, 1024),
+		quit:    make(chan chan bool),
+		writers: make(map[string]*storeWriter),
+		buckets: make(map[string]map[string]*storeBucket),
+		merged:  make(map[string]map[string]*rollupRecord),
+		flushed: make(map[string]map[string]int64),
+	}
+	for _, t := range tiers {
+		ret.writers[t.Name] = &storeWriter{tier: t, dir: dir}
+		if t.Span > 0 {
+			ret.buckets[t.Name] = make(map[string]*storeBucket)
+			ret.merged[t.Name] = make(map[string]*rollupRecord)
+			ret.flushed[t.Name] = make(map[string]int64)
+		}
+	}
+	return ret
+}
+
+// 打开存储，开始写入的协程
+func openProbeStore(dir string, tiers []*storeTier) *probeStore {
+	var ret = newProbeStore(dir, tiers)
+	netLog.Infoln("open result store:", dir)
+	ret.expire(time.Now())
+	go ret.run()
+	return ret
+}
+
+// 记录一个探测包的结果，不会阻塞探测，写不过来时丢弃
+func (self *probeStore) Append(rec *probeRecord) {
+	select {
+	case self.channel <- rec:
+	default:
+		if atomic.AddInt64(&self.dropped, 1)%1000 == 1 {
+			netLog.Warnln("存储写不过来，丢弃记录:", atomic.LoadInt64(&self.dropped))
+		}
+	}
+}
+
+// 直接写入一条汇总（汇总服务器收到的各个节点的每分钟统计）
+func (self *probeStore) AppendRollup(rec *rollupRecord) {
+	select {
+	case self.rollups <- rec:
+	default:
+		if atomic.AddInt64(&self.dropped, 1)%1000 == 1 {
+			netLog.Warnln("存储写不过来，丢弃记录:", atomic.LoadInt64(&self.dropped))
+		}
+	}
+}
+
+// 写完缓存里的记录，把所有的汇总写入文件，然后关闭
+func (self *probeStore) Close() {
+	var done = make(chan bool)
+	self.quit <- done
+	<-done
+}
+
+func (self *probeStore) run() {
+	defer CheckPanic(netLog)
+
+	var flush = time.NewTicker(time.Second)
+	defer flush.Stop()
+	var expire = time.NewTicker(time.Hour)
+	defer expire.Stop()
+
+	for {
+		select {
+		case rec := <-self.channel:
+			self.write(rec)
+		case rec := <-self.rollups:
+			self.writeRollup(rec)
+		case now := <-flush.C:
+			self.flushBuckets(now, false)
+			for _, w := range self.writers {
+				w.Flush()
+			}
+		case now := <-expire.C:
+			self.expire(now)
+		case done := <-self.quit:
+			for len(self.channel) > 0 {
+				self.write(<-self.channel)
+			}
+			for len(self.rollups) > 0 {
+				self.writeRollup(<-self.rollups)
+			}
+			self.flushBuckets(time.Now(), true)
+			for _, w := range self.writers {
+				w.Close()
+			}
+			done <- true
+			return
+		}
+	}
+}
+
+func (self *probeStore) write(rec *probeRecord) {
+	var now = time.Now()
+	if w := self.writers[storeTierRaw]; w != nil {
+		if err := w.Write(now, rec.encode()); err != nil {
+			netLog.Warnln("写入存储失败:", err.Error())
+		}
+	}
+
+	for _, t := range self.tiers {
+		if t.Span <= 0 {
+			continue
+		}
+		var span = int64(t.Span / time.Millisecond)
+		var start = rec.SendTime - rec.SendTime%span
+		if self.isFlushed(t, rec.Target, start) {
+			continue
+		}
+		var key = fmt.Sprintf("%s\x00%d", rec.Target, start)
+		var b, ok = self.buckets[t.Name][key]
+		if !ok {
+			b = &storeBucket{Time: start, Target: rec.Target, Proto: rec.Proto, Rtt: newHistogram()}
+			self.buckets[t.Name][key] = b
+		}
+		b.Add(rec)
+	}
+}
+
+// 这个时间段的汇总是不是已经写入了，写入以后再来的记录不再汇总，否则会写入第二条汇总
+func (self *probeStore) isFlushed(t *storeTier, target string, start int64) bool {
+	if last, ok := self.flushed[t.Name][target]; !ok || start > last {
+		return false
+	}
+	self.late++
+	if self.late%1000 == 1 {
+		netLog.Warnln("汇总已经写入，不再汇总这个时间段:", t.Name, target, self.late)
+	}
+	return true
+}
+
+// 一样长的直接写入，更长的合并以后由flushBuckets写入
+func (self *probeStore) writeRollup(rec *rollupRecord) {
+	var span = time.Duration(rec.Span) * time.Millisecond
+	for _, t := range self.tiers {
+		if span <= 0 || t.Span < span || t.Span%span != 0 {
+			continue
+		}
+		if t.Span == span {
+			if err := self.writers[t.Name].Write(time.Now(), rec.encode()); err != nil {
+				netLog.Warnln("写入存储失败:", err.Error())
+			}
+			continue
+		}
+
+		var tierMs = int64(t.Span / time.Millisecond)
+		var start = rec.Time - rec.Time%tierMs
+		if self.isFlushed(t, rec.Target, start) {
+			continue
+		}
+		var key = fmt.Sprintf("%s\x00%d", rec.Target, start)
+		if v, ok := self.merged[t.Name][key]; ok {
+			v.Merge(rec)
+			continue
+		}
+		var v = *rec
+		v.Time = start
+		v.Span = tierMs
+		self.merged[t.Name][key] = &v
+	}
+}
+
+// 把已经结束的时间段写入文件，all为true时全部写入
+func (self *probeStore) flushBuckets(now time.Time, all bool) {
+	var nowMs = now.UnixNano() / int64(time.Millisecond)
+	var grace = int64(storeRollupGrace / time.Millisecond)
+
+	for _, t := range self.tiers {
+		if t.Span <= 0 {
+			continue
+		}
+		var span = int64(t.Span / time.Millisecond)
+		for key, b := range self.buckets[t.Name] {
+			if !all && b.Time+span+grace > nowMs {
+				continue
+			}
+			delete(self.buckets[t.Name], key)
+			if last, ok := self.flushed[t.Name][b.Target]; !ok || b.Time > last {
+				self.flushed[t.Name][b.Target] = b.Time
+			}
+			if err := self.writers[t.Name].Write(now, b.Rollup(t.Span).encode()); err != nil {
+				netLog.Warnln("写入存储失败:", err.Error())
+			}
+		}
+		for key, v := range self.merged[t.Name] {
+			if !all && v.Time+span+grace > nowMs {
+				continue
+			}
+			delete(self.merged[t.Name], key)
+			if last, ok := self.flushed[t.Name][v.Target]; !ok || v.Time > last {
+				self.flushed[t.Name][v.Target] = v.Time
+			}
+			if err := self.writers[t.Name].Write(now, v.encode()); err != nil {
+				netLog.Warnln("写入存储失败:", err.Error())
+			}
+		}
+	}
+}
+
+// 删除过期的分段文件
+func (self *probeStore) expire(now time.Time) {
+	for _, t := range self.tiers {
+		if t.Keep <= 0 {
+			continue
+		}
+		for _, seg := range listStoreSegments(self.dir, t) {
+			if seg.end.Before(now.Add(-t.Keep)) {
+				netLog.Infoln("删除过期的存储文件:", seg.path)
+				os.Remove(seg.path)
+			}
+		}
+	}
+}
+
+type storeSegment struct {
+	path  string
+	start time.Time
+	end   time.Time
+	// 重启时新建的文件
+	restart bool
+	modTime time.Time
+}
+
+// 某一级的所有分段文件，从早到晚
+func listStoreSegments(dir string, tier *storeTier) []storeSegment {
+	var files, _ = ioutil.ReadDir(filepath.Join(dir, tier.Name))
+	var ret []storeSegment
+	for _, f := range files {
+		var name = f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, storeSegmentExt) {
+			continue
+		}
+		// 重启时新建的文件，名字后面带着时间
+		var parts = strings.SplitN(strings.TrimSuffix(name, storeSegmentExt), ".", 2)
+		var start, err = time.ParseInLocation(tier.Layout, parts[0], time.UTC)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, storeSegment{path: filepath.Join(dir, tier.Name, name), start: start, end: tier.segmentEnd(start),
+			restart: len(parts) > 1, modTime: f.ModTime()})
+	}
+	// 同一个时间段里，原来的文件在前，重启时新建的按写入的先后
+	sort.Slice(ret, func(i, j int) bool {
+		var a, b = ret[i], ret[j]
+		if !a.start.Equal(b.start) {
+			return a.start.Before(b.start)
+		}
+		if a.restart != b.restart {
+			return !a.restart
+		}
+		if !a.modTime.Equal(b.modTime) {
+			return a.modTime.Before(b.modTime)
+		}
+		return a.path < b.path
+	})
+	return ret
+}
+
+// 文件最后一条记录是不是完整的
+func storeSegmentIntact(path string) bool {
+	return readStoreSegment(path, func(payload []byte) {}) == nil
+}
+
+// 读取一个分段文件里的所有记录，遇到损坏的记录时停止
+func readStoreSegment(path string, fn func(payload []byte)) error {
+	var f, err = os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r = bufio.NewReaderSize(f, 64*1024)
+	var magic = make([]byte, len(storeMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != string(storeMagic) {
+		return errStoreCorrupt
+	}
+
+	var head [4]byte
+	for {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errStoreCorrupt
+		}
+		var n = binary.LittleEndian.Uint32(head[:])
+		if n > 1<<20 {
+			return errStoreCorrupt
+		}
+		var payload = make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return errStoreCorrupt
+		}
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return errStoreCorrupt
+		}
+		if binary.LittleEndian.Uint32(head[:]) != crc32.ChecksumIEEE(payload) {
+			return errStoreCorrupt
+		}
+		fn(payload)
+	}
+}
+
+// 读取[from, to)之间某一级的记录
+func readStoreTier(dir string, tier *storeTier, from, to int64, fn func(d *storeDecoder, kind int64)) {
+	// 记录按写入时间放到文件里，汇总要等时间段结束后才写入，所以多读一个时间段
+	var slack = tier.Span + storeRollupGrace
+	var fromTime = time.Unix(0, from*int64(time.Millisecond))
+	var toTime = time.Unix(0, to*int64(time.Millisecond)).Add(slack)
+
+	for _, seg := range listStoreSegments(dir, tier) {
+		if !seg.end.After(fromTime) || !seg.start.Before(toTime) {
+			continue
+		}
+		var err = readStoreSegment(seg.path, func(payload []byte) {
+			var d = &storeDecoder{b: payload}
+			fn(d, d.Varint())
+		})
+		if err != nil {
+			netLog.Warnln("读取存储文件出错:", seg.path, err.Error())
+		}
+	}
+}
+
+// 读取[from, to)之间发出的探测包记录
+func readProbeRecords(dir string, from, to int64, fn func(rec *probeRecord)) {
+	readStoreTier(dir, findStoreTier(defaultStoreTiers, storeTierRaw), from, to, func(d *storeDecoder, kind int64) {
+		if kind != storeRecordProbe {
+			return
+		}
+		var rec = decodeProbeRecord(d)
+		if d.err == nil && rec.SendTime >= from && rec.SendTime < to {
+			fn(rec)
+		}
+	})
+}
+
+// 读取[from, to)之间的汇总记录，tier是1m或者1h
+// 同一个目标同一个时间段的记录合并成一条（进程重启前后各写了一部分），按时间顺序返回
+func readRollupRecords(dir string, tier string, from, to int64, fn func(rec *rollupRecord)) {
+	var t = findStoreTier(defaultStoreTiers, tier)
+	if t == nil {
+		return
+	}
+	var byKey = make(map[string]*rollupRecord)
+	var all []*rollupRecord
+	readStoreTier(dir, t, from, to, func(d *storeDecoder, kind int64) {
+		if kind != storeRecordRollup {
+			return
+		}
+		var rec = decodeRollupRecord(d)
+		if d.err != nil || rec.Time < from || rec.Time >= to {
+			return
+		}
+		var key = fmt.Sprintf("%s\x00%d", rec.Target, rec.Time)
+		if v, ok := byKey[key]; ok {
+			v.Merge(rec)
+			return
+		}
+		byKey[key] = rec
+		all = append(all, rec)
+	})
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Time < all[j].Time
+	})
+	for _, rec := range all {
+		fn(rec)
+	}
+}
+
+var resultStore *probeStore
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func tempStoreDir(t *testing.T) string {
+	var dir, err = ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func storeMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func TestStoreRecordRoundTrip(t *testing.T) {
+	var dir = tempStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	var now = time.Date(2026, 10, 18, 3, 30, 0, 0, time.UTC)
+	var probes = []*probeRecord{
+		{Target: "game1", Proto: "tcp", Seq: 1, SendTime: storeMs(now), RecvTime: storeMs(now) + 12, Rtt: 12, Size: 40, Result: EProbeOnTime},
+		{Target: "game1", Proto: "tcp", Seq: 2, SendTime: storeMs(now) + 100, Rtt: 3000, Size: 40, Result: EProbeLost},
+		{Target: "游戏2", Proto: "udp", Seq: probeIdModulo - 1, SendTime: storeMs(now) + 200, RecvTime: storeMs(now) + 1200, Rtt: 1000, Result: EProbeLate},
+	}
+	var rollup = &rollupRecord{Time: storeMs(now), Span: 60000, Target: "game1", Proto: "tcp",
+		Counts:  probeCounts{Received: 10, Lost: 2, Late: 1, Duplicate: 1, Reorder: 3, Corrupt: 1, Unsent: 4},
+		RttSum:  120,
+		Latency: latencySummary{Count: 10, Min: 5, Max: 30, Avg: 12, P50: 11, P90: 20, P99: 30, P999: 30}}
+
+	var raw = &storeWriter{tier: findStoreTier(defaultStoreTiers, storeTierRaw), dir: dir}
+	for _, p := range probes {
+		if err := raw.Write(now, p.encode()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw.Close()
+	var minute = &storeWriter{tier: findStoreTier(defaultStoreTiers, storeTierMinute), dir: dir}
+	minute.Write(now, rollup.encode())
+	minute.Close()
+
+	var got []*probeRecord
+	readProbeRecords(dir, storeMs(now), storeMs(now)+1000, func(rec *probeRecord) {
+		got = append(got, rec)
+	})
+	if !reflect.DeepEqual(got, probes) {
+		t.Fatalf("probes = %+v, want %+v", got, probes)
+	}
+
+	var rollups []*rollupRecord
+	readRollupRecords(dir, storeTierMinute, storeMs(now), storeMs(now)+60000, func(rec *rollupRecord) {
+		rollups = append(rollups, rec)
+	})
+	if len(rollups) != 1 || !reflect.DeepEqual(rollups[0], rollup) {
+		t.Fatalf("rollups = %+v, want %+v", rollups, rollup)
+	}
+}
+
+func TestStoreTruncatedTail(t *testing.T) {
+	var dir = tempStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	var now = time.Date(2026, 10, 18, 3, 30, 0, 0, time.UTC)
+	var tier = findStoreTier(defaultStoreTiers, storeTierRaw)
+	var w = &storeWriter{tier: tier, dir: dir}
+	for i := int32(1); i <= 3; i++ {
+		w.Write(now, (&probeRecord{Target: "a", Seq: i, SendTime: storeMs(now) + int64(i)}).encode())
+	}
+	w.Close()
+
+	// 最后一条记录写了一半
+	var path = filepath.Join(dir, tier.Name, "20261018-03"+storeSegmentExt)
+	var info, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := readStoreSegment(path, func(payload []byte) { n++ }); err != errStoreCorrupt || n != 2 {
+		t.Fatalf("truncated segment: %d records, err %v", n, err)
+	}
+
+	// 重启后写到新的文件里，读取时两个文件都读
+	w = &storeWriter{tier: tier, dir: dir}
+	w.Write(now, (&probeRecord{Target: "a", Seq: 4, SendTime: storeMs(now) + 4}).encode())
+	w.Close()
+	if segs := listStoreSegments(dir, tier); len(segs) != 2 {
+		t.Fatalf("segments = %+v, want 2", segs)
+	}
+	var seqs []int32
+	readProbeRecords(dir, storeMs(now), storeMs(now)+1000, func(rec *probeRecord) {
+		seqs = append(seqs, rec.Seq)
+	})
+	if !reflect.DeepEqual(seqs, []int32{1, 2, 4}) {
+		t.Fatalf("seqs = %v, want [1 2 4]", seqs)
+	}
+}
+
+func TestStoreRetention(t *testing.T) {
+	var dir = tempStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	var now = time.Date(2026, 10, 18, 3, 30, 0, 0, time.UTC)
+	var files = map[string]bool{
+		// raw保留2天，分段结束在2天以前的删除
+		"raw/20261016-02.seg": false,
+		"raw/20261016-03.seg": true,
+		"raw/20261018-03.seg": true,
+		// 1m保留3天
+		"1m/20261014.seg": false,
+		"1m/20261015.seg": true,
+		// 1h保留1天，这个月的文件还没结束
+		"1h/202609.seg": false,
+		"1h/202610.seg": true,
+		// 不是分段文件的不动
+		"1m/notes.txt": true,
+	}
+	for name := range files {
+		var path = filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		ioutil.WriteFile(path, storeMagic, 0666)
+	}
+
+	newProbeStore(dir, newStoreTiers(2, 3, 1)).expire(now)
+	for name, keep := range files {
+		var _, err = os.Stat(filepath.Join(dir, name))
+		if keep != (err == nil) {
+			t.Errorf("%s: kept %v, want %v", name, err == nil, keep)
+		}
+	}
+}
+
+func TestConfigStoreTiers(t *testing.T) {
+	var tiers = configStoreTiers(&GlobalConfig{StoreRawDays: 3, StoreHourDays: 90})
+	var want = []time.Duration{3 * 24 * time.Hour, 30 * 24 * time.Hour, 90 * 24 * time.Hour}
+	for i, tier := range tiers {
+		if tier.Keep != want[i] {
+			t.Errorf("%s keep %v, want %v", tier.Name, tier.Keep, want[i])
+		}
+	}
+}
+
+func TestStoreLateRecordNotRolledUpTwice(t *testing.T) {
+	var dir = tempStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	var s = newProbeStore(dir, defaultStoreTiers)
+	// raw文件按写入的时间命名，用这一小时里的时间
+	var minute = time.Now().Truncate(time.Hour).Add(10 * time.Minute)
+	s.write(&probeRecord{Target: "a", SendTime: storeMs(minute) + 1, Rtt: 10, Result: EProbeOnTime})
+	s.flushBuckets(minute.Add(time.Minute+storeRollupGrace), false)
+	// 这一分钟已经写入了，晚到的记录只写入raw
+	s.write(&probeRecord{Target: "a", SendTime: storeMs(minute) + 2, Rtt: 20, Result: EProbeLate})
+	// 下一分钟照常汇总
+	s.write(&probeRecord{Target: "a", SendTime: storeMs(minute) + 60000, Rtt: 30, Result: EProbeOnTime})
+	s.flushBuckets(minute.Add(2*time.Minute), true)
+	for _, w := range s.writers {
+		w.Close()
+	}
+
+	var rollups []*rollupRecord
+	readStoreTier(dir, findStoreTier(defaultStoreTiers, storeTierMinute), storeMs(minute), storeMs(minute)+120000, func(d *storeDecoder, kind int64) {
+		rollups = append(rollups, decodeRollupRecord(d))
+	})
+	if len(rollups) != 2 || rollups[0].Counts.Received != 1 || rollups[1].Time != storeMs(minute)+60000 {
+		t.Fatalf("rollups = %+v", rollups)
+	}
+	if s.late != 1 {
+		// 这一小时还没有写入，只有1m丢掉了
+		t.Fatalf("late = %d, want 1", s.late)
+	}
+
+	var raw int
+	readProbeRecords(dir, storeMs(minute), storeMs(minute)+120000, func(rec *probeRecord) { raw++ })
+	if raw != 3 {
+		t.Fatalf("raw records = %d, want 3", raw)
+	}
+}
+
+func TestReadRollupRecordsMerges(t *testing.T) {
+	var dir = tempStoreDir(t)
+	defer os.RemoveAll(dir)
+
+	var now = time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)
+	var w = &storeWriter{tier: findStoreTier(defaultStoreTiers, storeTierMinute), dir: dir}
+	// 重启前后同一分钟各写了一条
+	w.Write(now, (&rollupRecord{Time: storeMs(now), Span: 60000, Target: "a", Counts: probeCounts{Received: 3, Lost: 1}, RttSum: 30,
+		Latency: latencySummary{Count: 3, Min: 5, Max: 20, P50: 10, P90: 20, P99: 20, P999: 20}}).encode())
+	w.Write(now, (&rollupRecord{Time: storeMs(now) + 60000, Span: 60000, Target: "a", Counts: probeCounts{Received: 1}}).encode())
+	w.Write(now, (&rollupRecord{Time: storeMs(now), Span: 60000, Target: "a", Counts: probeCounts{Received: 1}, RttSum: 50,
+		Latency: latencySummary{Count: 1, Min: 50, Max: 50, P50: 50, P90: 50, P99: 50, P999: 50}}).encode())
+	w.Close()
+
+	var got []*rollupRecord
+	readRollupRecords(dir, storeTierMinute, storeMs(now), storeMs(now)+120000, func(rec *rollupRecord) {
+		got = append(got, rec)
+	})
+	if len(got) != 2 || got[0].Time != storeMs(now) || got[1].Time != storeMs(now)+60000 {
+		t.Fatalf("rollups = %+v", got)
+	}
+	var l = got[0].Latency
+	if got[0].Counts.Received != 4 || got[0].Counts.Lost != 1 || l.Count != 4 || l.Min != 5 || l.Max != 50 || l.Avg != 20 || l.P50 != 20 {
+		t.Fatalf("merged = %+v", got[0])
+	}
+}
+
+// 判定丢包以后又回来的包还是算丢包，不能同时算成收到
+func TestStoreBucketLostThenLate(t *testing.T) {
+	var cases = []struct {
+		results []EProbeResult
+		want    probeCounts
+		rtts    int64
+	}{
+		{[]EProbeResult{EProbeLost, EProbeLostLate}, probeCounts{Lost: 1, Late: 1}, 0},
+		{[]EProbeResult{EProbeLate, EProbeLost}, probeCounts{Received: 1, Lost: 1, Late: 1}, 1},
+	}
+	for _, c := range cases {
+		var bucket = &storeBucket{Rtt: newHistogram()}
+		for _, r := range c.results {
+			bucket.Add(&probeRecord{Result: r, Rtt: 3100})
+		}
+		if bucket.Counts != c.want || bucket.Rtt.count != c.rtts {
+			t.Errorf("%v: counts %+v rtts %d, want %+v %d", c.results, bucket.Counts, bucket.Rtt.count, c.want, c.rtts)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:28
+ * Comment: 探测目标，一个客户端进程可以同时探测多个目标
+ *          每个目标是一个[target.名字]的配置段，没有写的字段沿用[main]里的值
+ */
+
+package main
+
+import (
+	"gopkg.in/ini.v1"
+	"strings"
+)
+
+const targetSectionPrefix = "target."
+
+type TargetConfig struct {
+	// 目标的名字，用在日志和汇报中
+	Name string `ini:"-"`
+
+	// 协议类型(TCP, UDP)
+	Proto string
+
+	// 服务器地址
+	ServerAddr string
+
+	// 最大等待时间（毫秒）
+	MaxWaitTime int64
+
+	// 探测包超时时间（毫秒）
+	ProbeTimeout int64
+
+	// 每个数据包额外带多少数据
+	StuffingCount int
+
+	// 发包间隔（毫秒）
+	ProbeInterval int64
+
+	// 每次连续发几个包
+	ProbeBurst int
+
+	// 发包间隔的分布（fixed, poisson）
+	ProbeMode string
+
+	// 轮换使用的附带数据大小（字节）
+	ProbeSizes string
+}
+
+// 用[main]里的值作为默认值
+func newTargetConfig(name string, cfg *GlobalConfig) *TargetConfig {
+	return &TargetConfig{
+		Name:          name,
+		Proto:         cfg.Proto,
+		ServerAddr:    cfg.ServerAddr,
+		MaxWaitTime:   cfg.MaxWaitTime,
+		ProbeTimeout:  cfg.ProbeTimeout,
+		StuffingCount: cfg.StuffingCount,
+		ProbeInterval: cfg.ProbeInterval,
+		ProbeBurst:    cfg.ProbeBurst,
+		ProbeMode:     cfg.ProbeMode,
+		ProbeSizes:    cfg.ProbeSizes,
+	}
+}
+
+// 读取所有的[target.xxx]配置段，一个都没有时，[main]本身就是唯一的目标
+func loadTargets(iniCfg *ini.File, cfg *GlobalConfig) ([]*TargetConfig, error) {
+	var targets, err = loadTargetSections(iniCfg, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(targets) == 0 {
+		targets = append(targets, newTargetConfig(cfg.ServerAddr, cfg))
+	}
+
+	return targets, nil
+}
+
+// 所有的[target.xxx]配置段
+func loadTargetSections(iniCfg *ini.File, cfg *GlobalConfig) ([]*TargetConfig, error) {
+	var targets []*TargetConfig
+
+	for _, section := range iniCfg.Sections() {
+		if !strings.HasPrefix(section.Name(), targetSectionPrefix) {
+			continue
+		}
+
+		var t = newTargetConfig(strings.TrimPrefix(section.Name(), targetSectionPrefix), cfg)
+		if err := section.MapTo(t); err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// 所有目标的名字
+func targetNames(targets []*TargetConfig) string {
+	var names []string
+	for _, t := range targets {
+		names = append(names, t.Name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 多个目标：没写的字段沿用[main]，没有[target.xxx]时[main]本身是唯一的目标，名字不能重复
+func TestLoadTargets(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	type target struct {
+		name     string
+		proto    string
+		addr     string
+		interval int64
+		timeout  int64
+	}
+	var cases = []struct {
+		name   string
+		text   string
+		want   []target
+		issues []string
+	}{
+		{"main.ini", `[main]
+Proto = tcp
+ServerAddr = 127.0.0.1:20201
+ProbeInterval = 500
+`, []target{{"127.0.0.1:20201", "tcp", "127.0.0.1:20201", 500, 0}}, nil},
+
+		{"targets.ini", `[main]
+Proto = tcp
+ProbeInterval = 500
+ProbeTimeout = 2000
+[target.game1]
+ServerAddr = 1.2.3.4:1
+[target.game2]
+Proto = udp
+ServerAddr = 1.2.3.4:2
+ProbeInterval = 100
+`, []target{{"game1", "tcp", "1.2.3.4:1", 500, 2000}, {"game2", "udp", "1.2.3.4:2", 100, 2000}}, nil},
+
+		// [main]的ServerAddr也是[target.xxx]的默认地址
+		{"inherit-addr.ini", `[main]
+Proto = udp
+ServerAddr = 127.0.0.1:20201
+[target.local]
+ProbeTimeout = 1000
+`, []target{{"local", "udp", "127.0.0.1:20201", 0, 1000}}, nil},
+
+		{"targets.yaml", `Proto: tcp
+ProbeInterval: 500
+targets:
+  - Name: game1
+    ServerAddr: 1.2.3.4:1
+  - Name: game2
+    ServerAddr: 1.2.3.4:2
+    ProbeTimeout: 1000
+`, []target{{"game1", "tcp", "1.2.3.4:1", 500, 0}, {"game2", "tcp", "1.2.3.4:2", 500, 1000}}, nil},
+
+		{"duplicate.ini", `[main]
+Proto = tcp
+[target.game1]
+ServerAddr = 1.2.3.4:1
+[target.game1]
+ProbeInterval = 100
+`, nil, []string{"重复的配置段"}},
+
+		{"duplicate.yaml", `Proto: tcp
+targets:
+  - Name: game1
+    ServerAddr: 1.2.3.4:1
+  - Name: game1
+    ServerAddr: 1.2.3.4:2
+`, nil, []string{"重名: game1"}},
+
+		{"empty-name.ini", `[main]
+Proto = tcp
+[target.]
+ServerAddr = 1.2.3.4:1
+`, nil, []string{"需要名字"}},
+	}
+	for _, c := range cases {
+		var path = filepath.Join(dir, c.name)
+		ioutil.WriteFile(path, []byte(c.text), 0666)
+		var cfg, err = parseConfig(path, map[string]string{"Role": "1"})
+		if len(c.issues) > 0 {
+			for _, issue := range c.issues {
+				if err == nil || !strings.Contains(err.Error(), issue) {
+					t.Errorf("%s: err = %v, want %s", c.name, err, issue)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		var targets = cfg.global.Targets
+		if len(targets) != len(c.want) {
+			t.Errorf("%s: targets %s", c.name, targetNames(targets))
+			continue
+		}
+		for i, w := range c.want {
+			var got = target{targets[i].Name, targets[i].Proto, targets[i].ServerAddr, targets[i].ProbeInterval, targets[i].ProbeTimeout}
+			if got != w {
+				t.Errorf("%s: target %d = %+v, want %+v", c.name, i, got, w)
+			}
+		}
+	}
+}
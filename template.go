@@ -0,0 +1,258 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 03:36
+ * Comment: 通知的模板，使用Go的text/template和html/template
+ *          TemplateDir里有 subject.tmpl, body.txt.tmpl, body.html.tmpl 时，使用这些文件代替默认的模板
+ *          日报和周报的模板文件名前面加 digest.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const defaultSubjectTemplate = `network-profiler:{{.LocalIp}} [{{upper .Event.State}}][{{.Event.Severity}}] {{.Event.Target}}: {{.Event.Rule}}`
+
+const defaultTextTemplate = `{{.Event.Expr}} {{if eq .Event.State "resolved"}}已恢复，当前值={{printf "%.2f" .Event.Value}}，持续了{{.Event.Duration}}{{else}}当前值={{printf "%.2f" .Event.Value}}{{end}}
+时间：{{.Time.Format "2006-01-02 15:04:05"}}  本机：{{.LocalIp}}
+{{range .Targets}}
+== {{.Name}} ({{.Proto}} {{.Addr}}) {{if .Connected}}已连接{{else}}未连接{{end}}
+{{range .Windows}}[{{window .Window}}] 丢包率={{printf "%.2f" .Counts.LossPercent}}% 延迟 min={{.Latency.Min}} avg={{printf "%.1f" .Latency.Avg}} p50={{.Latency.P50}} p90={{.Latency.P90}} p99={{.Latency.P99}} p99.9={{.Latency.P999}} max={{.Latency.Max}}
+{{end}}抖动={{printf "%.1f" .Jitter}}ms 时钟偏差={{.ClockOffset}}ms
+最近一小时延迟：{{sparkline .RttSeries}}
+最近一小时丢包：{{sparkline .LossSeries}}
+{{end}}`
+
+const defaultHtmlTemplate = `<p><b>{{.Event.Expr}}</b>
+{{if eq .Event.State "resolved"}}已恢复，当前值={{printf "%.2f" .Event.Value}}，持续了{{.Event.Duration}}{{else}}当前值={{printf "%.2f" .Event.Value}}{{end}}</p>
+<p>时间：{{.Time.Format "2006-01-02 15:04:05"}}　本机：{{.LocalIp}}</p>
+{{range .Targets}}
+<h3>{{.Name}} ({{.Proto}} {{.Addr}}) {{if .Connected}}已连接{{else}}<span style="color:#c00">未连接</span>{{end}}</h3>
+<table border="1" cellspacing="0" cellpadding="4" style="border-collapse:collapse">
+<tr><th>窗口</th><th>丢包率</th><th>min</th><th>avg</th><th>p50</th><th>p90</th><th>p99</th><th>p99.9</th><th>max</th><th>std</th></tr>
+{{range .Windows}}<tr><td>{{window .Window}}</td><td>{{printf "%.2f" .Counts.LossPercent}}%</td><td>{{.Latency.Min}}</td><td>{{printf "%.1f" .Latency.Avg}}</td><td>{{.Latency.P50}}</td><td>{{.Latency.P90}}</td><td>{{.Latency.P99}}</td><td>{{.Latency.P999}}</td><td>{{.Latency.Max}}</td><td>{{printf "%.1f" .Latency.StdDev}}</td></tr>
+{{end}}</table>
+<p>抖动={{printf "%.1f" .Jitter}}ms　时钟偏差={{.ClockOffset}}ms</p>
+<p>最近一小时延迟(p50)：<br>{{svgchart .RttSeries "#36c"}}<br><span style="font-family:monospace">{{sparkline .RttSeries}}</span></p>
+<p>最近一小时丢包率：<br>{{svgchart .LossSeries "#c33"}}<br><span style="font-family:monospace">{{sparkline .LossSeries}}</span></p>
+{{end}}`
+
+// 模板中可以使用的告警信息
+type templateEvent struct {
+	State    string
+	Severity string
+	Rule     string
+	Expr     string
+	Target   string
+	Value    float64
+	Duration time.Duration
+}
+
+// 模板的数据
+type templateData struct {
+	LocalIp string
+	Time    time.Time
+	Event   templateEvent
+	// 告警相关的目标，没有目标时是所有目标
+	Targets []*statsSnapshot
+	// 所有目标
+	All []*statsSnapshot
+}
+
+var sparkChars = []rune("▁▂▃▄▅▆▇█")
+
+// 用字符画的小曲线，负数表示没有数据，画成空格
+func sparkline(values []float64) string {
+	var max float64
+	for _, v := range values {
+		max = math.Max(max, v)
+	}
+
+	var buf []rune
+	for _, v := range values {
+		if v < 0 {
+			buf = append(buf, ' ')
+			continue
+		}
+		var idx = 0
+		if max > 0 {
+			idx = int(v / max * float64(len(sparkChars)-1))
+		}
+		buf = append(buf, sparkChars[idx])
+	}
+	return string(buf)
+}
+
+// 内嵌在html里的svg折线图，负数表示没有数据
+func svgChart(values []float64, color string) htmltemplate.HTML {
+	const width, height = 360, 60
+
+	var max float64
+	for _, v := range values {
+		max = math.Max(max, v)
+	}
+	if max <= 0 {
+		max = 1
+	}
+
+	// 连续有数据的一段画成一条折线，只有一个点的画成点
+	var points []string
+	var lines []string
+	for i, v := range values {
+		if v < 0 {
+			if len(points) > 0 {
+				lines = append(lines, strings.Join(points, " "))
+			}
+			points = nil
+			continue
+		}
+		var x = float64(i) * width / math.Max(float64(len(values)-1), 1)
+		var y = height - v/max*(height-4) - 2
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	if len(points) > 0 {
+		lines = append(lines, strings.Join(points, " "))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" style="background:#f6f6f6">`, width, height)
+	color = htmltemplate.HTMLEscapeString(color)
+	for _, l := range lines {
+		if !strings.Contains(l, " ") {
+			var xy = strings.Split(l, ",")
+			fmt.Fprintf(&buf, `<circle cx="%s" cy="%s" r="1.5" fill="%s"/>`, xy[0], xy[1], color)
+			continue
+		}
+		fmt.Fprintf(&buf, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`, color, l)
+	}
+	fmt.Fprintf(&buf, `<text x="2" y="10" font-size="9" fill="#666">max %.1f</text></svg>`, max)
+	return htmltemplate.HTML(buf.String())
+}
+
+var templateFuncs = map[string]interface{}{
+	"sparkline": sparkline,
+	"svgchart":  svgChart,
+	"window":    windowName,
+	"upper":     strings.ToUpper,
+	"change":    formatChange,
+}
+
+// 和上一个周期比较的变化量，负数表示没有数据
+func formatChange(cur, prev float64) string {
+	if cur < 0 || prev < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.2f", cur-prev)
+}
+
+type notifyTemplates struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// 读取模板，文件不存在时使用默认的模板
+func loadNotifyTemplates(dir string) (*notifyTemplates, error) {
+	return loadTemplateSet(dir, "", defaultSubjectTemplate, defaultTextTemplate, defaultHtmlTemplate)
+}
+
+// 读取一组模板，文件名是 prefix + subject.tmpl, body.txt.tmpl, body.html.tmpl
+func loadTemplateSet(dir, prefix, subjectDef, textDef, htmlDef string) (*notifyTemplates, error) {
+	var read = func(name, def string) string {
+		if len(dir) == 0 {
+			return def
+		}
+		var data, err = ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				netLog.Warnln("读取模板失败，使用默认模板:", name, err.Error())
+			}
+			return def
+		}
+		return string(data)
+	}
+
+	var ret = &notifyTemplates{}
+	var err error
+	if ret.subject, err = texttemplate.New(prefix + "subject.tmpl").Funcs(templateFuncs).Parse(read(prefix+"subject.tmpl", subjectDef)); err != nil {
+		return nil, err
+	}
+	if ret.text, err = texttemplate.New(prefix + "body.txt.tmpl").Funcs(templateFuncs).Parse(read(prefix+"body.txt.tmpl", textDef)); err != nil {
+		return nil, err
+	}
+	if ret.html, err = htmltemplate.New(prefix + "body.html.tmpl").Funcs(templateFuncs).Parse(read(prefix+"body.html.tmpl", htmlDef)); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// 执行模板，返回标题、文本和html
+func (self *notifyTemplates) execute(data interface{}) (string, string, string, error) {
+	var subject, text, html bytes.Buffer
+	if err := self.subject.Execute(&subject, data); err != nil {
+		return "", "", "", err
+	}
+	if err := self.text.Execute(&text, data); err != nil {
+		return "", "", "", err
+	}
+	if err := self.html.Execute(&html, data); err != nil {
+		return "", "", "", err
+	}
+	return strings.TrimSpace(subject.String()), text.String(), html.String(), nil
+}
+
+// 用模板生成通知
+func (self *notifyTemplates) Render(data *templateData) (*Notification, error) {
+	var subject, text, html, err = self.execute(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notification{
+		Subject:  subject,
+		Text:     text,
+		Html:     html,
+		Severity: data.Event.Severity,
+		Target:   data.Event.Target,
+		Time:     data.Time.UnixNano() / int64(time.Millisecond),
+	}, nil
+}
+
+var templates *notifyTemplates
+
+// 告警的通知
+func newAlertTemplateData(localIp string, ev *alertEvent, snaps []*statsSnapshot) *templateData {
+	var data = &templateData{
+		LocalIp: localIp,
+		Time:    time.Unix(0, ev.Time*int64(time.Millisecond)),
+		Event: templateEvent{
+			State:    ev.State,
+			Severity: ev.Rule.Severity,
+			Rule:     ev.Rule.Name,
+			Expr:     ev.Rule.Expr,
+			Target:   ev.Target,
+			Value:    ev.Value,
+			Duration: time.Duration(ev.Duration) * time.Millisecond,
+		},
+		All: snaps,
+	}
+	for _, v := range snaps {
+		if v.Name == ev.Target {
+			data.Targets = append(data.Targets, v)
+		}
+	}
+	if len(data.Targets) == 0 {
+		data.Targets = snaps
+	}
+	return data
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSparkline(t *testing.T) {
+	var cases = []struct {
+		values []float64
+		want   string
+	}{
+		{nil, ""},
+		{[]float64{0, 0}, "▁▁"},
+		// 负数是没有数据
+		{[]float64{0, -1, 7, 3.5}, "▁ █▄"},
+		{[]float64{-1, -1}, "  "},
+	}
+	for _, c := range cases {
+		if got := sparkline(c.values); got != c.want {
+			t.Errorf("sparkline(%v) = %q, want %q", c.values, got, c.want)
+		}
+	}
+}
+
+func TestSvgChart(t *testing.T) {
+	var cases = []struct {
+		values   []float64
+		contains []string
+		lines    int
+		points   int
+	}{
+		// 中间断开，前面两个点是折线，后面一个点画成点
+		{[]float64{1, 2, -1, 3}, []string{`points="0.0,39.3 120.0,20.7"`, `<circle cx="360.0" cy="2.0"`, "max 3.0"}, 1, 1},
+		{[]float64{1, 2, 3}, []string{`points="0.0,39.3 180.0,20.7 360.0,2.0"`}, 1, 0},
+		{[]float64{-1, 2, -1, 3, 1}, nil, 1, 1},
+		{[]float64{-1, -1}, []string{"max 1.0"}, 0, 0},
+		{nil, []string{"<svg", "</svg>"}, 0, 0},
+	}
+	for _, c := range cases {
+		var got = string(svgChart(c.values, "#36c"))
+		for _, s := range c.contains {
+			if !strings.Contains(got, s) {
+				t.Errorf("svgChart(%v) = %s, want %s", c.values, got, s)
+			}
+		}
+		if n := strings.Count(got, "<polyline"); n != c.lines {
+			t.Errorf("svgChart(%v): %d lines, want %d", c.values, n, c.lines)
+		}
+		if n := strings.Count(got, "<circle"); n != c.points {
+			t.Errorf("svgChart(%v): %d points, want %d", c.values, n, c.points)
+		}
+	}
+
+	if got := string(svgChart([]float64{1, 2}, `"><script>`)); strings.Contains(got, "<script>") {
+		t.Errorf("color not escaped: %s", got)
+	}
+}
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	var dir, err = ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadTemplateSet(t *testing.T) {
+	var data = &templateData{
+		LocalIp: "10.0.0.1",
+		Time:    time.Unix(0, 0),
+		Event:   templateEvent{State: "firing", Severity: "warning", Rule: "loss", Expr: "loss > 2%", Target: "t1", Value: 5},
+	}
+	var cases = []struct {
+		name    string
+		files   map[string]string
+		prefix  string
+		subject string
+		err     bool
+	}{
+		{"default", nil, "", "network-profiler:10.0.0.1 [FIRING][warning] t1: loss", false},
+		// 只替换一个文件，其他的用默认模板
+		{"override subject", map[string]string{"subject.tmpl": "{{.Event.Target}} {{upper .Event.State}}"}, "", "t1 FIRING", false},
+		// 日报的模板要加前缀，没有前缀的文件不用
+		{"prefix", map[string]string{"subject.tmpl": "plain", "digest.subject.tmpl": "digest {{.Event.Target}}"}, "digest.", "digest t1", false},
+		{"other prefix", map[string]string{"subject.tmpl": "plain"}, "digest.", "network-profiler:10.0.0.1 [FIRING][warning] t1: loss", false},
+		{"malformed", map[string]string{"body.html.tmpl": "{{.Event.Target"}, "", "", true},
+		{"unknown func", map[string]string{"body.txt.tmpl": "{{nope .Event.Target}}"}, "", "", true},
+	}
+	for _, c := range cases {
+		var dir = writeTemplates(t, c.files)
+		defer os.RemoveAll(dir)
+
+		var set, err = loadTemplateSet(dir, c.prefix, defaultSubjectTemplate, defaultTextTemplate, defaultHtmlTemplate)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: want an error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		var n, renderErr = set.Render(data)
+		if renderErr != nil {
+			t.Errorf("%s: %v", c.name, renderErr)
+			continue
+		}
+		if n.Subject != c.subject {
+			t.Errorf("%s: subject %q, want %q", c.name, n.Subject, c.subject)
+		}
+		if !strings.Contains(n.Text, "loss > 2% 当前值=5.00") || !strings.Contains(n.Html, "<b>loss &gt; 2%</b>") {
+			t.Errorf("%s: default body not used:\n%s\n%s", c.name, n.Text, n.Html)
+		}
+	}
+}
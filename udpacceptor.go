@@ -0,0 +1,175 @@
+/**
+ * Auth :   liubo
+ * Date :   2026/10/18 04:27
+ * Comment: 服务器端的udp侦听器，和cellnet的udp.Acceptor一样收发udp.ltv的封包，
+ *          区别是会话提供RemoteAddr，并且每个会话有自己的ID，用来区分和统计每个客户端
+ *          cellnet的udp会话拿不到对方的地址，ID也都是0
+ *          客户端超过udpSessionTTL没有发包，再发包时算新的会话
+ */
+
+package main
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/davyxu/cellnet"
+	"github.com/davyxu/cellnet/peer"
+)
+
+const (
+	udpAcceptorType = "udp.AddrAcceptor"
+	// 和cellnet的udp.Acceptor一样
+	udpMaxRecvBuffer = 2048
+	udpSessionTTL    = time.Minute
+	// 会话数超过这个值时清理过期的会话
+	udpSessionGCThreshold = 100
+)
+
+type udpAddrAcceptor struct {
+	peer.CorePeerProperty
+	peer.CoreContextSet
+	peer.CoreRunningTag
+	peer.CoreProcBundle
+	peer.CoreCaptureIOPanic
+
+	conn *net.UDPConn
+
+	sessionMutex sync.Mutex
+	sessions     map[string]*udpAddrSession
+	nextId       int64
+}
+
+type udpAddrSession struct {
+	*peer.CoreProcBundle
+	peer.CoreContextSet
+
+	acceptor *udpAddrAcceptor
+	id       int64
+	remote   *net.UDPAddr
+	pkt      []byte
+	expire   time.Time
+}
+
+func (self *udpAddrSession) Raw() interface{}       { return self }
+func (self *udpAddrSession) Peer() cellnet.Peer     { return self.acceptor }
+func (self *udpAddrSession) ID() int64              { return self.id }
+func (self *udpAddrSession) Close()                 {}
+func (self *udpAddrSession) RemoteAddr() net.Addr   { return self.remote }
+func (self *udpAddrSession) LocalAddress() net.Addr { return self.acceptor.conn.LocalAddr() }
+
+// udp.ltv的处理器从这里读取收到的封包
+func (self *udpAddrSession) ReadData() []byte {
+	return self.pkt
+}
+
+// udp.ltv的处理器通过这里发送封包
+func (self *udpAddrSession) WriteData(data []byte) {
+	self.acceptor.conn.WriteToUDP(data, self.remote)
+}
+
+func (self *udpAddrSession) Send(msg interface{}) {
+	self.SendMessage(&cellnet.SendMsgEvent{Ses: self, Msg: msg})
+}
+
+func (self *udpAddrSession) recv(data []byte) {
+	self.pkt = data
+	var msg, err = self.ReadMessage(self)
+	if msg != nil && err == nil {
+		self.ProcEvent(&cellnet.RecvMsgEvent{Ses: self, Msg: msg})
+	}
+}
+
+func (self *udpAddrAcceptor) TypeName() string {
+	return udpAcceptorType
+}
+
+func (self *udpAddrAcceptor) Start() cellnet.Peer {
+	var addr, err = net.ResolveUDPAddr("udp", self.Address())
+	if err == nil {
+		self.conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		netLog.Errorln("udp侦听失败:", self.Name(), self.Address(), err.Error())
+		return self
+	}
+	netLog.Infoln("udp listen:", self.Name(), self.conn.LocalAddr().String())
+
+	self.SetRunning(true)
+	go self.accept(self.conn)
+	return self
+}
+
+func (self *udpAddrAcceptor) Stop() {
+	if self.conn != nil {
+		self.conn.Close()
+	}
+	self.SetRunning(false)
+}
+
+func (self *udpAddrAcceptor) accept(conn *net.UDPConn) {
+	var buf = make([]byte, udpMaxRecvBuffer)
+	for {
+		var n, remote, err = conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		if n == 0 {
+			continue
+		}
+		// 处理器解码时会复制，缓冲区可以重复使用
+		var ses = self.session(remote, time.Now())
+		if self.CaptureIOPanic() {
+			self.protectedRecv(ses, buf[:n])
+		} else {
+			ses.recv(buf[:n])
+		}
+	}
+	self.SetRunning(false)
+}
+
+func (self *udpAddrAcceptor) protectedRecv(ses *udpAddrSession, data []byte) {
+	defer func() {
+		if err := recover(); err != nil {
+			netLog.Errorln("udp IO panic:", err)
+			self.conn.Close()
+		}
+	}()
+	ses.recv(data)
+}
+
+// 按对方的地址找到会话，过期了换一个新的会话
+func (self *udpAddrAcceptor) session(remote *net.UDPAddr, now time.Time) *udpAddrSession {
+	self.sessionMutex.Lock()
+	defer self.sessionMutex.Unlock()
+
+	if len(self.sessions) > udpSessionGCThreshold {
+		for key, ses := range self.sessions {
+			if now.After(ses.expire) {
+				delete(self.sessions, key)
+			}
+		}
+	}
+
+	var key = remote.String()
+	var ses = self.sessions[key]
+	if ses == nil || now.After(ses.expire) {
+		ses = &udpAddrSession{
+			CoreProcBundle: &self.CoreProcBundle,
+			acceptor:       self,
+			id:             atomic.AddInt64(&self.nextId, 1),
+			remote:         remote,
+		}
+		self.sessions[key] = ses
+	}
+	ses.expire = now.Add(udpSessionTTL)
+	return ses
+}
+
+func init() {
+	peer.RegisterPeerCreator(func() cellnet.Peer {
+		return &udpAddrAcceptor{sessions: make(map[string]*udpAddrSession)}
+	})
+}
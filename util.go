@@ -15,8 +15,12 @@ import (
 func TimeNowMs() int64 {
 	return time.Now().UnixNano() / int64(time.Millisecond)
 }
+// 探测包的Id在0到probeIdModulo-1之间循环
+// 以前是10000，发包快的时候（10ms间隔、多个包突发）不到超时时间就会回绕
+const probeIdModulo = 1 << 30
+
 func AddId(id int32) int32 {
-	return (id + 1) % 10000
+	return (id + 1) % probeIdModulo
 }
 
 func CheckPanic(logger *golog.Logger) {